@@ -20,6 +20,8 @@ import (
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/anton2920/go/lab/NN/nn"
 )
 
 type Neuron struct {
@@ -104,10 +106,12 @@ func (s *SOM) FindBMU(inputs []float32) int {
 	return bmuIndex
 }
 
-func (s *SOM) Train(trainingData [][]float32, ninputs int, width, height int, nrows, ncols int, maxCount int, startingRate float32) {
+/* Train trains SOM on maxCount random rows of trainingData. Schedules depending on loss observe mean quantization error of every len(trainingData) rows. */
+func (s *SOM) Train(trainingData [][]float32, ninputs int, width, height int, nrows, ncols int, maxCount int, schedule nn.Schedule) {
+	var quantizationError float32
 	var count int
 
-	rate := startingRate
+	schedule.Reset()
 	neuronWidth := float32(width) / float32(ncols)
 	neuronHeight := float32(height) / float32(nrows)
 	mapRadius := float32(max(width, height)) / 2
@@ -133,7 +137,9 @@ func (s *SOM) Train(trainingData [][]float32, ninputs int, width, height int, nr
 		inputs := trainingData[rand.Int()%len(trainingData)]
 		bmuIndex := s.FindBMU(inputs)
 		bmu := &s.Neurons[bmuIndex]
+		quantizationError += float32(math.Sqrt(float64(bmu.DistanceTo(inputs))))
 
+		rate := schedule.At(count)
 		neighbourhoodRadius := mapRadius * float32(math.Exp(float64(-count)/float64(timeConstant)))
 
 		for i := 0; i < len(s.Neurons); i++ {
@@ -148,8 +154,13 @@ func (s *SOM) Train(trainingData [][]float32, ninputs int, width, height int, nr
 			}
 		}
 
-		rate = startingRate * float32(math.Exp(float64(-count)/float64(maxCount)))
 		count++
+		if count%len(trainingData) == 0 {
+			if o, ok := schedule.(nn.LossObserver); ok {
+				o.Observe(quantizationError / float32(len(trainingData)))
+			}
+			quantizationError = 0
+		}
 	}
 }
 
@@ -288,7 +299,7 @@ func IndexHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		som.MinVector, som.MaxVector = NormalizeTrainingData(trainingData)
-		som.Train(trainingData, Ninputs, ImageWidth, ImageHeight, NRows, NCols, 5000, 0.1)
+		som.Train(trainingData, Ninputs, ImageWidth, ImageHeight, NRows, NCols, 5000, nn.NewExponentialDecay(0.1, 5000))
 		som.Trained = true
 
 		img := image.NewRGBA(image.Rect(0, 0, ImageWidth, ImageHeight))
@@ -5,6 +5,8 @@ import (
 	"math/rand"
 	"os"
 	"testing"
+
+	"github.com/anton2920/go/lab/NN/nn"
 )
 
 const (
@@ -13,21 +15,22 @@ const (
 )
 
 var (
-	testNN           NN
-	testTrainingData [][]float32
+	testNN      nn.NN
+	testInputs  [][]float32
+	testOutputs [][]float32
 )
 
 func BenchmarkNeuronTrain(b *testing.B) {
-	nn := NN{
-		Layers: []Layer{
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
+	network := nn.NN{
+		Layers: []nn.Layer{
+			{Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionTh},
 		},
 	}
 
 	for i := 0; i < b.N; i++ {
-		if err := nn.Train(testTrainingData, Ninputs, 0.05, 50000); err != nil {
+		if _, err := network.Train(testInputs, testOutputs, 1, 1, nn.NewSGD(0.05), nil, EPS, 50000); err != nil {
 			b.Fatalf("Failed to train NN: %s", err.Error())
 		}
 	}
@@ -45,11 +48,7 @@ func testCity(t *testing.T, city [7]float32) {
 	}
 
 	for i := 0; i < len(inputs); i++ {
-		for j := 0; j < len(inputs[i]); j++ {
-			inputs[i][j] = (inputs[i][j] - 0.5*(testNN.MaxVector[j]+testNN.MinVector[j])) / (0.5 * (testNN.MaxVector[j] - testNN.MinVector[j]))
-		}
-
-		outputs := testNN.Query(inputs[i])
+		outputs := testNN.Predict(inputs[i])
 		for i, output := range outputs {
 			if math.Abs(float64(output-city[2+i])) > EPS {
 				t.Errorf("Neuron failed to predict city at [%f; %f]: expected %.2f, got %.2f", inputs[i][0], inputs[i][1], city[2], output)
@@ -78,25 +77,42 @@ func TestTula(t *testing.T) {
 	testCity(t, [...]float32{54.1961, 37.6182, -1, -1, -1, -1, 1})
 }
 
-func TestMain(m *testing.M) {
-	var err error
+func TestGradients(t *testing.T) {
+	network := nn.NN{
+		Layers: []nn.Layer{
+			{Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionTh},
+		},
+	}
+	network.Init(Ninputs, rand.New(rand.NewSource(nn.Seed)))
 
-	testNN = NN{
-		Layers: []Layer{
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
+	if err := network.CheckGradients(testInputs[:4], testOutputs[:4], 1e-2); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMain(m *testing.M) {
+	testNN = nn.NN{
+		Layers: []nn.Layer{
+			{Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionTh},
 		},
 	}
 
-	testTrainingData, err = ReadTrainingData(TrainingFile)
+	trainingData, err := nn.ReadTrainingData(TrainingFile)
 	if err != nil {
 		Fatalf("Failed to read training data: %s\n", err.Error())
 	}
 
-	testNN.MinVector, testNN.MaxVector = NormalizeTrainingData(testTrainingData, Ninputs)
+	testNN.InputScaler = nn.NewScaler(nn.ScalerMinMax11, nn.ColumnRange(0, Ninputs)...)
+	if err := testNN.InputScaler.FitTransform(trainingData); err != nil {
+		Fatalf("Failed to scale training data: %s\n", err.Error())
+	}
+	testInputs, testOutputs = nn.SplitTrainingData(trainingData, Ninputs)
 
-	if err := testNN.Train(testTrainingData, Ninputs, 0.05, 50000); err != nil {
+	if _, err := testNN.Train(testInputs, testOutputs, 1, 1, nn.NewSGD(0.05), nil, EPS, 50000); err != nil {
 		Fatalf("Failed to train NN: %s\n", err.Error())
 	}
 
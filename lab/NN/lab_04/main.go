@@ -1,44 +1,15 @@
 package main
 
 import (
-	"encoding/csv"
-	"encoding/gob"
 	"flag"
 	"fmt"
-	"math"
-	"math/rand"
 	"os"
-	"strconv"
-	"strings"
-)
-
-type ActivationFunction func(float32) float32
-
-type Neuron struct {
-	Weights         []float32
-	PreviousWeights []float32
-	Bias            float32
-}
-
-type Layer struct {
-	Neurons    []Neuron
-	Outputs    []float32
-	FunctionID int
-}
-
-type NN struct {
-	Layers    []Layer
-	MinVector []float32
-	MaxVector []float32
-	Trained   bool
-}
 
-const (
-	FunctionSigmoid = iota
-	FunctionTh
-	FunctionReLU
+	"github.com/anton2920/go/lab/NN/nn"
 )
 
+var Cities = []string{"Bryansk", "Orel", "Smolensk", "Kaluga", "Tula"}
+
 const (
 	Ninputs      = 2
 	TrainingFile = "training.csv"
@@ -46,351 +17,42 @@ const (
 	EPS          = 1e-1
 )
 
-var (
-	Functions = []ActivationFunction{
-		Sigmoid,
-		Th,
-		ReLU,
-	}
-
-	Derivatives = []ActivationFunction{
-		SigmoidPrime,
-		ThPrime,
-		ReLUPrime,
-	}
-)
-
-func Sigmoid(x float32) float32 {
-	return 1 / (1 + float32(math.Exp(float64(-x))))
-}
-
-func SigmoidPrime(x float32) float32 {
-	return x * (1 - x)
-}
-
-func Th(x float32) float32 {
-	return float32(math.Tanh(float64(x)))
-}
-
-func ThPrime(x float32) float32 {
-	return 1 - x*x
-}
-
-func ReLU(x float32) float32 {
-	if x < 0 {
-		x *= 0.01
-	}
-	return x
-}
-
-func ReLUPrime(x float32) float32 {
-	if x < 0 {
-		return 0.01
-	} else {
-		return 1
-	}
-}
-
-func (n *Neuron) Query(inputs []float32) float32 {
-	var output float32
-
-	for i := 0; i < len(inputs); i++ {
-		output += inputs[i] * n.Weights[i]
-	}
-	output += n.Bias
-
-	return output
-}
-
-func (l *Layer) Query(inputs []float32) []float32 {
-	if l.Outputs == nil {
-		l.Outputs = make([]float32, len(l.Neurons))
-	}
-
-	for n := 0; n < len(l.Neurons); n++ {
-		neuron := &l.Neurons[n]
-		l.Outputs[n] = Functions[l.FunctionID](neuron.Query(inputs))
-	}
-	return l.Outputs
-}
-
-func (nn *NN) Load(filename string) error {
-	f, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	gobDecoder := gob.NewDecoder(f)
-	if err := gobDecoder.Decode(&nn); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (nn *NN) Store(filename string) error {
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	gobEncoder := gob.NewEncoder(f)
-	if err := gobEncoder.Encode(&nn); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (nn *NN) Query(inputs []float32) []float32 {
-	outputs := inputs
-	for l := 0; l < len(nn.Layers); l++ {
-		layer := &nn.Layers[l]
-		outputs = layer.Query(outputs)
-	}
-	return outputs
-}
-
-func (nn *NN) Train(trainingData [][]float32, ninputs int, trainingRate float32, maxTrainingCount int) (int, error) {
-	var done, needsTraining bool
-	var count int
-
-	rng := rand.New(rand.NewSource(6585))
-	for l := 0; l < len(nn.Layers); l++ {
-		layer := &nn.Layers[l]
-
-		for n := 0; n < len(layer.Neurons); n++ {
-			neuron := &layer.Neurons[n]
-
-			var nweights int
-			if l == 0 {
-				nweights = Ninputs
-			} else {
-				nweights = len(nn.Layers[l-1].Neurons)
-			}
-			neuron.Weights = make([]float32, nweights)
-
-			for w := 0; w < len(neuron.Weights); w++ {
-				neuron.Weights[w] = (rng.Float32() - 0.5) / 10
-			}
-			neuron.Bias = (rng.Float32() - 0.5) / 10
-
-			neuron.PreviousWeights = make([]float32, len(neuron.Weights))
-			copy(neuron.PreviousWeights, neuron.Weights)
-		}
-	}
-
-	for !done {
-		if count > maxTrainingCount {
-			return 0, fmt.Errorf("count exceeded %d", maxTrainingCount)
-		}
-
-		done = true
-		for _, row := range trainingData {
-			inputs := row[:ninputs]
-			correctOutputs := row[ninputs:]
-
-			outputs := nn.Query(inputs)
-
-			// fmt.Println(inputs, correctOutputs, outputs)
-
-			needsTraining = false
-			for j := 0; j < len(correctOutputs); j++ {
-				if math.Abs(float64(correctOutputs[j]-outputs[j])) > EPS {
-					done = false
-					needsTraining = true
-					break
-				}
-			}
-
-			if needsTraining {
-				var coef, prevCoef []float32
-				for l := len(nn.Layers) - 1; l >= 0; l-- {
-					layer := &nn.Layers[l]
-
-					coef = make([]float32, len(layer.Neurons))
-					for n := 0; n < len(layer.Neurons); n++ {
-						if l == len(nn.Layers)-1 {
-							coef[n] = Derivatives[layer.FunctionID](layer.Outputs[n]) * (correctOutputs[n] - outputs[n])
-						} else {
-							var temp float32
-							nextLayer := &nn.Layers[l+1]
-							for i := 0; i < len(nextLayer.Neurons); i++ {
-								temp += prevCoef[i] * nextLayer.Neurons[i].Weights[n]
-							}
-							coef[n] = Derivatives[layer.FunctionID](layer.Outputs[n]) * temp
-						}
-
-						neuron := &layer.Neurons[n]
-						for w := 0; w < len(neuron.Weights); w++ {
-							currWeight := &neuron.Weights[w]
-							prevWeight := &neuron.PreviousWeights[w]
-
-							if l == 0 {
-								*currWeight += trainingRate*coef[n]*inputs[w] + 0.5*(*currWeight-*prevWeight)
-							} else {
-								prevLayer := &nn.Layers[l-1]
-								*currWeight += trainingRate*coef[n]*prevLayer.Outputs[w] + 0.5*(*currWeight-*prevWeight)
-							}
-
-							*prevWeight = *currWeight
-						}
-						neuron.Bias += trainingRate * coef[n]
-					}
-
-					prevCoef = coef
-				}
-			}
-		}
-
-		count++
-	}
-
-	return count, nil
-}
-
 func Fatalf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, args...)
 	os.Exit(1)
 }
 
-func GenerateTrainingDataRow(csvWriter *csv.Writer, row []string, basis [][]float32, ninputs int, i int, maxOffset float32) error {
-	var j int
-	for ; j < ninputs; j++ {
-		row[j] = strconv.FormatFloat(float64(basis[i][j]+maxOffset*rand.Float32()), 'f', 4, 32)
-	}
-
-	for ; j < len(basis[i]); j++ {
-		row[j] = strconv.Itoa(int(basis[i][j]))
-	}
-
-	if err := csvWriter.Write(row); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func GenerateTrainingData(trainingFilename string, basis [][]float32, maxOffset float32, ninputs, count int) error {
-	f, err := os.Create(trainingFilename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	csvWriter := csv.NewWriter(f)
-	defer csvWriter.Flush()
-
-	row := make([]string, len(basis[0]))
-	for i := 0; i < len(basis); i++ {
-		if err := GenerateTrainingDataRow(csvWriter, row, basis, ninputs, i, maxOffset); err != nil {
-			return err
-		}
-	}
-
-	for k := 0; k < count-len(basis); k++ {
-		i := rand.Int() % len(basis)
-		if err := GenerateTrainingDataRow(csvWriter, row, basis, ninputs, i, maxOffset); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func ReadTrainingData(trainingFile string) ([][]float32, error) {
-	f, err := os.Open(trainingFile)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	csvReader := csv.NewReader(f)
-	trainingStrings, err := csvReader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
-
-	trainingData := make([][]float32, len(trainingStrings))
-	for i := 0; i < len(trainingData); i++ {
-		trainingData[i] = make([]float32, len(trainingStrings[i]))
-		for j := 0; j < len(trainingStrings[i]); j++ {
-			value, err := strconv.ParseFloat(strings.TrimSpace(trainingStrings[i][j]), 32)
-			if err != nil {
-				return nil, err
-			}
-			trainingData[i][j] = float32(value)
-		}
-	}
-
-	return trainingData, nil
-}
-
-func NormalizeTrainingData01(trainingData [][]float32, ninputs int) ([]float32, []float32) {
-	minVector := make([]float32, ninputs)
-	maxVector := make([]float32, ninputs)
-
-	for j := 0; j < ninputs; j++ {
-		minVector[j] = trainingData[0][j]
-		maxVector[j] = trainingData[0][j]
-	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			minVector[j] = min(minVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-			maxVector[j] = max(maxVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-		}
-	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			trainingData[i][j] = (trainingData[i][j] - minVector[j]) / (maxVector[j] - minVector[j])
-			// trainingData[i][j] = (trainingData[i][j] - 0.5*(maxVector[j]+minVector[j])) / (0.5 * (maxVector[j] - minVector[j]))
-		}
-	}
-
-	return minVector, maxVector
-}
-
-func NormalizeTrainingData11(trainingData [][]float32, ninputs int) ([]float32, []float32) {
-	minVector := make([]float32, ninputs)
-	maxVector := make([]float32, ninputs)
-
-	for j := 0; j < ninputs; j++ {
-		minVector[j] = trainingData[0][j]
-		maxVector[j] = trainingData[0][j]
-	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			minVector[j] = min(minVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-			maxVector[j] = max(maxVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-		}
+/* NewNetwork returns untrained NN which gives probability of every city for coordinates. */
+func NewNetwork() nn.NN {
+	return nn.NN{
+		Layers: []nn.Layer{
+			{Neurons: 5, FunctionID: nn.FunctionIdentity, Initializer: nn.XavierUniform},
+			{Kind: nn.LayerLayerNorm, Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionIdentity, Initializer: nn.XavierUniform},
+			{Kind: nn.LayerLayerNorm, Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionIdentity, Initializer: nn.XavierUniform},
+			{Kind: nn.LayerLayerNorm, Neurons: 5, FunctionID: nn.FunctionTh},
+			{Neurons: 5, FunctionID: nn.FunctionSoftmax, Initializer: nn.XavierUniform},
+		},
+		LossID: nn.LossCrossEntropy,
 	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			// trainingData[i][j] = (trainingData[i][j] - minVector[j]) / (maxVector[j] - minVector[j])
-			trainingData[i][j] = (trainingData[i][j] - 0.5*(maxVector[j]+minVector[j])) / (0.5 * (maxVector[j] - minVector[j]))
-		}
-	}
-
-	return minVector, maxVector
 }
 
 func main() {
-	var nn NN
+	var network nn.NN
 
 	generationFlag := flag.Bool("g", false, "generate training data for NN")
 	trainingFlag := flag.Bool("t", false, fmt.Sprintf("train NN with data from '%s' file", TrainingFile))
+	jsonFile := flag.String("json", "", "export NN to JSON file")
+	onnxFile := flag.String("onnx", "", "export NN to ONNX file")
+	foldsFlag := flag.Int("cv", 0, "report accuracy of NN over the given number of cross-validation folds before training")
+	reportFile := flag.String("report", "", "write metrics of NN on test data to CSV file")
 	flag.Parse()
 
-	nn.Load(NetworkFile)
+	network.Load(NetworkFile)
 
 	if *generationFlag {
-		if err := GenerateTrainingData(TrainingFile, [][]float32{
+		if err := nn.GenerateTrainingData(TrainingFile, [][]float32{
 			{53.2521, 34.3717, 1, -1, -1, -1, -1}, /* Bryansk. */
 			{52.9651, 36.0785, -1, 1, -1, -1, -1}, /* Orel. */
 			{54.7818, 32.0401, -1, -1, 1, -1, -1}, /* Smolensk. */
@@ -401,48 +63,98 @@ func main() {
 		}
 	}
 
-	if (!nn.Trained) || (*trainingFlag) {
-		nn = NN{
-			Layers: []Layer{
-				{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-				{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-				{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-				{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-			},
-		}
-
-		trainingData, err := ReadTrainingData(TrainingFile)
+	if (!network.Trained) || (*trainingFlag) {
+		trainingData, err := nn.ReadTrainingData(TrainingFile)
 		if err != nil {
 			Fatalf("Failed to read training data: %s\n", err.Error())
 		}
 
-		nn.MinVector, nn.MaxVector = NormalizeTrainingData11(trainingData, Ninputs)
+		inputScaler := nn.NewScaler(nn.ScalerMinMax11, nn.ColumnRange(0, Ninputs)...)
+		if err := inputScaler.FitTransform(trainingData); err != nil {
+			Fatalf("Failed to scale training data: %s\n", err.Error())
+		}
+		dataset := nn.NewDataset(trainingData, Ninputs)
+
+		/* NOTE(anton2920): softmax outputs probabilities, so cities are encoded with 0 instead of -1. */
+		for i := 0; i < len(dataset.Outputs); i++ {
+			for j := 0; j < len(dataset.Outputs[i]); j++ {
+				dataset.Outputs[i][j] = max(dataset.Outputs[i][j], 0)
+			}
+		}
+
+		if *foldsFlag > 0 {
+			means, stddevs, err := dataset.CrossValidate(*foldsFlag, &nn.SplitOptions{Shuffle: true, Seed: nn.Seed, Stratified: true}, func(fold int, training, validation nn.Dataset) ([]float32, error) {
+				network := NewNetwork()
+				if _, err := network.Train(training.Inputs, training.Outputs, 1, 1, nn.NewSGD(0.1), nil, EPS, 100000); err != nil {
+					return nil, err
+				}
+				metrics := nn.Classification(network.QueryAll(validation.Inputs), validation.Outputs)
+				return []float32{metrics.Accuracy}, nil
+			})
+			if err != nil {
+				Fatalf("Failed to cross-validate NN: %s\n", err.Error())
+			}
+			fmt.Printf("Accuracy over %d folds: %.3f ± %.3f\n", *foldsFlag, means[0], stddevs[0])
+		}
+
+		training, _, test, err := dataset.Split(&nn.SplitOptions{TestSplit: 0.2, Shuffle: true, Seed: nn.Seed, Stratified: true})
+		if err != nil {
+			Fatalf("Failed to split training data: %s\n", err.Error())
+		}
 
-		count, err := nn.Train(trainingData, Ninputs, 0.1, 100000)
+		network = NewNetwork()
+		network.InputScaler = inputScaler
+		count, err := network.Train(training.Inputs, training.Outputs, 1, 1, nn.NewSGD(0.1), nil, EPS, 100000)
 		if err != nil {
 			Fatalf("Failed to train NN: %s\n", err.Error())
 		}
 		fmt.Printf("Trained after %d epochs\n", count)
 
-		nn.Trained = true
+		metrics := nn.Classification(network.QueryAll(test.Inputs), test.Outputs)
+		metrics.Names = Cities
+		if err := metrics.WriteText(os.Stdout); err != nil {
+			Fatalf("Failed to write metrics: %s\n", err.Error())
+		}
+		if *reportFile != "" {
+			f, err := os.Create(*reportFile)
+			if err != nil {
+				Fatalf("Failed to create report: %s\n", err.Error())
+			}
+			if err := metrics.WriteCSV(f); err != nil {
+				Fatalf("Failed to write report: %s\n", err.Error())
+			}
+			f.Close()
+		}
+
+		network.Trained = true
 
-		if err := nn.Store(NetworkFile); err != nil {
+		if err := network.Store(NetworkFile); err != nil {
 			Fatalf("Failed to store NN: %s\n", err.Error())
 		}
 	}
 
-	if !nn.Trained {
+	if !network.Trained {
 		Fatalf("NN must be trained before it can process data\n")
 	}
 
+	if *jsonFile != "" {
+		if err := network.StoreJSON(*jsonFile); err != nil {
+			Fatalf("Failed to export NN to JSON: %s\n", err.Error())
+		}
+	}
+	if *onnxFile != "" {
+		if err := network.StoreONNX(*onnxFile); err != nil {
+			Fatalf("Failed to export NN to ONNX: %s\n", err.Error())
+		}
+	}
+
 	inputs := make([]float32, 2)
 	for i := 0; i < len(inputs); i++ {
 		fmt.Printf("Type value %d: ", i+1)
 		_, _ = fmt.Scanf("%f", &inputs[i])
-		inputs[i] = (inputs[i] - 0.5*(nn.MaxVector[i]+nn.MinVector[i])) / (0.5 * (nn.MaxVector[i] - nn.MinVector[i]))
 	}
 
-	for i, output := range nn.Query(inputs) {
-		fmt.Printf("Answer from neuron #%d: %f\n", i, output)
+	for i, output := range network.Predict(inputs) {
+		fmt.Printf("Probability of %s: %f\n", Cities[i], output)
 	}
 }
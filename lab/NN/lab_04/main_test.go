@@ -2,10 +2,12 @@ package main
 
 import (
 	"fmt"
-	"math"
 	"math/rand"
 	"os"
+	"runtime"
 	"testing"
+
+	"github.com/anton2920/go/lab/NN/nn"
 )
 
 const (
@@ -14,15 +16,17 @@ const (
 )
 
 var (
-	testNN             NN
-	testTrainingData11 [][]float32
-	testTrainingData01 [][]float32
+	testNN        nn.NN
+	testInputs11  [][]float32
+	testOutputs11 [][]float32
+	testInputs01  [][]float32
+	testOutputs01 [][]float32
 )
 
-func benchmarkNeuronTrain(b *testing.B, nlayers, nneurons int, trainingRate float32, functionID int, trainingData [][]float32) {
+func benchmarkNeuronTrain(b *testing.B, nlayers, nneurons int, trainingRate float32, functionID int, batchSize, workers int, inputs, outputs [][]float32) {
 	var count int
 	var err error
-	var nn NN
+	var network nn.NN
 
 	b.Helper()
 
@@ -30,15 +34,15 @@ func benchmarkNeuronTrain(b *testing.B, nlayers, nneurons int, trainingRate floa
 		b.Fatalf("Number of layers must be at least 2 (provided %d)", nlayers)
 	}
 
-	nn.Layers = append(nn.Layers, Layer{Neurons: make([]Neuron, nneurons), FunctionID: functionID})
+	network.Layers = append(network.Layers, nn.Layer{Neurons: nneurons, FunctionID: functionID})
 	for i := 0; i < nlayers-2; i++ {
-		nn.Layers = append(nn.Layers, Layer{Neurons: make([]Neuron, nneurons), FunctionID: functionID})
+		network.Layers = append(network.Layers, nn.Layer{Neurons: nneurons, FunctionID: functionID})
 	}
-	nn.Layers = append(nn.Layers, Layer{Neurons: make([]Neuron, 5), FunctionID: functionID})
+	network.Layers = append(network.Layers, nn.Layer{Neurons: 5, FunctionID: functionID})
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		count, err = nn.Train(trainingData, Ninputs, trainingRate, 50000)
+		count, err = network.Train(inputs, outputs, batchSize, workers, nn.NewSGD(trainingRate), nil, EPS, 50000)
 		if err != nil {
 			b.Fatalf("Failed to train NN: %s", err.Error())
 		}
@@ -50,15 +54,17 @@ func BenchmarkNeuronTrain(b *testing.B) {
 	fts := [...]struct {
 		FuncName string
 		Func     int
-		Data     [][]float32
+		Inputs   [][]float32
+		Outputs  [][]float32
 	}{
-		{"tanh", FunctionTh, testTrainingData11},
-		{"sigmoid", FunctionSigmoid, testTrainingData01},
+		{"tanh", nn.FunctionTh, testInputs11, testOutputs11},
+		{"sigmoid", nn.FunctionSigmoid, testInputs01, testOutputs01},
 	}
 
 	for _, ft := range fts {
 		f := ft.Func
-		t := ft.Data
+		in := ft.Inputs
+		out := ft.Outputs
 		name := ft.FuncName
 
 		for l := 2; l <= 5; l++ {
@@ -66,7 +72,7 @@ func BenchmarkNeuronTrain(b *testing.B) {
 				for r := 0.05; r <= 0.4; r *= 2 {
 					b.Run(fmt.Sprintf("%dlayers,%dneurons,%.2frate,%s", l, n, r, name), func(b *testing.B) {
 						b.Helper()
-						benchmarkNeuronTrain(b, l, n, float32(r), f, t)
+						benchmarkNeuronTrain(b, l, n, float32(r), f, 1, 1, in, out)
 					})
 				}
 			}
@@ -74,6 +80,15 @@ func BenchmarkNeuronTrain(b *testing.B) {
 	}
 }
 
+func BenchmarkNeuronTrainParallel(b *testing.B) {
+	for w := 1; w <= 2*runtime.NumCPU(); w *= 2 {
+		b.Run(fmt.Sprintf("3layers,20neurons,0.10rate,tanh,%dworkers", w), func(b *testing.B) {
+			b.Helper()
+			benchmarkNeuronTrain(b, 3, 20, 0.1, nn.FunctionTh, 0, w, testInputs11, testOutputs11)
+		})
+	}
+}
+
 func testRandomFrom(x float32, maxOffset float32) float32 {
 	return x + maxOffset*rand.Float32()
 }
@@ -85,16 +100,11 @@ func testCity(t *testing.T, city [7]float32) {
 		inputs = append(inputs, []float32{testRandomFrom(city[0], MaxOffset), testRandomFrom(city[1], MaxOffset)})
 	}
 
+	expected := nn.Class(city[2:])
 	for i := 0; i < len(inputs); i++ {
-		for j := 0; j < len(inputs[i]); j++ {
-			inputs[i][j] = (inputs[i][j] - 0.5*(testNN.MaxVector[j]+testNN.MinVector[j])) / (0.5 * (testNN.MaxVector[j] - testNN.MinVector[j]))
-		}
-
-		outputs := testNN.Query(inputs[i])
-		for i, output := range outputs {
-			if math.Abs(float64(output-city[2+i])) > 1e-1 {
-				t.Errorf("Neuron failed to predict city at [%f; %f]: expected %.2f, got %.2f", inputs[i][0], inputs[i][1], city[2], output)
-			}
+		outputs := testNN.Predict(inputs[i])
+		if actual := nn.Class(outputs); actual != expected {
+			t.Errorf("Neuron failed to predict city at [%f; %f]: expected %s, got %s with probabilities %.2f", inputs[i][0], inputs[i][1], Cities[expected], Cities[actual], outputs)
 		}
 	}
 }
@@ -119,37 +129,61 @@ func TestTula(t *testing.T) {
 	testCity(t, [...]float32{54.1961, 37.6182, -1, -1, -1, -1, 1})
 }
 
-func TestMain(m *testing.M) {
-	var err error
+func TestGradients(t *testing.T) {
+	for _, test := range [...]struct {
+		FunctionID      int
+		Inputs, Outputs [][]float32
+	}{
+		{nn.FunctionTh, testInputs11, testOutputs11},
+		{nn.FunctionSigmoid, testInputs01, testOutputs01},
+	} {
+		t.Run(nn.FunctionNames[test.FunctionID], func(t *testing.T) {
+			network := nn.NN{
+				Layers: []nn.Layer{
+					{Neurons: 5, FunctionID: test.FunctionID},
+					{Neurons: 5, FunctionID: test.FunctionID},
+					{Neurons: 5, FunctionID: test.FunctionID},
+				},
+			}
+			network.Init(Ninputs, rand.New(rand.NewSource(nn.Seed)))
 
-	testNN = NN{
-		Layers: []Layer{
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-			{Neurons: make([]Neuron, 5), FunctionID: FunctionTh},
-		},
+			if err := network.CheckGradients(test.Inputs[:4], test.Outputs[:4], 1e-2); err != nil {
+				t.Error(err)
+			}
+		})
 	}
+}
 
-	testTrainingData11, err = ReadTrainingData(TrainingFile)
+func TestMain(m *testing.M) {
+	testNN = NewNetwork()
+
+	trainingData11, err := nn.ReadTrainingData(TrainingFile)
 	if err != nil {
 		Fatalf("Failed to read training data: %s\n", err.Error())
 	}
-	testNN.MinVector, testNN.MaxVector = NormalizeTrainingData11(testTrainingData11, Ninputs)
+	testNN.InputScaler = nn.NewScaler(nn.ScalerMinMax11, nn.ColumnRange(0, Ninputs)...)
+	if err := testNN.InputScaler.FitTransform(trainingData11); err != nil {
+		Fatalf("Failed to scale training data: %s\n", err.Error())
+	}
+	testInputs11, testOutputs11 = nn.SplitTrainingData(trainingData11, Ninputs)
 
-	testTrainingData01, err = ReadTrainingData(TrainingFile)
+	trainingData01, err := nn.ReadTrainingData(TrainingFile)
 	if err != nil {
 		Fatalf("Failed to read training data: %s\n", err.Error())
 	}
-	NormalizeTrainingData01(testTrainingData01, Ninputs)
-	for i := 0; i < len(testTrainingData01); i++ {
-		for j := Ninputs; j < len(testTrainingData01[i]); j++ {
-			if testTrainingData01[i][j] < 0 {
-				testTrainingData01[i][j] = 0
+	if err := nn.NewScaler(nn.ScalerMinMax01, nn.ColumnRange(0, Ninputs)...).FitTransform(trainingData01); err != nil {
+		Fatalf("Failed to scale training data: %s\n", err.Error())
+	}
+	for i := 0; i < len(trainingData01); i++ {
+		for j := Ninputs; j < len(trainingData01[i]); j++ {
+			if trainingData01[i][j] < 0 {
+				trainingData01[i][j] = 0
 			}
 		}
 	}
+	testInputs01, testOutputs01 = nn.SplitTrainingData(trainingData01, Ninputs)
 
-	if _, err := testNN.Train(testTrainingData11, Ninputs, 0.1, 100000); err != nil {
+	if _, err := testNN.Train(testInputs11, testOutputs01, 1, 1, nn.NewSGD(0.1), nil, EPS, 100000); err != nil {
 		Fatalf("Failed to train NN: %s\n", err.Error())
 	}
 
@@ -4,8 +4,11 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math/rand"
 	"os"
 	"slices"
+
+	"github.com/anton2920/go/lab/NN/nn"
 )
 
 type Letter []int8
@@ -16,8 +19,14 @@ const (
 	LetterWidth      = 9
 	LetterHeight     = 9
 	LetterResolution = LetterWidth * LetterHeight
+
+	NoisyCopies = 20
+	NoisyPixels = 5
+	EPS         = 0.1
 )
 
+var LetterNames = []string{"A", "V", "P"}
+
 func DecodeImage(filepath string) (Letter, error) {
 	f, err := os.Open(fmt.Sprintf("%s/%s", LettersDir, filepath))
 	if err != nil {
@@ -103,6 +112,56 @@ func PrintLetter(letter Letter, width, height int) {
 	}
 }
 
+/* LetterInputs returns pixels of letter as inputs of NN. */
+func LetterInputs(letter Letter) []float32 {
+	inputs := make([]float32, len(letter))
+	for i := 0; i < len(letter); i++ {
+		inputs[i] = float32(letter[i])
+	}
+	return inputs
+}
+
+/* NewClassifier trains CNN to tell letters apart, including their copies with NoisyPixels random pixels inverted. */
+func NewClassifier(letters []Letter) (*nn.NN, error) {
+	var inputs, outputs [][]float32
+
+	network := nn.NN{
+		Layers: []nn.Layer{
+			{Kind: nn.LayerConv2D, Neurons: 4, FunctionID: nn.FunctionReLU, Height: LetterHeight, Width: LetterWidth, Kernel: 3, Initializer: nn.HeUniform},
+			{Kind: nn.LayerMaxPool2D, Height: LetterHeight - 2, Width: LetterWidth - 2, Kernel: 2},
+			{Kind: nn.LayerFlatten},
+			{Neurons: len(letters), FunctionID: nn.FunctionSoftmax, Initializer: nn.XavierUniform},
+		},
+		LossID: nn.LossCrossEntropy,
+	}
+
+	rng := rand.New(rand.NewSource(nn.Seed))
+	for k, letter := range letters {
+		output := make([]float32, len(letters))
+		output[k] = 1
+
+		for c := 0; c <= NoisyCopies; c++ {
+			input := LetterInputs(letter)
+			if c > 0 {
+				for p := 0; p < NoisyPixels; p++ {
+					i := rng.Intn(len(input))
+					input[i] = -input[i]
+				}
+			}
+			inputs = append(inputs, input)
+			outputs = append(outputs, output)
+		}
+	}
+
+	count, err := network.Train(inputs, outputs, 1, 1, nn.NewAdam(0.01), nil, EPS, 10000)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Trained CNN after %d epochs\n", count)
+
+	return &network, nil
+}
+
 func main() {
 	letters := []Letter{
 		MustDecode(DecodeImage("LetterA.bmp")),
@@ -128,6 +187,12 @@ func main() {
 		MustDecode(DecodeImage("LetterP_test_inv.bmp")),
 	}
 
+	/* NOTE(anton2920): Hopfield network restores tests in place, so CNN gets their copies. */
+	testInputs := make([][]float32, len(tests))
+	for i, test := range tests {
+		testInputs[i] = LetterInputs(test)
+	}
+
 	const maxSteps = 5000
 	outputs := make([]int8, LetterResolution)
 	for i, test := range tests {
@@ -163,4 +228,15 @@ func main() {
 		PrintLetter(outputs, LetterWidth, LetterHeight)
 		fmt.Println()
 	}
+
+	/* NOTE(anton2920): CNN is not trained on inverted letters, so unlike Hopfield network it fails on the last test. */
+	classifier, err := NewClassifier(letters)
+	if err != nil {
+		Fatalf("Failed to train CNN: %s\n", err.Error())
+	}
+	for i, inputs := range testInputs {
+		outputs := classifier.Query(inputs)
+		class := nn.Class(outputs)
+		fmt.Printf("Test %d: CNN recognized letter %s with probability %.2f\n", i, LetterNames[class], outputs[class])
+	}
 }
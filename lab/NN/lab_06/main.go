@@ -8,11 +8,14 @@ import (
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"math"
 	"math/rand"
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/anton2920/go/lab/NN/nn"
 )
 
 type Neuron struct {
@@ -120,10 +123,12 @@ func (s *SOM) FindBMU(inputs []float32) int {
 	return bmuIndex
 }
 
-func (s *SOM) Train(trainingData [][]float32, ninputs int, width, height int, nrows, ncols int, maxCount int, startingRate float32) {
-	var count int
+/* Train trains SOM on maxCount rows of source, starting it over when it runs out of rows. Schedules depending on loss observe mean quantization error of every pass over source. */
+func (s *SOM) Train(source nn.Source, ninputs int, width, height int, nrows, ncols int, maxCount int, schedule nn.Schedule) error {
+	var quantizationError float32
+	var count, nsamples int
 
-	rate := startingRate
+	schedule.Reset()
 	neuronWidth := float32(width) / float32(ncols)
 	neuronHeight := float32(height) / float32(nrows)
 	mapRadius := float32(max(width, height)) / 2
@@ -146,10 +151,27 @@ func (s *SOM) Train(trainingData [][]float32, ninputs int, width, height int, nr
 	}
 
 	for count < maxCount {
-		inputs := trainingData[rand.Int()%len(trainingData)]
+		inputs, err := source.Next()
+		if err == io.EOF {
+			if o, ok := schedule.(nn.LossObserver); (ok) && (nsamples > 0) {
+				o.Observe(quantizationError / float32(nsamples))
+			}
+			quantizationError, nsamples = 0, 0
+
+			if err := source.Reset(); err != nil {
+				return err
+			}
+			inputs, err = source.Next()
+		}
+		if err != nil {
+			return err
+		}
 		bmuIndex := s.FindBMU(inputs)
 		bmu := &s.Neurons[bmuIndex]
+		quantizationError += float32(math.Sqrt(float64(bmu.DistanceTo(inputs))))
+		nsamples++
 
+		rate := schedule.At(count)
 		neighbourhoodRadius := mapRadius * float32(math.Exp(float64(-count)/float64(timeConstant)))
 
 		for i := 0; i < len(s.Neurons); i++ {
@@ -164,9 +186,10 @@ func (s *SOM) Train(trainingData [][]float32, ninputs int, width, height int, nr
 			}
 		}
 
-		rate = startingRate * float32(math.Exp(float64(-count)/float64(maxCount)))
 		count++
 	}
+
+	return nil
 }
 
 func (s *SOM) Render(img *image.RGBA) {
@@ -317,7 +340,10 @@ func main() {
 			Fatalf("Failed to draw training data: %s\n", err.Error())
 		}
 
-		som.Train(trainingData, Ninputs, ImageWidth, ImageHeight, NRows, NCols, 5000, 0.1)
+		source := nn.NewShuffleSource(nn.NewSliceSource(trainingData), len(trainingData), nn.Seed)
+		if err := som.Train(source, Ninputs, ImageWidth, ImageHeight, NRows, NCols, 5000, nn.NewExponentialDecay(0.1, 5000)); err != nil {
+			Fatalf("Failed to train SOM: %s\n", err.Error())
+		}
 		som.Trained = true
 
 		if err := som.Store(NetworkFile); err != nil {
@@ -0,0 +1,201 @@
+package nn
+
+import "math"
+
+/* ActivationFunction is applied to weighted sum of every neuron. Both methods take weighted sum x, not output of Activate. */
+type ActivationFunction interface {
+	Activate(x float32) float32
+	Derivative(x float32) float32
+}
+
+type Sigmoid struct{}
+
+type Th struct{}
+
+/* NOTE(anton2920): ReLU has always been slightly leaky, so NN with "relu" layers keep working. */
+type ReLU struct{}
+
+type Identity struct{}
+
+type LeakyReLU struct {
+	Alpha float32
+}
+
+type ELU struct {
+	Alpha float32
+}
+
+/* GELU is exact, x*Φ(x), where Φ is standard normal CDF. */
+type GELU struct{}
+
+type Softplus struct{}
+
+/* Swish is x*sigmoid(x), also known as SiLU. */
+type Swish struct{}
+
+const (
+	FunctionSigmoid = iota
+	FunctionTh
+	FunctionReLU
+	FunctionIdentity
+	FunctionSoftmax /* NOTE(anton2920): only valid for output layer. */
+	FunctionLeakyReLU
+	FunctionELU
+	FunctionGELU
+	FunctionSoftplus
+	FunctionSwish
+)
+
+var (
+	Functions = []ActivationFunction{
+		Sigmoid{},
+		Th{},
+		ReLU{},
+		Identity{},
+		Identity{}, /* NOTE(anton2920): softmax is applied to the whole layer by Layer.Query, its Jacobian is handled by NN.Backpropagate. */
+		LeakyReLU{Alpha: 0.1},
+		ELU{Alpha: 1},
+		GELU{},
+		Softplus{},
+		Swish{},
+	}
+
+	/* FunctionNames identify activation functions in model files, so they must not change. */
+	FunctionNames = []string{
+		"sigmoid",
+		"tanh",
+		"relu",
+		"identity",
+		"softmax",
+		"leaky-relu",
+		"elu",
+		"gelu",
+		"softplus",
+		"swish",
+	}
+)
+
+/* FunctionByName returns ID of activation function with the given name. */
+func FunctionByName(name string) (int, bool) {
+	for id := 0; id < len(FunctionNames); id++ {
+		if FunctionNames[id] == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+func sigmoid(x float32) float32 {
+	return 1 / (1 + float32(math.Exp(float64(-x))))
+}
+
+func (Sigmoid) Activate(x float32) float32 {
+	return sigmoid(x)
+}
+
+func (Sigmoid) Derivative(x float32) float32 {
+	y := sigmoid(x)
+	return y * (1 - y)
+}
+
+func (Th) Activate(x float32) float32 {
+	return float32(math.Tanh(float64(x)))
+}
+
+func (Th) Derivative(x float32) float32 {
+	y := float32(math.Tanh(float64(x)))
+	return 1 - y*y
+}
+
+func (ReLU) Activate(x float32) float32 {
+	return LeakyReLU{Alpha: 0.01}.Activate(x)
+}
+
+func (ReLU) Derivative(x float32) float32 {
+	return LeakyReLU{Alpha: 0.01}.Derivative(x)
+}
+
+func (Identity) Activate(x float32) float32 {
+	return x
+}
+
+func (Identity) Derivative(x float32) float32 {
+	return 1
+}
+
+func (f LeakyReLU) Activate(x float32) float32 {
+	if x < 0 {
+		x *= f.Alpha
+	}
+	return x
+}
+
+func (f LeakyReLU) Derivative(x float32) float32 {
+	if x < 0 {
+		return f.Alpha
+	} else {
+		return 1
+	}
+}
+
+func (f ELU) Activate(x float32) float32 {
+	if x < 0 {
+		x = f.Alpha * float32(math.Expm1(float64(x)))
+	}
+	return x
+}
+
+func (f ELU) Derivative(x float32) float32 {
+	if x < 0 {
+		return f.Alpha * float32(math.Exp(float64(x)))
+	} else {
+		return 1
+	}
+}
+
+func (GELU) Activate(x float32) float32 {
+	return float32(0.5 * float64(x) * (1 + math.Erf(float64(x)/math.Sqrt2)))
+}
+
+func (GELU) Derivative(x float32) float32 {
+	cdf := 0.5 * (1 + math.Erf(float64(x)/math.Sqrt2))
+	pdf := math.Exp(-0.5*float64(x)*float64(x)) / math.Sqrt(2*math.Pi)
+	return float32(cdf + float64(x)*pdf)
+}
+
+/* Activate returns log(1+exp(x)), computed without overflow for large x. */
+func (Softplus) Activate(x float32) float32 {
+	return float32(max(float64(x), 0) + math.Log1p(math.Exp(-math.Abs(float64(x)))))
+}
+
+func (Softplus) Derivative(x float32) float32 {
+	return sigmoid(x)
+}
+
+func (Swish) Activate(x float32) float32 {
+	return x * sigmoid(x)
+}
+
+func (Swish) Derivative(x float32) float32 {
+	y := sigmoid(x)
+	return y + x*y*(1-y)
+}
+
+/* Softmax turns xs into probability distribution in place. */
+func Softmax(xs []float32) {
+	var sum float32
+
+	maxX := xs[0]
+	for i := 1; i < len(xs); i++ {
+		maxX = max(maxX, xs[i])
+	}
+
+	for i := 0; i < len(xs); i++ {
+		xs[i] = float32(math.Exp(float64(xs[i] - maxX)))
+		sum += xs[i]
+	}
+
+	for i := 0; i < len(xs); i++ {
+		xs[i] /= sum
+	}
+}
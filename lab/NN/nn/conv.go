@@ -0,0 +1,139 @@
+package nn
+
+/* queryConv stores weighted sums of convolution layer for every row of inputs into sums. Outputs of every position of grid are next to each other, a value per filter. */
+func (l *Layer) queryConv(sums, inputs *Matrix) {
+	_, width, kh, kw, stride := l.grid()
+	oh, ow := l.outputGrid()
+	channels := l.Weights.Cols / (kh * kw)
+	n := kw * channels
+
+	sums.Resize(inputs.Rows, oh*ow*l.Neurons)
+	for r := 0; r < inputs.Rows; r++ {
+		in := inputs.Row(r)
+		for y := 0; y < oh; y++ {
+			for x := 0; x < ow; x++ {
+				position := sums.Row(r)[(y*ow+x)*l.Neurons : (y*ow+x+1)*l.Neurons]
+				for k := 0; k < l.Neurons; k++ {
+					weights := l.Weights.Row(k)
+
+					/* NOTE(anton2920): inputs of every row of kernel are next to each other, so each of them is a single dot product. */
+					sum := l.Biases[k]
+					for ky := 0; ky < kh; ky++ {
+						start := ((y*stride+ky)*width + x*stride) * channels
+						sum += dot(in[start:start+n], weights[ky*n:(ky+1)*n])
+					}
+					position[k] = sum
+				}
+			}
+		}
+	}
+}
+
+/* backwardConv adds gradients of weights and biases of convolution layer to weights and biases. If prevDeltas is not nil, derivatives with respect to inputs are stored into it. */
+func (l *Layer) backwardConv(deltas, inputs, prevDeltas, weights *Matrix, biases []float32) {
+	_, width, kh, kw, stride := l.grid()
+	oh, ow := l.outputGrid()
+	channels := l.Weights.Cols / (kh * kw)
+	n := kw * channels
+
+	if prevDeltas != nil {
+		prevDeltas.Resize(inputs.Rows, inputs.Cols)
+		prevDeltas.Zero()
+	}
+	for r := 0; r < inputs.Rows; r++ {
+		in := inputs.Row(r)
+		for y := 0; y < oh; y++ {
+			for x := 0; x < ow; x++ {
+				position := deltas.Row(r)[(y*ow+x)*l.Neurons : (y*ow+x+1)*l.Neurons]
+				for k := 0; k < l.Neurons; k++ {
+					if position[k] == 0 {
+						continue
+					}
+
+					biases[k] += position[k]
+					for ky := 0; ky < kh; ky++ {
+						start := ((y*stride+ky)*width + x*stride) * channels
+						axpy(position[k], in[start:start+n], weights.Row(k)[ky*n:(ky+1)*n])
+						if prevDeltas != nil {
+							axpy(position[k], l.Weights.Row(k)[ky*n:(ky+1)*n], prevDeltas.Row(r)[start:start+n])
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+/* queryPool stores maximum or average of every window of every channel of inputs into outputs. For max pooling sums hold index of input each output was taken from. */
+func (l *Layer) queryPool(sums, outputs, inputs *Matrix) {
+	height, width, kh, kw, stride := l.grid()
+	oh, ow := l.outputGrid()
+	channels := inputs.Cols / (height * width)
+
+	sums.Resize(inputs.Rows, oh*ow*channels)
+	outputs.Resize(sums.Rows, sums.Cols)
+	for r := 0; r < inputs.Rows; r++ {
+		in := inputs.Row(r)
+		for y := 0; y < oh; y++ {
+			for x := 0; x < ow; x++ {
+				for c := 0; c < channels; c++ {
+					var sum float32
+
+					best := -1
+					for ky := 0; ky < kh; ky++ {
+						for kx := 0; kx < kw; kx++ {
+							i := ((y*stride+ky)*width+x*stride+kx)*channels + c
+							sum += in[i]
+							if (best == -1) || (in[i] > in[best]) {
+								best = i
+							}
+						}
+					}
+
+					o := (y*ow+x)*channels + c
+					switch l.Kind {
+					case LayerMaxPool1D, LayerMaxPool2D:
+						/* NOTE(anton2920): indices are exact in float32 for rows of up to 2^24 inputs. */
+						outputs.Row(r)[o] = in[best]
+						sums.Row(r)[o] = float32(best)
+					case LayerAvgPool1D, LayerAvgPool2D:
+						outputs.Row(r)[o] = sum / float32(kh*kw)
+						sums.Row(r)[o] = outputs.Row(r)[o]
+					}
+				}
+			}
+		}
+	}
+}
+
+/* backwardPool stores derivatives of loss with respect to inputs of pooling layer into prevDeltas. */
+func (l *Layer) backwardPool(sums, deltas, inputs, prevDeltas *Matrix) {
+	height, width, kh, kw, stride := l.grid()
+	oh, ow := l.outputGrid()
+	channels := inputs.Cols / (height * width)
+
+	prevDeltas.Resize(inputs.Rows, inputs.Cols)
+	prevDeltas.Zero()
+	for r := 0; r < inputs.Rows; r++ {
+		prev := prevDeltas.Row(r)
+		for y := 0; y < oh; y++ {
+			for x := 0; x < ow; x++ {
+				for c := 0; c < channels; c++ {
+					o := (y*ow+x)*channels + c
+					delta := deltas.Row(r)[o]
+
+					switch l.Kind {
+					case LayerMaxPool1D, LayerMaxPool2D:
+						prev[int(sums.Row(r)[o])] += delta
+					case LayerAvgPool1D, LayerAvgPool2D:
+						for ky := 0; ky < kh; ky++ {
+							for kx := 0; kx < kw; kx++ {
+								prev[((y*stride+ky)*width+x*stride+kx)*channels+c] += delta / float32(kh*kw)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,470 @@
+package nn
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/* CSVOptions control how CSV files are read. Zero value reads all columns of comma-separated numbers without header. */
+type CSVOptions struct {
+	/* Comma separates fields, ',' if zero. Decimal separates fractional part of numbers, '.' if zero, so files delimited by ';' may use ','. */
+	Comma   rune
+	Decimal rune
+
+	/* Comment, if set, starts lines which are ignored. */
+	Comment rune
+
+	/* Header makes the first row names of columns. Without header columns are named by their indices. */
+	Header bool
+
+	/* Columns select columns by name or index, all columns are read if empty. Names are matched first. */
+	Columns []string
+
+	/* Missing is a strategy for empty fields and fields equal to one of MissingValues, like "NA". */
+	Missing       int
+	MissingValues []string
+
+	/* Categorical are columns, selected like Columns, which are one-hot encoded: column "c" with categories "a" and "b" becomes columns "c=a" and "c=b". Missing categories are encoded as zeros, unless they are errors or skipped. */
+	Categorical []string
+
+	/* Categories, if set, fix categories of categorical columns by name, so encoding matches the one used before. Other categories are errors. */
+	Categories map[string][]string
+}
+
+/* CSVError is an error in a field of CSV file. Line and Column start from 1. */
+type CSVError struct {
+	File   string
+	Line   int
+	Column int
+	Err    error
+}
+
+/* Table holds values of selected columns of CSV file. */
+type Table struct {
+	/* Names has a name of every column of Rows. */
+	Names []string
+	Rows  [][]float32
+
+	/* Categories has categories of every categorical column by its name, in order of their columns. */
+	Categories map[string][]string
+
+	/* sources have index of CSV column every column of Rows comes from, header has names of CSV columns. */
+	sources []int
+	header  []string
+}
+
+const (
+	MissingError  = iota /* Missing values are errors. */
+	MissingSkip          /* Rows with missing values are skipped. */
+	MissingZero          /* Missing values are replaced with zeros. */
+	MissingMean          /* Missing values are replaced with mean of the column. */
+	MissingMedian        /* Missing values are replaced with median of the column. */
+)
+
+var MissingNames = []string{
+	"error",
+	"skip",
+	"zero",
+	"mean",
+	"median",
+}
+
+/* MissingByName returns missing-value strategy with the given name. */
+func MissingByName(name string) (int, bool) {
+	for strategy := 0; strategy < len(MissingNames); strategy++ {
+		if MissingNames[strategy] == name {
+			return strategy, true
+		}
+	}
+	return 0, false
+}
+
+func (e *CSVError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Err.Error())
+}
+
+func (e *CSVError) Unwrap() error {
+	return e.Err
+}
+
+/* findColumn returns index of column with the given name or, if there is no such name, index. */
+func findColumn(names []string, column string) (int, error) {
+	if i := index(names, column); i >= 0 {
+		return i, nil
+	}
+	if i, err := strconv.Atoi(column); (err == nil) && (i >= 0) && (i < len(names)) {
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown column %q", column)
+}
+
+/* csvParser turns records of CSV file into rows of selected columns. */
+type csvParser struct {
+	filename string
+	options  *CSVOptions
+	names    []string
+	decimal  rune
+
+	/* columns are indices of selected fields of records. Categories hold categories of every selected categorical column. */
+	columns     []int
+	categorical []bool
+	categories  [][]string
+}
+
+func newCSVReader(r io.Reader, options *CSVOptions) *csv.Reader {
+	cr := csv.NewReader(r)
+	if options.Comma != 0 {
+		cr.Comma = options.Comma
+	}
+	cr.Comment = options.Comment
+	cr.TrimLeadingSpace = true
+	cr.ReuseRecord = true
+	return cr
+}
+
+/* csvReadError adds file name to syntax errors of CSV reader. */
+func csvReadError(filename string, err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return &CSVError{File: filename, Line: pe.Line, Column: pe.Column, Err: pe.Err}
+	}
+	return err
+}
+
+/* newCSVParser selects columns of records with the given names. */
+func newCSVParser(filename string, names []string, options *CSVOptions) (*csvParser, error) {
+	p := csvParser{filename: filename, options: options, names: names, decimal: options.Decimal}
+	if p.decimal == 0 {
+		p.decimal = '.'
+	}
+
+	if len(options.Columns) == 0 {
+		p.columns = identityOrder(len(names))
+	}
+	for _, column := range options.Columns {
+		c, err := findColumn(names, column)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		p.columns = append(p.columns, c)
+	}
+
+	p.categories = make([][]string, len(p.columns))
+	p.categorical = make([]bool, len(p.columns))
+	for _, column := range options.Categorical {
+		c, err := findColumn(names, column)
+		if err != nil {
+			return nil, fmt.Errorf("%s: categorical %w", filename, err)
+		}
+		k := 0
+		for (k < len(p.columns)) && (p.columns[k] != c) {
+			k++
+		}
+		if k == len(p.columns) {
+			return nil, fmt.Errorf("%s: categorical column %q is not selected", filename, column)
+		}
+		p.categorical[k] = true
+		if fixed, ok := options.Categories[names[c]]; ok {
+			p.categories[k] = append([]string(nil), fixed...)
+		}
+	}
+
+	return &p, nil
+}
+
+/* fieldError returns error in field c of the last record read by cr. */
+func (p *csvParser) fieldError(cr *csv.Reader, c int, err error) error {
+	line, column := cr.FieldPos(c)
+	return &CSVError{File: p.filename, Line: line, Column: column, Err: err}
+}
+
+/* parse parses selected fields of the last record read by cr into numbers and values of categorical columns. Missing numbers become NaNs, missing values stay empty. It returns false if record must be skipped. New categories are added, unless they are fixed. */
+func (p *csvParser) parse(cr *csv.Reader, record []string, numbers []float32, values []string) (bool, error) {
+	var missing bool
+
+	for k, c := range p.columns {
+		field := strings.TrimSpace(record[c])
+		values[k] = ""
+		if (field == "") || (index(p.options.MissingValues, field) >= 0) {
+			if p.options.Missing == MissingError {
+				return false, p.fieldError(cr, c, fmt.Errorf("missing value of column %q", p.names[c]))
+			}
+			missing = true
+			numbers[k] = float32(math.NaN())
+			continue
+		}
+
+		if p.categorical[k] {
+			values[k] = field
+			continue
+		}
+		if p.decimal != '.' {
+			field = strings.ReplaceAll(field, string(p.decimal), ".")
+		}
+		x, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return false, p.fieldError(cr, c, fmt.Errorf("invalid number %q in column %q", record[c], p.names[c]))
+		}
+		numbers[k] = float32(x)
+	}
+	if (missing) && (p.options.Missing == MissingSkip) {
+		return false, nil
+	}
+
+	for k, c := range p.columns {
+		if (p.categorical[k]) && (values[k] != "") && (index(p.categories[k], values[k]) < 0) {
+			if _, ok := p.options.Categories[p.names[c]]; ok {
+				return false, p.fieldError(cr, c, fmt.Errorf("unknown category %q of column %q", values[k], p.names[c]))
+			}
+			p.categories[k] = append(p.categories[k], values[k])
+		}
+	}
+
+	return true, nil
+}
+
+/* encode appends numbers and one-hot encoded values to row[:0]. Missing numbers become zeros. */
+func (p *csvParser) encode(numbers []float32, values []string, row []float32) []float32 {
+	row = row[:0]
+	for k := range p.columns {
+		if !p.categorical[k] {
+			x := numbers[k]
+			if math.IsNaN(float64(x)) {
+				x = 0
+			}
+			row = append(row, x)
+		} else {
+			for _, category := range p.categories[k] {
+				var x float32
+				if values[k] == category {
+					x = 1
+				}
+				row = append(row, x)
+			}
+		}
+	}
+	return row
+}
+
+/* table returns Table without rows, which has names of columns produced by encode. */
+func (p *csvParser) table() Table {
+	table := Table{Categories: make(map[string][]string), header: p.names}
+	for k, c := range p.columns {
+		if !p.categorical[k] {
+			table.Names = append(table.Names, p.names[c])
+			table.sources = append(table.sources, c)
+		} else {
+			table.Categories[p.names[c]] = p.categories[k]
+			for _, category := range p.categories[k] {
+				table.Names = append(table.Names, p.names[c]+"="+category)
+				table.sources = append(table.sources, c)
+			}
+		}
+	}
+	return table
+}
+
+/* csvNames returns names of columns from header record or, without header, their indices. */
+func csvNames(record []string, header bool) []string {
+	names := make([]string, len(record))
+	for j := 0; j < len(record); j++ {
+		if header {
+			names[j] = strings.TrimSpace(record[j])
+		} else {
+			names[j] = strconv.Itoa(j)
+		}
+	}
+	return names
+}
+
+/* ReadCSV reads selected columns of CSV file into Table. Errors in fields are CSVErrors. */
+func ReadCSV(filename string, options *CSVOptions) (*Table, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return DecodeCSV(f, filename, options)
+}
+
+/* DecodeCSV reads selected columns of CSV data from r into Table. Filename is only used in errors. */
+func DecodeCSV(r io.Reader, filename string, options *CSVOptions) (*Table, error) {
+	var numbers [][]float32
+	var values [][]string
+	var p *csvParser
+
+	if options == nil {
+		options = new(CSVOptions)
+	}
+
+	cr := newCSVReader(r, options)
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvReadError(filename, err)
+		}
+
+		if p == nil {
+			if p, err = newCSVParser(filename, csvNames(record, options.Header), options); err != nil {
+				return nil, err
+			}
+			if options.Header {
+				continue
+			}
+		}
+
+		number := make([]float32, len(p.columns))
+		value := make([]string, len(p.columns))
+		ok, err := p.parse(cr, record, number, value)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			numbers = append(numbers, number)
+			values = append(values, value)
+		}
+	}
+	if p == nil {
+		return &Table{Categories: make(map[string][]string)}, nil
+	}
+
+	for k, c := range p.columns {
+		if (p.categorical[k]) || (options.Missing == MissingZero) {
+			continue
+		}
+		if err := fillMissing(numbers, k, options.Missing); err != nil {
+			return nil, fmt.Errorf("%s: column %q %w", filename, p.names[c], err)
+		}
+	}
+
+	table := p.table()
+	table.Rows = make([][]float32, len(numbers))
+	for i := 0; i < len(numbers); i++ {
+		table.Rows[i] = p.encode(numbers[i], values[i], make([]float32, 0, len(table.Names)))
+	}
+
+	return &table, nil
+}
+
+/* index returns index of x in xs or -1, if there is none. */
+func index(xs []string, x string) int {
+	for i := 0; i < len(xs); i++ {
+		if xs[i] == x {
+			return i
+		}
+	}
+	return -1
+}
+
+/* fillMissing replaces missing values, which are NaNs, in column k of rows with its mean or median. */
+func fillMissing(rows [][]float32, k int, strategy int) error {
+	var xs []float64
+	var sum float64
+
+	for i := 0; i < len(rows); i++ {
+		if x := rows[i][k]; !math.IsNaN(float64(x)) {
+			xs = append(xs, float64(x))
+			sum += float64(x)
+		}
+	}
+	if len(xs) == len(rows) {
+		return nil
+	}
+	if len(xs) == 0 {
+		return fmt.Errorf("has no values")
+	}
+
+	var fill float64
+	switch strategy {
+	case MissingMean:
+		fill = sum / float64(len(xs))
+	case MissingMedian:
+		sort.Float64s(xs)
+		fill = quantile(xs, 0.5)
+	default:
+		return fmt.Errorf("has missing values and unknown strategy %d", strategy)
+	}
+
+	for i := 0; i < len(rows); i++ {
+		if math.IsNaN(float64(rows[i][k])) {
+			rows[i][k] = float32(fill)
+		}
+	}
+	return nil
+}
+
+/* Columns returns indices of columns of Table with the given names. Names and indices of CSV columns select every column which comes from them, so categorical column selects all its one-hot encoded columns. */
+func (t *Table) Columns(names ...string) ([]int, error) {
+	var columns []int
+
+	for _, name := range names {
+		if c := index(t.Names, name); c >= 0 {
+			columns = append(columns, c)
+			continue
+		}
+
+		source, err := findColumn(t.header, name)
+		n := len(columns)
+		for c := 0; (err == nil) && (c < len(t.sources)); c++ {
+			if t.sources[c] == source {
+				columns = append(columns, c)
+			}
+		}
+		if len(columns) == n {
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+	}
+
+	return columns, nil
+}
+
+/* Dataset returns Dataset with inputs and outputs selected from columns of Table, see Table.Columns. Empty outputs select all columns after the last input. */
+func (t *Table) Dataset(inputs, outputs []string) (Dataset, error) {
+	var dataset Dataset
+
+	inputColumns, err := t.Columns(inputs...)
+	if err != nil {
+		return dataset, fmt.Errorf("inputs: %w", err)
+	}
+	if len(inputColumns) == 0 {
+		return dataset, fmt.Errorf("no input columns")
+	}
+
+	outputColumns, err := t.Columns(outputs...)
+	if err != nil {
+		return dataset, fmt.Errorf("outputs: %w", err)
+	}
+	if len(outputs) == 0 {
+		last := inputColumns[0]
+		for _, c := range inputColumns {
+			last = max(last, c)
+		}
+		outputColumns = ColumnRange(last+1, len(t.Names))
+	}
+
+	dataset.Inputs = make([][]float32, len(t.Rows))
+	dataset.Outputs = make([][]float32, len(t.Rows))
+	for i, row := range t.Rows {
+		dataset.Inputs[i] = make([]float32, len(inputColumns))
+		for j, c := range inputColumns {
+			dataset.Inputs[i][j] = row[c]
+		}
+		dataset.Outputs[i] = make([]float32, len(outputColumns))
+		for j, c := range outputColumns {
+			dataset.Outputs[i][j] = row[c]
+		}
+	}
+
+	return dataset, nil
+}
@@ -0,0 +1,74 @@
+package nn
+
+import (
+	"encoding/csv"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+func GenerateTrainingDataRow(csvWriter *csv.Writer, row []string, basis [][]float32, ninputs int, i int, maxOffset float32) error {
+	var j int
+	for ; j < ninputs; j++ {
+		row[j] = strconv.FormatFloat(float64(basis[i][j]+maxOffset*rand.Float32()), 'f', 4, 32)
+	}
+
+	for ; j < len(basis[i]); j++ {
+		row[j] = strconv.Itoa(int(basis[i][j]))
+	}
+
+	if err := csvWriter.Write(row); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func GenerateTrainingData(trainingFilename string, basis [][]float32, maxOffset float32, ninputs, count int) error {
+	f, err := os.Create(trainingFilename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	csvWriter := csv.NewWriter(f)
+	defer csvWriter.Flush()
+
+	row := make([]string, len(basis[0]))
+	for i := 0; i < len(basis); i++ {
+		if err := GenerateTrainingDataRow(csvWriter, row, basis, ninputs, i, maxOffset); err != nil {
+			return err
+		}
+	}
+
+	for k := 0; k < count-len(basis); k++ {
+		i := rand.Int() % len(basis)
+		if err := GenerateTrainingDataRow(csvWriter, row, basis, ninputs, i, maxOffset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/* ReadTrainingData reads CSV file of numbers without header. Use ReadCSV for other files. */
+func ReadTrainingData(trainingFile string) ([][]float32, error) {
+	table, err := ReadCSV(trainingFile, nil)
+	if err != nil {
+		return nil, err
+	}
+	return table.Rows, nil
+}
+
+/* SplitTrainingData splits every row of training data into first ninputs inputs and remaining outputs. */
+func SplitTrainingData(trainingData [][]float32, ninputs int) ([][]float32, [][]float32) {
+	inputs := make([][]float32, len(trainingData))
+	outputs := make([][]float32, len(trainingData))
+
+	for i := 0; i < len(trainingData); i++ {
+		inputs[i] = trainingData[i][:ninputs]
+		outputs[i] = trainingData[i][ninputs:]
+	}
+
+	return inputs, outputs
+}
@@ -0,0 +1,220 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+/* Dataset holds samples split into inputs and outputs. Rows are shared with data Dataset was made from, so they are never copied. */
+type Dataset struct {
+	Inputs  [][]float32
+	Outputs [][]float32
+}
+
+/* SplitOptions control how Dataset is split into parts. Splits are fractions of the whole Dataset. */
+type SplitOptions struct {
+	ValidationSplit float32
+	TestSplit       float32
+
+	/* Shuffle makes samples shuffled with Seed before splitting. Otherwise their order is kept, as time series require. */
+	Shuffle bool
+	Seed    int64
+
+	/* Stratified keeps proportion of every class, see Class, the same in every part. */
+	Stratified bool
+}
+
+/* Fold is a pair of training and validation parts of Dataset used by k-fold cross-validation and walk-forward validation. */
+type Fold struct {
+	Training   Dataset
+	Validation Dataset
+}
+
+/* NewDataset splits every row returned by ReadTrainingData into first ninputs inputs and remaining outputs. */
+func NewDataset(rows [][]float32, ninputs int) Dataset {
+	inputs, outputs := SplitTrainingData(rows, ninputs)
+	return Dataset{Inputs: inputs, Outputs: outputs}
+}
+
+func (d *Dataset) Len() int {
+	return len(d.Inputs)
+}
+
+/* Subset returns Dataset with samples selected by indices. */
+func (d *Dataset) Subset(indices []int) Dataset {
+	subset := Dataset{Inputs: make([][]float32, len(indices)), Outputs: make([][]float32, len(indices))}
+	for i, index := range indices {
+		subset.Inputs[i] = d.Inputs[index]
+		subset.Outputs[i] = d.Outputs[index]
+	}
+	return subset
+}
+
+/* Shuffle shuffles samples in place. The same seed always gives the same order. */
+func (d *Dataset) Shuffle(seed int64) {
+	rand.New(rand.NewSource(seed)).Shuffle(d.Len(), func(i, j int) {
+		d.Inputs[i], d.Inputs[j] = d.Inputs[j], d.Inputs[i]
+		d.Outputs[i], d.Outputs[j] = d.Outputs[j], d.Outputs[i]
+	})
+}
+
+/* Class returns index of the largest output, which is a class of one-hot encoded sample. */
+func Class(outputs []float32) int {
+	var class int
+
+	for i := 1; i < len(outputs); i++ {
+		if outputs[i] > outputs[class] {
+			class = i
+		}
+	}
+
+	return class
+}
+
+/* order returns indices of samples, shuffled if options say so. */
+func (d *Dataset) order(options *SplitOptions) []int {
+	order := identityOrder(d.Len())
+	if options.Shuffle {
+		shuffle(rand.New(rand.NewSource(options.Seed)), order)
+	}
+	return order
+}
+
+/* groups returns indices of samples in order given by options, grouped by class if split is stratified. */
+func (d *Dataset) groups(options *SplitOptions) [][]int {
+	order := d.order(options)
+	if !options.Stratified {
+		return [][]int{order}
+	}
+
+	var groups [][]int
+	classes := make(map[int]int)
+	for _, i := range order {
+		class := Class(d.Outputs[i])
+		g, ok := classes[class]
+		if !ok {
+			g = len(groups)
+			classes[class] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	return groups
+}
+
+/* Split returns training, validation and test parts of Dataset. Every part keeps order of samples, which are shuffled first if options say so. Without shuffling training part comes first and test part comes last. */
+func (d *Dataset) Split(options *SplitOptions) (Dataset, Dataset, Dataset, error) {
+	var training, validation, test []int
+
+	if (options.ValidationSplit < 0) || (options.TestSplit < 0) || (options.ValidationSplit+options.TestSplit >= 1) {
+		return Dataset{}, Dataset{}, Dataset{}, fmt.Errorf("invalid splits: validation %g, test %g", options.ValidationSplit, options.TestSplit)
+	}
+
+	for _, group := range d.groups(options) {
+		ntest := int(math.Round(float64(options.TestSplit) * float64(len(group))))
+		nvalidation := int(math.Round(float64(options.ValidationSplit) * float64(len(group))))
+		ntraining := max(len(group)-nvalidation-ntest, 0)
+
+		training = append(training, group[:ntraining]...)
+		validation = append(validation, group[ntraining:len(group)-ntest]...)
+		test = append(test, group[len(group)-ntest:]...)
+	}
+	if len(training) == 0 {
+		return Dataset{}, Dataset{}, Dataset{}, fmt.Errorf("no samples left for training out of %d", d.Len())
+	}
+	if options.Stratified {
+		/* NOTE(anton2920): groups are concatenated class by class, so samples are put back in order, otherwise NN.TrainValidate would validate on classes it was not trained on. */
+		positions := make([]int, d.Len())
+		for p, i := range d.order(options) {
+			positions[i] = p
+		}
+		for _, part := range [...][]int{training, validation, test} {
+			sort.Slice(part, func(i, j int) bool { return positions[part[i]] < positions[part[j]] })
+		}
+	}
+
+	return d.Subset(training), d.Subset(validation), d.Subset(test), nil
+}
+
+/* Folds splits Dataset into k folds for cross-validation. Every sample is used for validation exactly once. Splits in options are ignored. */
+func (d *Dataset) Folds(k int, options *SplitOptions) ([]Fold, error) {
+	if (k < 2) || (k > d.Len()) {
+		return nil, fmt.Errorf("cannot split %d samples into %d folds", d.Len(), k)
+	}
+
+	var next int
+
+	parts := make([][]int, k)
+	for _, group := range d.groups(options) {
+		if options.Stratified {
+			/* NOTE(anton2920): samples of every class are dealt to folds in turn, continuing from where previous class stopped. */
+			for _, i := range group {
+				parts[next] = append(parts[next], i)
+				next = (next + 1) % k
+			}
+		} else {
+			for f := 0; f < k; f++ {
+				parts[f] = append(parts[f], group[f*len(group)/k:(f+1)*len(group)/k]...)
+			}
+		}
+	}
+
+	folds := make([]Fold, k)
+	for f := 0; f < k; f++ {
+		var training []int
+		for p := 0; p < k; p++ {
+			if p != f {
+				training = append(training, parts[p]...)
+			}
+		}
+		folds[f] = Fold{Training: d.Subset(training), Validation: d.Subset(parts[f])}
+	}
+
+	return folds, nil
+}
+
+/* CrossValidate calls evaluate for every of k folds of Dataset and returns mean and standard deviation of every metric evaluate returns. */
+func (d *Dataset) CrossValidate(k int, options *SplitOptions, evaluate func(fold int, training, validation Dataset) ([]float32, error)) ([]float32, []float32, error) {
+	folds, err := d.Folds(k, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return validateFolds(folds, evaluate)
+}
+
+/* validateFolds calls evaluate for every fold and returns mean and standard deviation of every metric evaluate returns. */
+func validateFolds(folds []Fold, evaluate func(fold int, training, validation Dataset) ([]float32, error)) ([]float32, []float32, error) {
+	var sums, squares []float64
+
+	k := len(folds)
+	for f := 0; f < len(folds); f++ {
+		metrics, err := evaluate(f, folds[f].Training, folds[f].Validation)
+		if err != nil {
+			return nil, nil, fmt.Errorf("fold #%d: %w", f, err)
+		}
+		if sums == nil {
+			sums = make([]float64, len(metrics))
+			squares = make([]float64, len(metrics))
+		} else if len(metrics) != len(sums) {
+			return nil, nil, fmt.Errorf("fold #%d: expected %d metrics, got %d", f, len(sums), len(metrics))
+		}
+
+		for m := 0; m < len(metrics); m++ {
+			sums[m] += float64(metrics[m])
+			squares[m] += float64(metrics[m]) * float64(metrics[m])
+		}
+	}
+
+	means := make([]float32, len(sums))
+	stddevs := make([]float32, len(sums))
+	for m := 0; m < len(sums); m++ {
+		mean := sums[m] / float64(k)
+		means[m] = float32(mean)
+		stddevs[m] = float32(math.Sqrt(max(squares[m]/float64(k)-mean*mean, 0)))
+	}
+
+	return means, stddevs, nil
+}
@@ -0,0 +1,249 @@
+package nn
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+const (
+	ForecastRecursive = iota
+	ForecastDirect
+)
+
+var ForecastNames = []string{
+	"recursive",
+	"direct",
+}
+
+/* ForecastByName returns kind of forecast with the given name. */
+func ForecastByName(name string) (int, bool) {
+	for kind := 0; kind < len(ForecastNames); kind++ {
+		if ForecastNames[kind] == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+/* ForecastOptions control how Forecaster predicts time series. */
+type ForecastOptions struct {
+	/* Kind is either ForecastRecursive, which predicts Horizon rows at a time and feeds them back into inputs, or ForecastDirect, which takes all Steps rows from outputs of a single query. */
+	Kind  int
+	Steps int
+
+	/* Window must be the same as options of Windows NN was trained on. Recursive forecasts need horizon of 1 and every input column among output columns. */
+	Window WindowOptions
+
+	/* Level is the fraction of bootstrapped forecasts prediction intervals cover, Samples is the number of them. */
+	Level   float32
+	Samples int
+	Seed    int64
+}
+
+/* Forecaster predicts rows of time series several steps ahead with NN trained on Windows of it. */
+type Forecaster struct {
+	NN      *NN
+	Options ForecastOptions
+
+	/* Residuals holds differences between actual and predicted rows of output columns for every step ahead and every sample of validation series. They are collected by Fit. */
+	Residuals [][][]float32
+
+	rng *rand.Rand
+}
+
+/* Forecast holds rows of output columns for every step ahead. Lower and Upper bounds of prediction intervals are nil if Forecaster was not fitted. */
+type Forecast struct {
+	Predictions [][]float32
+	Lower       [][]float32
+	Upper       [][]float32
+}
+
+func NewForecaster(nn *NN, options *ForecastOptions) *Forecaster {
+	return &Forecaster{NN: nn, Options: *options, rng: rand.New(rand.NewSource(options.Seed))}
+}
+
+/* columns checks options against rows of ncols columns and returns input and output columns, as well as position of every input column among output ones for recursive forecasts. */
+func (f *Forecaster) columns(ncols int) ([]int, []int, []int, error) {
+	options := &f.Options
+
+	if (options.Kind < 0) || (options.Kind >= len(ForecastNames)) {
+		return nil, nil, nil, fmt.Errorf("unknown forecast %d", options.Kind)
+	}
+	if (options.Window.Lookback <= 0) || (options.Window.Horizon <= 0) || (options.Steps <= 0) {
+		return nil, nil, nil, fmt.Errorf("invalid lookback %d, horizon %d and %d steps", options.Window.Lookback, options.Window.Horizon, options.Steps)
+	}
+
+	inputColumns, err := windowColumns(options.Window.InputColumns, ncols)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("input %w", err)
+	}
+	outputColumns, err := windowColumns(options.Window.OutputColumns, ncols)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("output %w", err)
+	}
+
+	var positions []int
+	switch options.Kind {
+	case ForecastRecursive:
+		if options.Window.Horizon != 1 {
+			return nil, nil, nil, fmt.Errorf("recursive forecast needs horizon of 1, got %d", options.Window.Horizon)
+		}
+		positions = make([]int, len(inputColumns))
+		for k, c := range inputColumns {
+			positions[k] = -1
+			for p, o := range outputColumns {
+				if o == c {
+					positions[k] = p
+					break
+				}
+			}
+			if positions[k] == -1 {
+				return nil, nil, nil, fmt.Errorf("recursive forecast cannot predict input column %d", c)
+			}
+		}
+	case ForecastDirect:
+		if options.Steps > options.Window.Horizon {
+			return nil, nil, nil, fmt.Errorf("direct forecast cannot predict %d steps with horizon %d", options.Steps, options.Window.Horizon)
+		}
+	}
+
+	return inputColumns, outputColumns, positions, nil
+}
+
+/* forecast returns Steps rows of output columns predicted for every window of inputs. If rng is not nil, predicted rows are perturbed by random residuals, which are fed back into inputs of recursive forecasts. Windows are modified. */
+func (f *Forecaster) forecast(windows [][]float32, noutputs int, positions []int, rng *rand.Rand) [][][]float32 {
+	steps := f.Options.Steps
+
+	paths := make([][][]float32, len(windows))
+	for r := 0; r < len(windows); r++ {
+		paths[r] = make([][]float32, steps)
+	}
+
+	switch f.Options.Kind {
+	case ForecastRecursive:
+		ninputs := len(positions)
+		for s := 0; s < steps; s++ {
+			outputs := f.NN.PredictAll(windows)
+			for r := 0; r < len(windows); r++ {
+				row := outputs[r]
+				if rng != nil {
+					axpy(1, f.Residuals[0][rng.Intn(len(f.Residuals[0]))], row)
+				}
+				paths[r][s] = row
+
+				window := windows[r]
+				copy(window, window[ninputs:])
+				for k, p := range positions {
+					window[len(window)-ninputs+k] = row[p]
+				}
+			}
+		}
+	case ForecastDirect:
+		outputs := f.NN.PredictAll(windows)
+		for r := 0; r < len(windows); r++ {
+			/* NOTE(anton2920): all steps take residuals of the same validation sample, so errors of neighbouring steps stay correlated. */
+			var sample int
+			if rng != nil {
+				sample = rng.Intn(len(f.Residuals[0]))
+			}
+			for s := 0; s < steps; s++ {
+				row := outputs[r][s*noutputs : (s+1)*noutputs]
+				if rng != nil {
+					axpy(1, f.Residuals[s][sample], row)
+				}
+				paths[r][s] = row
+			}
+		}
+	}
+
+	return paths
+}
+
+/* Fit collects residuals of forecasts for every window of validation series, which must have at least Lookback+Steps rows. */
+func (f *Forecaster) Fit(series [][]float32) error {
+	if len(series) == 0 {
+		return fmt.Errorf("no validation series")
+	}
+	_, outputColumns, positions, err := f.columns(len(series[0]))
+	if err != nil {
+		return err
+	}
+
+	d, err := Windows(series, &WindowOptions{Lookback: f.Options.Window.Lookback, Horizon: f.Options.Steps, InputColumns: f.Options.Window.InputColumns, OutputColumns: f.Options.Window.OutputColumns})
+	if err != nil {
+		return err
+	}
+	if (len(d.Inputs[0]) != f.NN.NumInputs()) || ((f.Options.Kind == ForecastRecursive) && (len(outputColumns) != f.NN.NumOutputs())) || ((f.Options.Kind == ForecastDirect) && (f.Options.Window.Horizon*len(outputColumns) != f.NN.NumOutputs())) {
+		return fmt.Errorf("windows do not fit NN with %d inputs and %d outputs", f.NN.NumInputs(), f.NN.NumOutputs())
+	}
+
+	paths := f.forecast(d.Inputs, len(outputColumns), positions, nil)
+	f.Residuals = make([][][]float32, f.Options.Steps)
+	for s := 0; s < f.Options.Steps; s++ {
+		f.Residuals[s] = make([][]float32, d.Len())
+		for i := 0; i < d.Len(); i++ {
+			residual := append([]float32(nil), d.Outputs[i][s*len(outputColumns):(s+1)*len(outputColumns)]...)
+			axpy(-1, paths[i][s], residual)
+			f.Residuals[s][i] = residual
+		}
+	}
+
+	return nil
+}
+
+/* Forecast predicts Steps rows following history, using its last Lookback rows. If Forecaster was fitted, prediction intervals are quantiles of Samples forecasts perturbed by residuals drawn with replacement. */
+func (f *Forecaster) Forecast(history [][]float32) (*Forecast, error) {
+	var forecast Forecast
+
+	if len(history) < f.Options.Window.Lookback {
+		return nil, fmt.Errorf("history of %d rows is shorter than lookback %d", len(history), f.Options.Window.Lookback)
+	}
+	inputColumns, outputColumns, positions, err := f.columns(len(history[0]))
+	if err != nil {
+		return nil, err
+	}
+
+	window := make([]float32, 0, f.Options.Window.Lookback*len(inputColumns))
+	for t := len(history) - f.Options.Window.Lookback; t < len(history); t++ {
+		window = appendColumns(window, history[t], inputColumns)
+	}
+	if len(window) != f.NN.NumInputs() {
+		return nil, fmt.Errorf("window of %d values does not fit NN with %d inputs", len(window), f.NN.NumInputs())
+	}
+	forecast.Predictions = f.forecast([][]float32{append([]float32(nil), window...)}, len(outputColumns), positions, nil)[0]
+
+	if f.Residuals == nil {
+		return &forecast, nil
+	}
+	if (f.Options.Level <= 0) || (f.Options.Level >= 1) || (f.Options.Samples <= 0) {
+		return nil, fmt.Errorf("invalid level %g of %d samples", f.Options.Level, f.Options.Samples)
+	}
+	if len(f.Residuals) < f.Options.Steps {
+		return nil, fmt.Errorf("residuals are fitted for %d steps, expected %d", len(f.Residuals), f.Options.Steps)
+	}
+
+	windows := make([][]float32, f.Options.Samples)
+	for r := 0; r < len(windows); r++ {
+		windows[r] = append([]float32(nil), window...)
+	}
+	paths := f.forecast(windows, len(outputColumns), positions, f.rng)
+
+	forecast.Lower = make([][]float32, f.Options.Steps)
+	forecast.Upper = make([][]float32, f.Options.Steps)
+	xs := make([]float64, len(paths))
+	for s := 0; s < f.Options.Steps; s++ {
+		forecast.Lower[s] = make([]float32, len(outputColumns))
+		forecast.Upper[s] = make([]float32, len(outputColumns))
+		for k := 0; k < len(outputColumns); k++ {
+			for r := 0; r < len(paths); r++ {
+				xs[r] = float64(paths[r][s][k])
+			}
+			sort.Float64s(xs)
+			forecast.Lower[s][k] = float32(quantile(xs, float64(1-f.Options.Level)/2))
+			forecast.Upper[s][k] = float32(quantile(xs, float64(1+f.Options.Level)/2))
+		}
+	}
+
+	return &forecast, nil
+}
@@ -0,0 +1,364 @@
+package nn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/*
+Model file format. All numbers are little-endian, strings are prefixed with uint16 length,
+float vectors are prefixed with uint32 length.
+
+	magic          [4]byte  "NNMF"
+	version        uint16   FormatVersion
+	loss           string   one of LossNames
+	trained        uint8
+	l1, l2         float32
+	maxGradNorm    float32
+	nlayers        uint32
+	for every layer:
+		kind       string   one of LayerNames
+		function   string   one of FunctionNames
+		neurons    uint32
+		inputs     uint32   columns of weights
+		steps      uint32
+		truncate   uint32
+		height     uint32
+		width      uint32
+		kernel     uint32
+		stride     uint32
+		sequence   uint8
+		dropout    float32
+		momentum   float32
+		weights    [rows*inputs]float32, row per neuron of every gate
+		biases     [rows]float32
+		mean       float vector, running means of BatchNorm, empty for other layers
+		variance   float vector, running variances of BatchNorm, empty for other layers
+	inputScaler    scaler
+	outputScaler   scaler
+	checksum       uint32   CRC-32 (IEEE) of everything above
+
+where rows is neurons multiplied by number of gates: 4 for LSTM, 3 for GRU, 0 for pooling and flatten, 1 otherwise.
+
+Scaler is stored as
+
+	kind           string   one of ScalerNames, empty if there is no scaler
+	ncolumns       uint32
+	columns        [ncolumns]uint32
+	offsets        [ncolumns]float32
+	scales         [ncolumns]float32
+*/
+
+const FormatVersion = 5
+
+var FormatMagic = [4]byte{'N', 'N', 'M', 'F'}
+
+var (
+	ErrBadMagic = errors.New("not a model file")
+	ErrChecksum = errors.New("checksum mismatch, model file is corrupted")
+)
+
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *encoder) write(v interface{}) {
+	if e.err == nil {
+		e.err = binary.Write(e.w, binary.LittleEndian, v)
+	}
+}
+
+func (e *encoder) writeString(s string) {
+	e.write(uint16(len(s)))
+	e.write([]byte(s))
+}
+
+func (e *encoder) writeFloats(xs []float32) {
+	e.write(uint32(len(xs)))
+	e.write(xs)
+}
+
+func (e *encoder) writeScaler(s *Scaler) {
+	if s == nil {
+		e.writeString("")
+		return
+	}
+
+	e.writeString(ScalerNames[s.Kind])
+	e.write(uint32(len(s.Columns)))
+	for _, c := range s.Columns {
+		e.write(uint32(c))
+	}
+	e.write(s.Offsets)
+	e.write(s.Scales)
+}
+
+/* Encode writes NN in model file format. */
+func (nn *NN) Encode(w io.Writer) error {
+	var buffer bytes.Buffer
+
+	if (nn.LossID < 0) || (nn.LossID >= len(LossNames)) {
+		return fmt.Errorf("unknown loss %d", nn.LossID)
+	}
+
+	e := encoder{w: &buffer}
+	e.write(FormatMagic)
+	e.write(uint16(FormatVersion))
+	e.writeString(LossNames[nn.LossID])
+
+	var trained uint8
+	if nn.Trained {
+		trained = 1
+	}
+	e.write(trained)
+	e.write([]float32{nn.Regularization.L1, nn.Regularization.L2, nn.Regularization.MaxGradNorm})
+
+	if err := nn.validateInitialized(); err != nil {
+		return err
+	}
+	e.write(uint32(len(nn.Layers)))
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+
+		var sequence uint8
+		if layer.Sequence {
+			sequence = 1
+		}
+
+		e.writeString(LayerNames[layer.Kind])
+		e.writeString(FunctionNames[layer.FunctionID])
+		e.write(uint32(layer.Neurons))
+		e.write(uint32(layer.Weights.Cols))
+		e.write([]uint32{uint32(layer.Steps), uint32(layer.Truncate), uint32(layer.Height), uint32(layer.Width), uint32(layer.Kernel), uint32(layer.Stride)})
+		e.write(sequence)
+		e.write([]float32{layer.Dropout, layer.Momentum})
+		e.write(layer.Weights.Data)
+		e.write(layer.Biases)
+		e.writeFloats(layer.Mean)
+		e.writeFloats(layer.Variance)
+	}
+
+	if err := nn.validateScalers(); err != nil {
+		return err
+	}
+	e.writeScaler(nn.InputScaler)
+	e.writeScaler(nn.OutputScaler)
+	e.write(crc32.ChecksumIEEE(buffer.Bytes()))
+	if e.err != nil {
+		return e.err
+	}
+
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) read(v interface{}) {
+	if d.err == nil {
+		if err := binary.Read(d.r, binary.LittleEndian, v); err != nil {
+			d.err = fmt.Errorf("truncated model file: %w", err)
+		}
+	}
+}
+
+func (d *decoder) readString() string {
+	var n uint16
+
+	d.read(&n)
+	buf := make([]byte, n)
+	d.read(buf)
+
+	return string(buf)
+}
+
+/* readFloats reads n floats, checking first that there are enough bytes left, so corrupted sizes do not cause huge allocations. */
+func (d *decoder) readFloats(n int) []float32 {
+	if d.err != nil {
+		return nil
+	}
+	if (n < 0) || (n > d.r.Len()/4) {
+		d.err = fmt.Errorf("truncated model file: need %d floats, %d bytes left", n, d.r.Len())
+		return nil
+	}
+
+	xs := make([]float32, n)
+	d.read(xs)
+
+	return xs
+}
+
+func (d *decoder) readScaler() *Scaler {
+	var ncolumns uint32
+
+	name := d.readString()
+	if (d.err != nil) || (name == "") {
+		return nil
+	}
+	kind, ok := ScalerByName(name)
+	if !ok {
+		d.err = fmt.Errorf("unknown scaler %q", name)
+		return nil
+	}
+
+	d.read(&ncolumns)
+	if (d.err == nil) && (int(ncolumns) > d.r.Len()/12) {
+		d.err = fmt.Errorf("truncated model file: need %d scaler columns, %d bytes left", ncolumns, d.r.Len())
+	}
+	if d.err != nil {
+		return nil
+	}
+	columns := make([]uint32, ncolumns)
+	d.read(columns)
+
+	s := Scaler{Kind: kind, Columns: make([]int, ncolumns)}
+	for k, c := range columns {
+		s.Columns[k] = int(c)
+	}
+	s.Offsets = d.readFloats(int(ncolumns))
+	s.Scales = d.readFloats(int(ncolumns))
+
+	return &s
+}
+
+/* Decode reads NN in model file format, replacing layers, loss and scalers of NN. */
+func (nn *NN) Decode(r io.Reader) error {
+	var magic [4]byte
+	var version uint16
+	var trained uint8
+	var nlayers uint32
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	d := decoder{r: bytes.NewReader(data)}
+	d.read(&magic)
+	if d.err != nil {
+		return d.err
+	}
+	if magic != FormatMagic {
+		return ErrBadMagic
+	}
+	d.read(&version)
+	if d.err != nil {
+		return d.err
+	}
+	if version != FormatVersion {
+		return fmt.Errorf("unsupported model file version %d, expected %d", version, FormatVersion)
+	}
+	header := len(magic) + 2
+	if len(data) < header+4 {
+		return errors.New("truncated model file")
+	}
+	if binary.LittleEndian.Uint32(data[len(data)-4:]) != crc32.ChecksumIEEE(data[:len(data)-4]) {
+		return ErrChecksum
+	}
+	d.r = bytes.NewReader(data[header : len(data)-4])
+
+	lossName := d.readString()
+	d.read(&trained)
+	regularization := d.readFloats(3)
+	d.read(&nlayers)
+	if d.err != nil {
+		return d.err
+	}
+
+	lossID, ok := LossByName(lossName)
+	if !ok {
+		return fmt.Errorf("unknown loss %q", lossName)
+	}
+
+	layers := make([]Layer, 0, min(int(nlayers), d.r.Len()))
+	for l := 0; l < int(nlayers); l++ {
+		var neurons, inputs, steps, truncate, height, width, kernel, stride, nmeans, nvariances uint32
+		var sequence uint8
+		var layer Layer
+
+		kindName := d.readString()
+		functionName := d.readString()
+		d.read(&neurons)
+		d.read(&inputs)
+		d.read(&steps)
+		d.read(&truncate)
+		d.read(&height)
+		d.read(&width)
+		d.read(&kernel)
+		d.read(&stride)
+		d.read(&sequence)
+		d.read(&layer.Dropout)
+		d.read(&layer.Momentum)
+		if d.err != nil {
+			return d.err
+		}
+
+		kind, ok := LayerByName(kindName)
+		if !ok {
+			return fmt.Errorf("layer #%d: unknown kind %q", l, kindName)
+		}
+		functionID, ok := FunctionByName(functionName)
+		if !ok {
+			return fmt.Errorf("layer #%d: unknown activation function %q", l, functionName)
+		}
+
+		layer.Kind = kind
+		layer.Neurons = int(neurons)
+		layer.FunctionID = functionID
+		layer.Steps = int(steps)
+		layer.Truncate = int(truncate)
+		layer.Height = int(height)
+		layer.Width = int(width)
+		layer.Kernel = int(kernel)
+		layer.Stride = int(stride)
+		layer.Sequence = sequence != 0
+
+		rows := layer.gates() * layer.Neurons
+		layer.Weights = Matrix{Rows: rows, Cols: int(inputs), Data: d.readFloats(rows * int(inputs))}
+		layer.Biases = d.readFloats(rows)
+		d.read(&nmeans)
+		layer.Mean = d.readFloats(int(nmeans))
+		d.read(&nvariances)
+		layer.Variance = d.readFloats(int(nvariances))
+		layers = append(layers, layer)
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(layers) == 0 {
+		return fmt.Errorf("NN has no layers")
+	}
+	if err := validateLayers(layers, layers[0].NumInputs(), true); err != nil {
+		return err
+	}
+
+	inputScaler := d.readScaler()
+	outputScaler := d.readScaler()
+	if d.err != nil {
+		return d.err
+	}
+	if d.r.Len() != 0 {
+		return fmt.Errorf("unexpected %d bytes at the end of model file", d.r.Len())
+	}
+
+	if err := validateScalers(layers, inputScaler, outputScaler); err != nil {
+		return err
+	}
+
+	nn.Layers = layers
+	nn.LossID = lossID
+	nn.Trained = trained != 0
+	nn.Regularization = Regularization{L1: regularization[0], L2: regularization[1], MaxGradNorm: regularization[2]}
+	nn.InputScaler = inputScaler
+	nn.OutputScaler = outputScaler
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+/* GradientCheckStep is a step of central finite differences used by NN.CheckGradients. */
+const GradientCheckStep = 1e-3
+
+/* meanLoss returns loss of NN in training mode averaged over samples, accumulated in float64 to keep finite differences precise. Dropout masks are the same every time. */
+func (nn *NN) meanLoss(inputs *Matrix, outputs [][]float32) float64 {
+	var sum float64
+
+	loss := Losses[nn.LossID]
+	results := nn.forward(&nn.ws, inputs, rand.New(rand.NewSource(Seed)))
+	for r := 0; r < results.Rows; r++ {
+		sum += float64(loss.Loss(results.Row(r), outputs[r]))
+	}
+
+	return sum / float64(results.Rows)
+}
+
+/* CheckGradients compares gradients from NN.Backpropagate with central finite differences of mean loss, allowing relative error of tolerance. NN is queried in training mode, so BatchNorm uses statistics of batch, and dropout masks are the same for every query. Regularization is not checked. */
+func (nn *NN) CheckGradients(inputs, outputs [][]float32, tolerance float64) error {
+	var batch Matrix
+
+	batch.SetRows(inputs)
+	g := nn.NewGradients()
+	nn.forward(&nn.ws, &batch, rand.New(rand.NewSource(Seed)))
+	nn.Backpropagate(&batch, outputs, g)
+
+	mismatch := func(analytic, numerical float64) bool {
+		return math.Abs(analytic-numerical) > tolerance*max(math.Abs(analytic), math.Abs(numerical), 1e-1)
+	}
+
+	check := func(l int, kind string, i int, param *float32, grad float32) error {
+		saved := *param
+
+		*param = saved + GradientCheckStep
+		plus := nn.meanLoss(&batch, outputs)
+		*param = saved - GradientCheckStep
+		minus := nn.meanLoss(&batch, outputs)
+		*param = saved
+
+		/* NOTE(anton2920): step is added to float32, so the actual difference between arguments is used. */
+		right := float64(saved+GradientCheckStep) - float64(saved)
+		left := float64(saved) - float64(saved-GradientCheckStep)
+		numerical := (plus - minus) / (left + right)
+		analytic := float64(grad) / float64(g.Count)
+
+		if mismatch(analytic, numerical) {
+			/* NOTE(anton2920): ReLU, MAE and Huber have kinks; when step crosses one, only one-sided difference matches. */
+			center := nn.meanLoss(&batch, outputs)
+			if mismatch(analytic, (plus-center)/right) && mismatch(analytic, (center-minus)/left) {
+				return fmt.Errorf("layer #%d (%s), %s #%d: analytic gradient %g, numerical %g", l, FunctionNames[nn.Layers[l].FunctionID], kind, i, analytic, numerical)
+			}
+		}
+		return nil
+	}
+
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+
+		for w := 0; w < len(layer.Weights.Data); w++ {
+			if err := check(l, "weight", w, &layer.Weights.Data[w], g.Weights[l].Data[w]); err != nil {
+				return err
+			}
+		}
+		for n := 0; n < len(layer.Biases); n++ {
+			if err := check(l, "bias", n, &layer.Biases[n], g.Biases[l][n]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
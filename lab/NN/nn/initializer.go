@@ -0,0 +1,52 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+)
+
+/* Initializer returns initial value for a single weight of layer with fanIn inputs and fanOut neurons. */
+type Initializer func(rng *rand.Rand, fanIn, fanOut int) float32
+
+/* SmallUniform is the default initializer, used when Layer.Initializer is not set. It ignores layer size. */
+func SmallUniform(rng *rand.Rand, fanIn, fanOut int) float32 {
+	return (rng.Float32() - 0.5) / 10
+}
+
+func uniform(rng *rand.Rand, limit float64) float32 {
+	return float32((2*rng.Float64() - 1) * limit)
+}
+
+/* XavierUniform is Glorot uniform initialization, suitable for sigmoid and tanh. */
+func XavierUniform(rng *rand.Rand, fanIn, fanOut int) float32 {
+	return uniform(rng, math.Sqrt(6/float64(fanIn+fanOut)))
+}
+
+/* XavierNormal is Glorot normal initialization, suitable for sigmoid and tanh. */
+func XavierNormal(rng *rand.Rand, fanIn, fanOut int) float32 {
+	return float32(rng.NormFloat64() * math.Sqrt(2/float64(fanIn+fanOut)))
+}
+
+/* HeUniform is Kaiming uniform initialization, suitable for ReLU. */
+func HeUniform(rng *rand.Rand, fanIn, fanOut int) float32 {
+	return uniform(rng, math.Sqrt(6/float64(fanIn)))
+}
+
+/* HeNormal is Kaiming normal initialization, suitable for ReLU. */
+func HeNormal(rng *rand.Rand, fanIn, fanOut int) float32 {
+	return float32(rng.NormFloat64() * math.Sqrt(2/float64(fanIn)))
+}
+
+func Constant(value float32) Initializer {
+	return func(rng *rand.Rand, fanIn, fanOut int) float32 {
+		return value
+	}
+}
+
+var Initializers = map[string]Initializer{
+	"small":          SmallUniform,
+	"xavier-uniform": XavierUniform,
+	"xavier-normal":  XavierNormal,
+	"he-uniform":     HeUniform,
+	"he-normal":      HeNormal,
+}
@@ -0,0 +1,222 @@
+package nn
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+/* jsonModel is a human-readable representation of NN. Kinds of layers, activations and loss are referred to by name, weights have a row per neuron of every gate. */
+type jsonModel struct {
+	Version        int                `json:"version"`
+	Loss           string             `json:"loss"`
+	Trained        bool               `json:"trained"`
+	Regularization jsonRegularization `json:"regularization"`
+	Layers         []jsonLayer        `json:"layers"`
+	InputScaler    *jsonScaler        `json:"input_scaler,omitempty"`
+	OutputScaler   *jsonScaler        `json:"output_scaler,omitempty"`
+}
+
+type jsonRegularization struct {
+	L1          float32 `json:"l1"`
+	L2          float32 `json:"l2"`
+	MaxGradNorm float32 `json:"max_grad_norm"`
+}
+
+type jsonScaler struct {
+	Kind    string    `json:"kind"`
+	Columns []int     `json:"columns"`
+	Offsets []float32 `json:"offsets"`
+	Scales  []float32 `json:"scales"`
+}
+
+type jsonLayer struct {
+	Kind       string      `json:"kind"`
+	Activation string      `json:"activation"`
+	Neurons    int         `json:"neurons"`
+	Inputs     int         `json:"inputs"`
+	Steps      int         `json:"steps,omitempty"`
+	Truncate   int         `json:"truncate,omitempty"`
+	Sequence   bool        `json:"sequence,omitempty"`
+	Height     int         `json:"height,omitempty"`
+	Width      int         `json:"width,omitempty"`
+	Kernel     int         `json:"kernel,omitempty"`
+	Stride     int         `json:"stride,omitempty"`
+	Momentum   float32     `json:"momentum,omitempty"`
+	Mean       []float32   `json:"mean,omitempty"`
+	Variance   []float32   `json:"variance,omitempty"`
+	Dropout    float32     `json:"dropout"`
+	Weights    [][]float32 `json:"weights"`
+	Biases     []float32   `json:"biases"`
+}
+
+func newJSONScaler(s *Scaler) *jsonScaler {
+	if s == nil {
+		return nil
+	}
+	return &jsonScaler{Kind: ScalerNames[s.Kind], Columns: s.Columns, Offsets: s.Offsets, Scales: s.Scales}
+}
+
+func (js *jsonScaler) scaler() (*Scaler, error) {
+	if js == nil {
+		return nil, nil
+	}
+
+	kind, ok := ScalerByName(js.Kind)
+	if !ok {
+		return nil, fmt.Errorf("unknown scaler %q", js.Kind)
+	}
+	return &Scaler{Kind: kind, Columns: js.Columns, Offsets: js.Offsets, Scales: js.Scales}, nil
+}
+
+/* ExportJSON writes NN as indented JSON. Its version follows FormatVersion. */
+func (nn *NN) ExportJSON(w io.Writer) error {
+	if (nn.LossID < 0) || (nn.LossID >= len(LossNames)) {
+		return fmt.Errorf("unknown loss %d", nn.LossID)
+	}
+
+	model := jsonModel{
+		Version: FormatVersion,
+		Loss:    LossNames[nn.LossID],
+		Trained: nn.Trained,
+		Regularization: jsonRegularization{
+			L1:          nn.Regularization.L1,
+			L2:          nn.Regularization.L2,
+			MaxGradNorm: nn.Regularization.MaxGradNorm,
+		},
+		Layers: make([]jsonLayer, len(nn.Layers)),
+	}
+
+	if err := nn.validateInitialized(); err != nil {
+		return err
+	}
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+
+		weights := make([][]float32, layer.Weights.Rows)
+		for n := 0; n < layer.Weights.Rows; n++ {
+			weights[n] = layer.Weights.Row(n)
+		}
+
+		model.Layers[l] = jsonLayer{
+			Kind:       LayerNames[layer.Kind],
+			Activation: FunctionNames[layer.FunctionID],
+			Neurons:    layer.Neurons,
+			Inputs:     layer.Weights.Cols,
+			Steps:      layer.Steps,
+			Truncate:   layer.Truncate,
+			Sequence:   layer.Sequence,
+			Height:     layer.Height,
+			Width:      layer.Width,
+			Kernel:     layer.Kernel,
+			Stride:     layer.Stride,
+			Momentum:   layer.Momentum,
+			Mean:       layer.Mean,
+			Variance:   layer.Variance,
+			Dropout:    layer.Dropout,
+			Weights:    weights,
+			Biases:     layer.Biases,
+		}
+	}
+
+	if err := nn.validateScalers(); err != nil {
+		return err
+	}
+	model.InputScaler = newJSONScaler(nn.InputScaler)
+	model.OutputScaler = newJSONScaler(nn.OutputScaler)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(&model)
+}
+
+/* ImportJSON reads NN written by NN.ExportJSON, replacing layers, loss and scalers of NN. */
+func (nn *NN) ImportJSON(r io.Reader) error {
+	var model jsonModel
+
+	if err := json.NewDecoder(r).Decode(&model); err != nil {
+		return err
+	}
+	if model.Version != FormatVersion {
+		return fmt.Errorf("unsupported model version %d, expected %d", model.Version, FormatVersion)
+	}
+
+	lossID, ok := LossByName(model.Loss)
+	if !ok {
+		return fmt.Errorf("unknown loss %q", model.Loss)
+	}
+
+	layers := make([]Layer, len(model.Layers))
+	for l := 0; l < len(model.Layers); l++ {
+		src := &model.Layers[l]
+		layer := &layers[l]
+
+		kind, ok := LayerByName(src.Kind)
+		if !ok {
+			return fmt.Errorf("layer #%d: unknown kind %q", l, src.Kind)
+		}
+		functionID, ok := FunctionByName(src.Activation)
+		if !ok {
+			return fmt.Errorf("layer #%d: unknown activation function %q", l, src.Activation)
+		}
+
+		layer.Kind = kind
+		layer.Neurons = src.Neurons
+		layer.FunctionID = functionID
+		layer.Steps = src.Steps
+		layer.Truncate = src.Truncate
+		layer.Sequence = src.Sequence
+		layer.Height = src.Height
+		layer.Width = src.Width
+		layer.Kernel = src.Kernel
+		layer.Stride = src.Stride
+		layer.Momentum = src.Momentum
+		layer.Mean = src.Mean
+		layer.Variance = src.Variance
+		layer.Dropout = src.Dropout
+
+		if ((layer.gates() > 0) && (src.Neurons <= 0)) || (src.Inputs <= 0) {
+			return fmt.Errorf("layer #%d has %d neurons and %d inputs", l, src.Neurons, src.Inputs)
+		}
+		rows := layer.gates() * src.Neurons
+		if (len(src.Weights) != rows) || (len(src.Biases) != rows) {
+			return fmt.Errorf("layer #%d: expected %d rows of weights and biases, got %d and %d", l, rows, len(src.Weights), len(src.Biases))
+		}
+		layer.Weights.Resize(rows, src.Inputs)
+		for n := 0; n < rows; n++ {
+			if len(src.Weights[n]) != src.Inputs {
+				return fmt.Errorf("layer #%d: neuron #%d has %d weights, expected %d", l, n, len(src.Weights[n]), src.Inputs)
+			}
+			copy(layer.Weights.Row(n), src.Weights[n])
+		}
+		layer.Biases = src.Biases
+	}
+
+	if len(layers) == 0 {
+		return fmt.Errorf("NN has no layers")
+	}
+	if err := validateLayers(layers, layers[0].NumInputs(), true); err != nil {
+		return err
+	}
+
+	inputScaler, err := model.InputScaler.scaler()
+	if err != nil {
+		return fmt.Errorf("input %w", err)
+	}
+	outputScaler, err := model.OutputScaler.scaler()
+	if err != nil {
+		return fmt.Errorf("output %w", err)
+	}
+	if err := validateScalers(layers, inputScaler, outputScaler); err != nil {
+		return err
+	}
+
+	nn.Layers = layers
+	nn.LossID = lossID
+	nn.Trained = model.Trained
+	nn.Regularization = Regularization{L1: model.Regularization.L1, L2: model.Regularization.L2, MaxGradNorm: model.Regularization.MaxGradNorm}
+	nn.InputScaler = inputScaler
+	nn.OutputScaler = outputScaler
+
+	return nil
+}
@@ -0,0 +1,289 @@
+package nn
+
+import "fmt"
+
+const (
+	LayerDense = iota
+	LayerRNN
+	LayerLSTM
+	LayerGRU
+	LayerConv1D
+	LayerConv2D
+	LayerMaxPool1D
+	LayerMaxPool2D
+	LayerAvgPool1D
+	LayerAvgPool2D
+	LayerFlatten
+	LayerBatchNorm
+	LayerLayerNorm
+)
+
+var LayerNames = []string{
+	"dense",
+	"rnn",
+	"lstm",
+	"gru",
+	"conv1d",
+	"conv2d",
+	"maxpool1d",
+	"maxpool2d",
+	"avgpool1d",
+	"avgpool2d",
+	"flatten",
+	"batchnorm",
+	"layernorm",
+}
+
+/* LayerByName returns kind of layer with the given name. */
+func LayerByName(name string) (int, bool) {
+	for kind := 0; kind < len(LayerNames); kind++ {
+		if LayerNames[kind] == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+/* Recurrent reports whether layer treats its inputs as a sequence of steps. */
+func (l *Layer) Recurrent() bool {
+	return (l.Kind == LayerRNN) || (l.Kind == LayerLSTM) || (l.Kind == LayerGRU)
+}
+
+/* Convolutional reports whether layer slides kernel of weights over grid of its inputs. */
+func (l *Layer) Convolutional() bool {
+	return (l.Kind == LayerConv1D) || (l.Kind == LayerConv2D)
+}
+
+/* Pooling reports whether layer takes maximum or average of every window of grid of its inputs. */
+func (l *Layer) Pooling() bool {
+	return (l.Kind == LayerMaxPool1D) || (l.Kind == LayerMaxPool2D) || (l.Kind == LayerAvgPool1D) || (l.Kind == LayerAvgPool2D)
+}
+
+/* Normalization reports whether layer normalizes its inputs, scaling and shifting them afterwards. */
+func (l *Layer) Normalization() bool {
+	return (l.Kind == LayerBatchNorm) || (l.Kind == LayerLayerNorm)
+}
+
+/* activated reports whether layer applies activation function to weighted sums, so its deltas are derivatives with respect to them. */
+func (l *Layer) activated() bool {
+	return (l.Kind == LayerDense) || (l.Convolutional()) || (l.Normalization())
+}
+
+/* gates returns number of rows of weights for every neuron of layer. */
+func (l *Layer) gates() int {
+	switch {
+	case l.Kind == LayerLSTM:
+		return 4
+	case l.Kind == LayerGRU:
+		return 3
+	case (l.Pooling()) || (l.Kind == LayerFlatten):
+		return 0
+	default:
+		return 1
+	}
+}
+
+/* grid returns height and width of grid of inputs of convolution or pooling layer, height and width of its kernel and stride. One-dimensional layers have height of 1. */
+func (l *Layer) grid() (height, width, kh, kw, stride int) {
+	height, width, kh, kw = 1, l.Width, 1, l.Kernel
+	if (l.Kind == LayerConv2D) || (l.Kind == LayerMaxPool2D) || (l.Kind == LayerAvgPool2D) {
+		height, kh = l.Height, l.Kernel
+	}
+
+	stride = l.Stride
+	if stride == 0 {
+		stride = 1
+		if l.Pooling() {
+			stride = l.Kernel
+		}
+	}
+
+	return height, width, kh, kw, stride
+}
+
+/* outputGrid returns height and width of grid of outputs of convolution or pooling layer. */
+func (l *Layer) outputGrid() (int, int) {
+	height, width, kh, kw, stride := l.grid()
+	return (height-kh)/stride + 1, (width-kw)/stride + 1
+}
+
+/* shape returns dimensions of weights of layer with ninputs inputs. */
+func (l *Layer) shape(ninputs int) (int, int) {
+	switch {
+	case l.Recurrent():
+		return l.gates() * l.Neurons, ninputs/l.Steps + l.Neurons
+	case l.Convolutional():
+		height, width, kh, kw, _ := l.grid()
+		return l.Neurons, kh * kw * (ninputs / (height * width))
+	case l.Normalization():
+		return l.Neurons, 1
+	default:
+		return l.gates() * l.Neurons, ninputs
+	}
+}
+
+/* NumInputs returns size of input rows of initialized layer. */
+func (l *Layer) NumInputs() int {
+	switch {
+	case l.Recurrent():
+		return l.Steps * (l.Weights.Cols - l.Neurons)
+	case l.Convolutional():
+		height, width, kh, kw, _ := l.grid()
+		return height * width * (l.Weights.Cols / (kh * kw))
+	case l.Normalization():
+		return l.Neurons
+	default:
+		return l.Weights.Cols
+	}
+}
+
+/* NumOutputs returns size of output rows of layer. */
+func (l *Layer) NumOutputs() int {
+	return l.numOutputs(l.NumInputs())
+}
+
+/* numOutputs returns size of output rows of layer with ninputs inputs. */
+func (l *Layer) numOutputs(ninputs int) int {
+	switch {
+	case (l.Recurrent()) && (l.Sequence):
+		return l.Steps * l.Neurons
+	case l.Convolutional():
+		oh, ow := l.outputGrid()
+		return oh * ow * l.Neurons
+	case l.Pooling():
+		height, width, _, _, _ := l.grid()
+		oh, ow := l.outputGrid()
+		return oh * ow * (ninputs / (height * width))
+	case l.Kind == LayerFlatten:
+		return ninputs
+	default:
+		return l.Neurons
+	}
+}
+
+/* NumInputs returns size of input rows of initialized NN. */
+func (nn *NN) NumInputs() int {
+	return nn.Layers[0].NumInputs()
+}
+
+/* NumOutputs returns size of output rows of NN. */
+func (nn *NN) NumOutputs() int {
+	return nn.Layers[len(nn.Layers)-1].NumOutputs()
+}
+
+/* validateLayers checks that every layer can take outputs of the previous one, the first one taking rows of ninputs. If initialized is set, shapes of weights and biases are checked too. */
+func validateLayers(layers []Layer, ninputs int, initialized bool) error {
+	if len(layers) == 0 {
+		return fmt.Errorf("NN has no layers")
+	}
+
+	for l := 0; l < len(layers); l++ {
+		layer := &layers[l]
+
+		if (layer.Kind < 0) || (layer.Kind >= len(LayerNames)) {
+			return fmt.Errorf("layer #%d: unknown kind %d", l, layer.Kind)
+		}
+		if (layer.FunctionID < 0) || (layer.FunctionID >= len(FunctionNames)) {
+			return fmt.Errorf("layer #%d: unknown activation function %d", l, layer.FunctionID)
+		}
+		if (layer.gates() > 0) && (layer.Neurons <= 0) {
+			return fmt.Errorf("layer #%d has no neurons", l)
+		}
+		/* NOTE(anton2920): derivative of softmax is combined with the one of loss, so it has none of its own. */
+		if (layer.FunctionID == FunctionSoftmax) && (l < len(layers)-1) {
+			return fmt.Errorf("layer #%d: softmax can only be used in output layer", l)
+		}
+		if layer.Dropout != 0 {
+			if !((layer.Dropout > 0) && (layer.Dropout < 1)) {
+				return fmt.Errorf("layer #%d: dropout %g is out of range [0; 1)", l, layer.Dropout)
+			}
+			if layer.Kind != LayerDense {
+				return fmt.Errorf("layer #%d: dropout cannot be used in %s layer", l, LayerNames[layer.Kind])
+			}
+			if l == len(layers)-1 {
+				return fmt.Errorf("layer #%d: dropout cannot be used in output layer", l)
+			}
+		}
+
+		if layer.Recurrent() {
+			if layer.FunctionID == FunctionSoftmax {
+				return fmt.Errorf("layer #%d: softmax cannot be used in %s layer", l, LayerNames[layer.Kind])
+			}
+			if layer.Steps <= 0 {
+				return fmt.Errorf("layer #%d: %s layer has no steps", l, LayerNames[layer.Kind])
+			}
+			if (ninputs <= 0) || (ninputs%layer.Steps != 0) {
+				return fmt.Errorf("layer #%d: %d inputs cannot be split into %d steps", l, ninputs, layer.Steps)
+			}
+			if layer.Truncate < 0 {
+				return fmt.Errorf("layer #%d: negative truncation %d", l, layer.Truncate)
+			}
+		}
+
+		if (layer.Convolutional()) || (layer.Pooling()) {
+			height, width, kh, kw, _ := layer.grid()
+			if (layer.Convolutional()) && (layer.FunctionID == FunctionSoftmax) {
+				return fmt.Errorf("layer #%d: softmax cannot be used in %s layer", l, LayerNames[layer.Kind])
+			}
+			if (height <= 0) || (width <= 0) {
+				return fmt.Errorf("layer #%d: %s layer has invalid grid %dx%d", l, LayerNames[layer.Kind], height, width)
+			}
+			if (ninputs <= 0) || (ninputs%(height*width) != 0) {
+				return fmt.Errorf("layer #%d: %d inputs cannot be split into %dx%d grid", l, ninputs, height, width)
+			}
+			if (kh <= 0) || (kw <= 0) || (kh > height) || (kw > width) {
+				return fmt.Errorf("layer #%d: kernel %dx%d does not fit into %dx%d grid", l, kh, kw, height, width)
+			}
+			if layer.Stride < 0 {
+				return fmt.Errorf("layer #%d: negative stride %d", l, layer.Stride)
+			}
+		}
+
+		if layer.Normalization() {
+			if layer.Neurons != ninputs {
+				return fmt.Errorf("layer #%d: %s layer has %d neurons, but %d inputs", l, LayerNames[layer.Kind], layer.Neurons, ninputs)
+			}
+			if (layer.Momentum < 0) || (layer.Momentum > 1) {
+				return fmt.Errorf("layer #%d: momentum %g is out of range [0; 1]", l, layer.Momentum)
+			}
+		}
+
+		if initialized {
+			rows, cols := layer.shape(ninputs)
+			if (layer.Weights.Rows != rows) || (len(layer.Biases) != rows) {
+				return fmt.Errorf("layer #%d is not initialized", l)
+			}
+			if layer.Weights.Cols != cols {
+				if l == 0 {
+					return fmt.Errorf("layer #%d has %d columns of weights, expected %d", l, layer.Weights.Cols, cols)
+				}
+				return fmt.Errorf("layer #%d has %d inputs, but previous layer has %d outputs", l, layer.NumInputs(), ninputs)
+			}
+			if (layer.Kind == LayerBatchNorm) && ((len(layer.Mean) != rows) || (len(layer.Variance) != rows)) {
+				return fmt.Errorf("layer #%d has %d running means and %d variances, expected %d", l, len(layer.Mean), len(layer.Variance), rows)
+			}
+			for _, variance := range layer.Variance {
+				if !(variance >= 0) {
+					return fmt.Errorf("layer #%d has invalid running variance %g", l, variance)
+				}
+			}
+		}
+
+		ninputs = layer.numOutputs(ninputs)
+	}
+
+	return nil
+}
+
+/* Validate checks that layers of NN fit together for input rows of size ninputs. Training methods call it before initializing NN. */
+func (nn *NN) Validate(ninputs int) error {
+	return validateLayers(nn.Layers, ninputs, false)
+}
+
+/* validateInitialized checks layers of initialized NN, if there are any. */
+func (nn *NN) validateInitialized() error {
+	if len(nn.Layers) == 0 {
+		return nil
+	}
+	return validateLayers(nn.Layers, nn.NumInputs(), true)
+}
@@ -0,0 +1,139 @@
+package nn
+
+import "math"
+
+/* Loss measures how far outputs of NN are from expected ones for a single sample. */
+type Loss interface {
+	Loss(outputs, expected []float32) float32
+
+	/* Gradient stores derivatives of loss with respect to every output into gradient. */
+	Gradient(outputs, expected, gradient []float32)
+}
+
+/* NOTE(anton2920): losses are summed over outputs; averaging over samples is done by Gradients. */
+type MSE struct{}
+
+type MAE struct{}
+
+type Huber struct {
+	Delta float32
+}
+
+type CrossEntropy struct{}
+
+const (
+	LossMSE = iota
+	LossMAE
+	LossHuber
+	LossCrossEntropy
+)
+
+var Losses = []Loss{
+	MSE{},
+	MAE{},
+	Huber{Delta: 1},
+	CrossEntropy{},
+}
+
+/* LossNames identify losses in model files, so they must not change. */
+var LossNames = []string{
+	"mse",
+	"mae",
+	"huber",
+	"cross-entropy",
+}
+
+/* LossByName returns ID of loss with the given name. */
+func LossByName(name string) (int, bool) {
+	for id := 0; id < len(LossNames); id++ {
+		if LossNames[id] == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+/* Loss returns half of squared error, which is what classic delta rule minimizes. */
+func (MSE) Loss(outputs, expected []float32) float32 {
+	var loss float32
+
+	for i := 0; i < len(outputs); i++ {
+		loss += 0.5 * (outputs[i] - expected[i]) * (outputs[i] - expected[i])
+	}
+
+	return loss
+}
+
+func (MSE) Gradient(outputs, expected, gradient []float32) {
+	for i := 0; i < len(outputs); i++ {
+		gradient[i] = outputs[i] - expected[i]
+	}
+}
+
+func (MAE) Loss(outputs, expected []float32) float32 {
+	var loss float32
+
+	for i := 0; i < len(outputs); i++ {
+		loss += float32(math.Abs(float64(outputs[i] - expected[i])))
+	}
+
+	return loss
+}
+
+func (MAE) Gradient(outputs, expected, gradient []float32) {
+	for i := 0; i < len(outputs); i++ {
+		switch {
+		case outputs[i] > expected[i]:
+			gradient[i] = 1
+		case outputs[i] < expected[i]:
+			gradient[i] = -1
+		default:
+			gradient[i] = 0
+		}
+	}
+}
+
+/* Loss is quadratic for errors smaller than h.Delta and linear otherwise. */
+func (h Huber) Loss(outputs, expected []float32) float32 {
+	var loss float32
+
+	for i := 0; i < len(outputs); i++ {
+		diff := float32(math.Abs(float64(outputs[i] - expected[i])))
+		if diff <= h.Delta {
+			loss += 0.5 * diff * diff
+		} else {
+			loss += h.Delta * (diff - 0.5*h.Delta)
+		}
+	}
+
+	return loss
+}
+
+func (h Huber) Gradient(outputs, expected, gradient []float32) {
+	for i := 0; i < len(outputs); i++ {
+		diff := outputs[i] - expected[i]
+		gradient[i] = max(-h.Delta, min(diff, h.Delta))
+	}
+}
+
+/* crossEntropyEPS prevents taking logarithm of zero probability. */
+const crossEntropyEPS = 1e-7
+
+/* Loss expects outputs to be probabilities and expected to be a probability distribution, usually one-hot encoded class. */
+func (CrossEntropy) Loss(outputs, expected []float32) float32 {
+	var loss float32
+
+	for i := 0; i < len(outputs); i++ {
+		if expected[i] != 0 {
+			loss -= expected[i] * float32(math.Log(float64(max(outputs[i], crossEntropyEPS))))
+		}
+	}
+
+	return loss
+}
+
+func (CrossEntropy) Gradient(outputs, expected, gradient []float32) {
+	for i := 0; i < len(outputs); i++ {
+		gradient[i] = -expected[i] / max(outputs[i], crossEntropyEPS)
+	}
+}
@@ -0,0 +1,93 @@
+package nn
+
+/* Matrix is a dense row-major matrix. */
+type Matrix struct {
+	Rows int
+	Cols int
+	Data []float32
+}
+
+/* Resize changes dimensions of m, reusing its storage when it is large enough. Contents are not preserved. */
+func (m *Matrix) Resize(rows, cols int) {
+	if cap(m.Data) < rows*cols {
+		m.Data = make([]float32, rows*cols)
+	}
+	m.Data = m.Data[:rows*cols]
+	m.Rows = rows
+	m.Cols = cols
+}
+
+func (m *Matrix) Row(i int) []float32 {
+	return m.Data[i*m.Cols : (i+1)*m.Cols]
+}
+
+func (m *Matrix) Zero() {
+	for i := 0; i < len(m.Data); i++ {
+		m.Data[i] = 0
+	}
+}
+
+/* SetRows copies rows into m, one row per slice. */
+func (m *Matrix) SetRows(rows [][]float32) {
+	m.Resize(len(rows), len(rows[0]))
+	for i := 0; i < len(rows); i++ {
+		copy(m.Row(i), rows[i])
+	}
+}
+
+func dot(xs, ys []float32) float32 {
+	var sum float32
+
+	ys = ys[:len(xs)]
+	for i := 0; i < len(xs); i++ {
+		sum += xs[i] * ys[i]
+	}
+
+	return sum
+}
+
+/* axpy computes ys += a*xs. */
+func axpy(a float32, xs, ys []float32) {
+	ys = ys[:len(xs)]
+	for i := 0; i < len(xs); i++ {
+		ys[i] += a * xs[i]
+	}
+}
+
+/* MulTransposed computes dst = a * b^T + bias, where bias is added to every row. */
+func MulTransposed(dst, a, b *Matrix, bias []float32) {
+	dst.Resize(a.Rows, b.Rows)
+	for i := 0; i < a.Rows; i++ {
+		row := a.Row(i)
+		out := dst.Row(i)
+		for j := 0; j < b.Rows; j++ {
+			out[j] = dot(row, b.Row(j)) + bias[j]
+		}
+	}
+}
+
+/* Mul computes dst = a * b. */
+func Mul(dst, a, b *Matrix) {
+	dst.Resize(a.Rows, b.Cols)
+	dst.Zero()
+	for i := 0; i < a.Rows; i++ {
+		row := a.Row(i)
+		out := dst.Row(i)
+		for k := 0; k < a.Cols; k++ {
+			axpy(row[k], b.Row(k), out)
+		}
+	}
+}
+
+/* AddTransposedMul computes dst += a^T * b. */
+func AddTransposedMul(dst, a, b *Matrix) {
+	for k := 0; k < a.Rows; k++ {
+		row := a.Row(k)
+		src := b.Row(k)
+		for i := 0; i < a.Cols; i++ {
+			if row[i] != 0 {
+				axpy(row[i], src, dst.Row(i))
+			}
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package nn
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+)
+
+/* RegressionMetrics summarize errors of predicted outputs over every output of every sample. */
+type RegressionMetrics struct {
+	MSE  float32
+	RMSE float32
+	MAE  float32
+
+	/* MAPE is in percents. Expected outputs equal to zero are skipped. */
+	MAPE float32
+
+	/* R2 is coefficient of determination, which compares squared error to variance of every expected output. */
+	R2 float32
+
+	Count int
+}
+
+/* ClassificationMetrics summarize predictions of one-hot encoded classes, see Class. NN with a single output is treated as binary classifier with threshold 0.5. */
+type ClassificationMetrics struct {
+	Accuracy float32
+
+	/* Confusion has a row per expected class and a column per predicted class. */
+	Confusion [][]int
+
+	/* Precision, Recall, F1 and AUC (area under ROC curve, one class versus the rest) are per class. They are NaN when undefined, like recall of class without samples. */
+	Precision []float32
+	Recall    []float32
+	F1        []float32
+	AUC       []float32
+	Support   []int
+
+	/* Names, if set, are used by reports instead of class numbers. */
+	Names []string
+}
+
+/* QueryAll returns outputs of NN for every row of inputs. Unlike NN.QueryBatch, returned rows are not reused. */
+func (nn *NN) QueryAll(inputs [][]float32) [][]float32 {
+	var batch Matrix
+
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	batch.SetRows(inputs)
+	results := nn.QueryBatch(&batch)
+
+	outputs := make([][]float32, results.Rows)
+	data := append([]float32(nil), results.Data...)
+	for r := 0; r < results.Rows; r++ {
+		outputs[r] = data[r*results.Cols : (r+1)*results.Cols]
+	}
+
+	return outputs
+}
+
+/* PredictAll is like QueryAll, but works in original units, like Predict. Inputs are not modified. */
+func (nn *NN) PredictAll(inputs [][]float32) [][]float32 {
+	if nn.InputScaler != nil {
+		scaled := make([][]float32, len(inputs))
+		for i := 0; i < len(inputs); i++ {
+			scaled[i] = append([]float32(nil), inputs[i]...)
+		}
+		nn.InputScaler.Transform(scaled)
+		inputs = scaled
+	}
+	outputs := nn.QueryAll(inputs)
+	if nn.OutputScaler != nil {
+		nn.OutputScaler.InverseTransform(outputs)
+	}
+	return outputs
+}
+
+/* Regression compares predicted outputs with expected ones. */
+func Regression(predicted, expected [][]float32) RegressionMetrics {
+	var squares, absolutes, percents, total float64
+	var m RegressionMetrics
+	var npercents int
+
+	if len(expected) == 0 {
+		return m
+	}
+
+	means := make([]float64, len(expected[0]))
+	for i := 0; i < len(expected); i++ {
+		for j := 0; j < len(expected[i]); j++ {
+			means[j] += float64(expected[i][j])
+		}
+	}
+	for j := 0; j < len(means); j++ {
+		means[j] /= float64(len(expected))
+	}
+
+	for i := 0; i < len(expected); i++ {
+		for j := 0; j < len(expected[i]); j++ {
+			diff := float64(predicted[i][j]) - float64(expected[i][j])
+			squares += diff * diff
+			absolutes += math.Abs(diff)
+			if expected[i][j] != 0 {
+				percents += math.Abs(diff / float64(expected[i][j]))
+				npercents++
+			}
+
+			deviation := float64(expected[i][j]) - means[j]
+			total += deviation * deviation
+		}
+		m.Count += len(expected[i])
+	}
+
+	m.MSE = float32(squares / float64(m.Count))
+	m.RMSE = float32(math.Sqrt(squares / float64(m.Count)))
+	m.MAE = float32(absolutes / float64(m.Count))
+	if npercents > 0 {
+		m.MAPE = float32(100 * percents / float64(npercents))
+	}
+	if total > 0 {
+		m.R2 = float32(1 - squares/total)
+	} else {
+		m.R2 = float32(math.NaN())
+	}
+
+	return m
+}
+
+/* scores returns scores of every class for outputs of NN, adding the negative class for NN with a single output. */
+func scores(outputs []float32) []float32 {
+	if len(outputs) == 1 {
+		return []float32{1 - outputs[0], outputs[0]}
+	}
+	return outputs
+}
+
+/* rocAUC returns probability that score of random positive sample is greater than score of random negative one. */
+func rocAUC(scores []float32, positive []bool) float32 {
+	var npositive, nnegative int
+	var ranks float64
+
+	order := identityOrder(len(scores))
+	sort.Slice(order, func(i, j int) bool { return scores[order[i]] < scores[order[j]] })
+
+	/* NOTE(anton2920): tied scores get their average rank. */
+	for i := 0; i < len(order); {
+		j := i
+		for (j < len(order)) && (scores[order[j]] == scores[order[i]]) {
+			j++
+		}
+		rank := 0.5 * float64(i+j+1)
+		for k := i; k < j; k++ {
+			if positive[order[k]] {
+				ranks += rank
+				npositive++
+			} else {
+				nnegative++
+			}
+		}
+		i = j
+	}
+
+	if (npositive == 0) || (nnegative == 0) {
+		return float32(math.NaN())
+	}
+	return float32((ranks - float64(npositive*(npositive+1))/2) / float64(npositive*nnegative))
+}
+
+/* Classification compares predicted classes with expected ones. */
+func Classification(predicted, expected [][]float32) ClassificationMetrics {
+	var m ClassificationMetrics
+	var correct int
+
+	if len(expected) == 0 {
+		return m
+	}
+
+	nclasses := len(scores(expected[0]))
+	m.Confusion = make([][]int, nclasses)
+	for c := 0; c < nclasses; c++ {
+		m.Confusion[c] = make([]int, nclasses)
+	}
+	for i := 0; i < len(expected); i++ {
+		e, p := Class(scores(expected[i])), Class(scores(predicted[i]))
+		m.Confusion[e][p]++
+		if e == p {
+			correct++
+		}
+	}
+	m.Accuracy = float32(correct) / float32(len(expected))
+
+	m.Precision = make([]float32, nclasses)
+	m.Recall = make([]float32, nclasses)
+	m.F1 = make([]float32, nclasses)
+	m.AUC = make([]float32, nclasses)
+	m.Support = make([]int, nclasses)
+
+	classScores := make([]float32, len(expected))
+	positive := make([]bool, len(expected))
+	for c := 0; c < nclasses; c++ {
+		var npredicted int
+
+		for e := 0; e < nclasses; e++ {
+			npredicted += m.Confusion[e][c]
+			m.Support[c] += m.Confusion[c][e]
+		}
+		m.Precision[c] = float32(m.Confusion[c][c]) / float32(npredicted)
+		m.Recall[c] = float32(m.Confusion[c][c]) / float32(m.Support[c])
+		if m.Precision[c]+m.Recall[c] > 0 {
+			m.F1[c] = 2 * m.Precision[c] * m.Recall[c] / (m.Precision[c] + m.Recall[c])
+		} else {
+			m.F1[c] = m.Precision[c] + m.Recall[c]
+		}
+
+		for i := 0; i < len(expected); i++ {
+			classScores[i] = scores(predicted[i])[c]
+			positive[i] = Class(scores(expected[i])) == c
+		}
+		m.AUC[c] = rocAUC(classScores, positive)
+	}
+
+	return m
+}
+
+func formatMetric(x float32) string {
+	return strconv.FormatFloat(float64(x), 'f', 4, 32)
+}
+
+/* WriteText writes metrics as a human-readable table. */
+func (m *RegressionMetrics) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "MSE:\t%s\n", formatMetric(m.MSE))
+	fmt.Fprintf(tw, "RMSE:\t%s\n", formatMetric(m.RMSE))
+	fmt.Fprintf(tw, "MAE:\t%s\n", formatMetric(m.MAE))
+	fmt.Fprintf(tw, "MAPE:\t%s%%\n", formatMetric(m.MAPE))
+	fmt.Fprintf(tw, "R²:\t%s\n", formatMetric(m.R2))
+	return tw.Flush()
+}
+
+/* WriteCSV writes metrics as "metric,value" rows. */
+func (m *RegressionMetrics) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"metric", "value"})
+	cw.Write([]string{"mse", formatMetric(m.MSE)})
+	cw.Write([]string{"rmse", formatMetric(m.RMSE)})
+	cw.Write([]string{"mae", formatMetric(m.MAE)})
+	cw.Write([]string{"mape", formatMetric(m.MAPE)})
+	cw.Write([]string{"r2", formatMetric(m.R2)})
+	cw.Flush()
+	return cw.Error()
+}
+
+func (m *ClassificationMetrics) name(c int) string {
+	if c < len(m.Names) {
+		return m.Names[c]
+	}
+	return strconv.Itoa(c)
+}
+
+/* macro returns unweighted average of per-class metric, skipping NaNs. */
+func macro(xs []float32) float32 {
+	var sum float32
+	var n int
+
+	for _, x := range xs {
+		if !math.IsNaN(float64(x)) {
+			sum += x
+			n++
+		}
+	}
+	if n == 0 {
+		return float32(math.NaN())
+	}
+	return sum / float32(n)
+}
+
+/* WriteText writes accuracy, per-class metrics with their macro averages and confusion matrix. */
+func (m *ClassificationMetrics) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Accuracy: %s\n\n", formatMetric(m.Accuracy))
+
+	fmt.Fprintf(tw, "Class\tPrecision\tRecall\tF1\tROC-AUC\tSupport\n")
+	for c := 0; c < len(m.Confusion); c++ {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%d\n", m.name(c), formatMetric(m.Precision[c]), formatMetric(m.Recall[c]), formatMetric(m.F1[c]), formatMetric(m.AUC[c]), m.Support[c])
+	}
+	fmt.Fprintf(tw, "Macro average\t%s\t%s\t%s\t%s\t\n\n", formatMetric(macro(m.Precision)), formatMetric(macro(m.Recall)), formatMetric(macro(m.F1)), formatMetric(macro(m.AUC)))
+
+	fmt.Fprintf(tw, "Expected \\ predicted")
+	for c := 0; c < len(m.Confusion); c++ {
+		fmt.Fprintf(tw, "\t%s", m.name(c))
+	}
+	fmt.Fprintln(tw)
+	for e := 0; e < len(m.Confusion); e++ {
+		fmt.Fprintf(tw, "%s", m.name(e))
+		for p := 0; p < len(m.Confusion[e]); p++ {
+			fmt.Fprintf(tw, "\t%d", m.Confusion[e][p])
+		}
+		fmt.Fprintln(tw)
+	}
+
+	return tw.Flush()
+}
+
+/* WriteCSV writes metrics as "metric,class,predicted,value" rows. Class is empty for accuracy, predicted class is set only for confusion matrix. */
+func (m *ClassificationMetrics) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+
+	cw.Write([]string{"metric", "class", "predicted", "value"})
+	cw.Write([]string{"accuracy", "", "", formatMetric(m.Accuracy)})
+	for c := 0; c < len(m.Confusion); c++ {
+		cw.Write([]string{"precision", m.name(c), "", formatMetric(m.Precision[c])})
+		cw.Write([]string{"recall", m.name(c), "", formatMetric(m.Recall[c])})
+		cw.Write([]string{"f1", m.name(c), "", formatMetric(m.F1[c])})
+		cw.Write([]string{"roc_auc", m.name(c), "", formatMetric(m.AUC[c])})
+		cw.Write([]string{"support", m.name(c), "", strconv.Itoa(m.Support[c])})
+	}
+	for e := 0; e < len(m.Confusion); e++ {
+		for p := 0; p < len(m.Confusion[e]); p++ {
+			cw.Write([]string{"confusion", m.name(e), m.name(p), strconv.Itoa(m.Confusion[e][p])})
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,814 @@
+package nn
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+)
+
+type Layer struct {
+	/* Kind is one of Layer* constants, dense layer by default. */
+	Kind       int
+	Neurons    int
+	FunctionID int
+
+	/* Weights has a row of input weights for every neuron. Recurrent layers have a row for every neuron of every gate, with weights of step inputs followed by weights of previous hidden state. Convolution layers have a row for every filter with weights of every row of kernel one after another. Pooling and flatten layers have no rows, but a column for every input. Normalization layers have a row with a single scale for every neuron, which takes input of the same index. */
+	Weights Matrix
+	Biases  []float32
+
+	/* Steps is the number of time steps every input row of recurrent layer is split into. Sequence makes it output hidden state of every step instead of only the last one. */
+	Steps    int
+	Sequence bool
+
+	/* Truncate, if set, limits backpropagation through time of recurrent layer to blocks of that many steps. */
+	Truncate int
+
+	/* Height and Width are dimensions of grid inputs of convolution and pooling layers are laid out in, with values of all channels of every position next to each other. One-dimensional layers ignore Height. Neurons is the number of filters of convolution layer, activation function of pooling and flatten layers is ignored. */
+	Height int
+	Width  int
+
+	/* Kernel is size of windows of convolution and pooling layers, square for two-dimensional ones, which are placed every Stride positions. Stride is 1 for convolution and Kernel for pooling by default. Windows never extend beyond grid. */
+	Kernel int
+	Stride int
+
+	/* Mean and Variance are running statistics of inputs of BatchNorm layer, which NN.Query normalizes them with instead of statistics of batch. Momentum is weight of every batch in them, DefaultNormMomentum by default. */
+	Mean     []float32
+	Variance []float32
+	Momentum float32
+
+	/* Dropout is a probability of dropping every output of hidden layer during training. It is ignored by NN.Query. */
+	Dropout float32
+
+	/* Initializer is used by NN.Init for weights of this layer. It is not stored with NN. */
+	Initializer Initializer
+}
+
+/* Gradients accumulates derivatives of error with respect to every weight and bias of NN over several samples. */
+type Gradients struct {
+	Weights []Matrix
+	Biases  [][]float32
+	Count   int
+}
+
+/* workspace holds weighted sums, outputs and deltas of every layer with a row per sample of a batch. Deltas are derivatives of loss with respect to weighted sums of dense, convolution and normalization layers and outputs of other ones. It is reused between batches. */
+type workspace struct {
+	sums    []Matrix
+	outputs []Matrix
+	deltas  []Matrix
+
+	/* stats hold means and variances normalization layers used. */
+	stats []Matrix
+
+	/* masks hold scales applied to outputs by dropout, if NN was queried in training mode. Training mode also makes BatchNorm use statistics of batch. */
+	masks    []Matrix
+	training bool
+
+	/* scratch is temporary storage of layers which need one for backward pass. */
+	scratch []float32
+}
+
+/* EpochMetrics is passed to TrainOptions.Callback after every epoch. Losses are averaged over samples. */
+type EpochMetrics struct {
+	Epoch          int
+	TrainingLoss   float32
+	ValidationLoss float32
+
+	/* Improved is set when validation loss is the best so far, so NN was checkpointed. */
+	Improved bool
+}
+
+type TrainOptions struct {
+	Optimizer       Optimizer
+	Schedule        Schedule
+	BatchSize       int
+	MaxEpochs       int
+	ValidationSplit float32
+
+	/* Workers is the number of goroutines every batch is split between. Results depend on it, but are reproducible for the same value. */
+	Workers int
+
+	/* Patience is the number of epochs without improvement of validation loss by more than MinDelta before training stops. 0 disables early stopping. */
+	Patience int
+	MinDelta float32
+
+	/* CheckpointFile, if set, receives the best NN every time validation loss improves. */
+	CheckpointFile string
+
+	/* Callback, if set, is called after every epoch. Returning StopTraining finishes training without error, any other error aborts it. */
+	Callback func(EpochMetrics) error
+}
+
+type NN struct {
+	Layers  []Layer
+	LossID  int
+	Trained bool
+
+	/* InputScaler and OutputScaler, if set, are fitted to training data and used by NN.Predict. They are stored with NN. */
+	InputScaler  *Scaler
+	OutputScaler *Scaler
+
+	Regularization Regularization
+
+	ws workspace
+}
+
+var StopTraining = errors.New("stop training")
+
+/* Seed is used for weights initialization, so training results are reproducible. */
+const Seed = 6585
+
+/* Query stores weighted sums and outputs of layer for every row of inputs into sums and outputs. Recurrent layers store weighted sums and states of every step into sums. BatchNorm uses running statistics. Layer itself is not modified. */
+func (l *Layer) Query(sums, outputs, inputs *Matrix) {
+	var stats Matrix
+	l.query(sums, &stats, outputs, inputs, false)
+}
+
+/* query is like Query, but stores statistics of normalization layers into stats and makes BatchNorm use statistics of batch in training mode. */
+func (l *Layer) query(sums, stats, outputs, inputs *Matrix, training bool) {
+	switch {
+	case l.Recurrent():
+		l.queryRecurrent(sums, outputs, inputs)
+		return
+	case l.Pooling():
+		l.queryPool(sums, outputs, inputs)
+		return
+	case l.Kind == LayerFlatten:
+		/* NOTE(anton2920): rows are always flat, so flatten layer only passes inputs through. */
+		sums.Resize(inputs.Rows, 0)
+		outputs.Resize(inputs.Rows, inputs.Cols)
+		copy(outputs.Data, inputs.Data)
+		return
+	case l.Convolutional():
+		l.queryConv(sums, inputs)
+	case l.Normalization():
+		l.queryNorm(sums, stats, inputs, training)
+	default:
+		MulTransposed(sums, inputs, &l.Weights, l.Biases)
+	}
+	outputs.Resize(sums.Rows, sums.Cols)
+
+	f := Functions[l.FunctionID]
+	for i := 0; i < len(sums.Data); i++ {
+		outputs.Data[i] = f.Activate(sums.Data[i])
+	}
+	if l.FunctionID == FunctionSoftmax {
+		for r := 0; r < outputs.Rows; r++ {
+			Softmax(outputs.Row(r))
+		}
+	}
+}
+
+func (ws *workspace) resize(nlayers int) {
+	if len(ws.outputs) != nlayers {
+		ws.sums = make([]Matrix, nlayers)
+		ws.outputs = make([]Matrix, nlayers)
+		ws.deltas = make([]Matrix, nlayers)
+		ws.stats = make([]Matrix, nlayers)
+		ws.masks = make([]Matrix, nlayers)
+	}
+}
+
+func loadFile(filename string, decode func(io.Reader) error) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := decode(bufio.NewReader(f)); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	return nil
+}
+
+func storeFile(filename string, encode func(io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := encode(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+/* Load reads NN from file in model file format. */
+func (nn *NN) Load(filename string) error {
+	return loadFile(filename, nn.Decode)
+}
+
+/* Store writes NN to file in model file format. */
+func (nn *NN) Store(filename string) error {
+	return storeFile(filename, nn.Encode)
+}
+
+func (nn *NN) LoadJSON(filename string) error {
+	return loadFile(filename, nn.ImportJSON)
+}
+
+func (nn *NN) StoreJSON(filename string) error {
+	return storeFile(filename, nn.ExportJSON)
+}
+
+func (nn *NN) StoreONNX(filename string) error {
+	return storeFile(filename, nn.ExportONNX)
+}
+
+/* Query returns outputs of NN for a single sample. Returned slice is valid until the next query. */
+func (nn *NN) Query(inputs []float32) []float32 {
+	return nn.QueryBatch(&Matrix{Rows: 1, Cols: len(inputs), Data: inputs}).Row(0)
+}
+
+/* Predict returns outputs of NN for a single sample in original units: inputs are scaled by InputScaler and outputs are scaled back by OutputScaler. Inputs are not modified, returned slice is valid until the next query. */
+func (nn *NN) Predict(inputs []float32) []float32 {
+	if nn.InputScaler != nil {
+		inputs = append([]float32(nil), inputs...)
+		nn.InputScaler.TransformRow(inputs)
+	}
+	outputs := nn.Query(inputs)
+	if nn.OutputScaler != nil {
+		nn.OutputScaler.InverseTransformRow(outputs)
+	}
+	return outputs
+}
+
+/* QueryBatch returns outputs of NN for every row of inputs. Returned matrix is valid until the next query. */
+func (nn *NN) QueryBatch(inputs *Matrix) *Matrix {
+	return nn.forward(&nn.ws, inputs, nil)
+}
+
+/* forward computes outputs of every layer into ws. If rng is not nil, NN is queried in training mode, which applies dropout to hidden layers. It only reads NN, so it is safe to call concurrently with different workspaces. */
+func (nn *NN) forward(ws *workspace, inputs *Matrix, rng *rand.Rand) *Matrix {
+	ws.resize(len(nn.Layers))
+	ws.training = rng != nil
+
+	outputs := inputs
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+
+		layer.query(&ws.sums[l], &ws.stats[l], &ws.outputs[l], outputs, ws.training)
+		if (ws.training) && (layer.Dropout > 0) && (l < len(nn.Layers)-1) {
+			dropout(rng, layer.Dropout, &ws.outputs[l], &ws.masks[l])
+		}
+		outputs = &ws.outputs[l]
+	}
+	return outputs
+}
+
+/* Init sets weights of every layer using its initializer. Biases are set to zero, unless default initializer is used. Layers must pass NN.Validate. */
+func (nn *NN) Init(ninputs int, rng *rand.Rand) {
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+
+		rows, fanIn := layer.shape(ninputs)
+		fanOut := layer.Neurons
+		ninputs = layer.numOutputs(ninputs)
+
+		layer.Weights.Resize(rows, fanIn)
+		layer.Biases = make([]float32, rows)
+		for n := 0; n < rows; n++ {
+			weights := layer.Weights.Row(n)
+
+			if layer.Initializer == nil {
+				for w := 0; w < len(weights); w++ {
+					weights[w] = SmallUniform(rng, fanIn, fanOut)
+				}
+				layer.Biases[n] = SmallUniform(rng, fanIn, fanOut)
+			} else {
+				for w := 0; w < len(weights); w++ {
+					weights[w] = layer.Initializer(rng, fanIn, fanOut)
+				}
+			}
+		}
+
+		/* NOTE(anton2920): LSTM starts with forget gate open, so gradients flow through cell state from the beginning. */
+		if layer.Kind == LayerLSTM {
+			for n := layer.Neurons; n < 2*layer.Neurons; n++ {
+				layer.Biases[n] = 1
+			}
+		}
+
+		/* NOTE(anton2920): normalization layers start with outputs of zero mean and unit variance, whatever initializer is. */
+		if layer.Normalization() {
+			for n := 0; n < rows; n++ {
+				layer.Weights.Data[n] = 1
+				layer.Biases[n] = 0
+			}
+		}
+		if layer.Kind == LayerBatchNorm {
+			layer.Mean = make([]float32, rows)
+			layer.Variance = make([]float32, rows)
+			for n := 0; n < rows; n++ {
+				layer.Variance[n] = 1
+			}
+		}
+	}
+}
+
+/* copyParameters replaces weights and biases of NN with ones from src, allocating them if needed. */
+func (nn *NN) copyParameters(src *NN) {
+	if len(nn.Layers) != len(src.Layers) {
+		nn.Layers = make([]Layer, len(src.Layers))
+	}
+
+	for l := 0; l < len(src.Layers); l++ {
+		layer := &nn.Layers[l]
+		srcLayer := &src.Layers[l]
+
+		layer.Kind = srcLayer.Kind
+		layer.Neurons = srcLayer.Neurons
+		layer.FunctionID = srcLayer.FunctionID
+		layer.Steps = srcLayer.Steps
+		layer.Sequence = srcLayer.Sequence
+		layer.Truncate = srcLayer.Truncate
+		layer.Height = srcLayer.Height
+		layer.Width = srcLayer.Width
+		layer.Kernel = srcLayer.Kernel
+		layer.Stride = srcLayer.Stride
+		layer.Mean = append(layer.Mean[:0], srcLayer.Mean...)
+		layer.Variance = append(layer.Variance[:0], srcLayer.Variance...)
+		layer.Momentum = srcLayer.Momentum
+		layer.Dropout = srcLayer.Dropout
+		layer.Weights.Resize(srcLayer.Weights.Rows, srcLayer.Weights.Cols)
+		copy(layer.Weights.Data, srcLayer.Weights.Data)
+		layer.Biases = append(layer.Biases[:0], srcLayer.Biases...)
+	}
+}
+
+/* NewGradients returns zeroed gradients with the same shape as NN weights and biases. */
+func (nn *NN) NewGradients() *Gradients {
+	var g Gradients
+
+	g.Weights = make([]Matrix, len(nn.Layers))
+	g.Biases = make([][]float32, len(nn.Layers))
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+
+		g.Weights[l].Resize(layer.Weights.Rows, layer.Weights.Cols)
+		g.Biases[l] = make([]float32, layer.Weights.Rows)
+	}
+
+	return &g
+}
+
+func (g *Gradients) Reset() {
+	for l := 0; l < len(g.Weights); l++ {
+		g.Weights[l].Zero()
+		for n := 0; n < len(g.Biases[l]); n++ {
+			g.Biases[l][n] = 0
+		}
+	}
+	g.Count = 0
+}
+
+/* Add adds gradients from src to g. */
+func (g *Gradients) Add(src *Gradients) {
+	for l := 0; l < len(g.Weights); l++ {
+		axpy(1, src.Weights[l].Data, g.Weights[l].Data)
+		axpy(1, src.Biases[l], g.Biases[l])
+	}
+	g.Count += src.Count
+}
+
+/* Loss returns value of NN loss function for the last NN.Query. */
+func (nn *NN) Loss(expected []float32) float32 {
+	return Losses[nn.LossID].Loss(nn.ws.outputs[len(nn.Layers)-1].Row(0), expected)
+}
+
+/* outputCoef stores derivatives of loss with respect to weighted sums of output layer into coef. */
+func (nn *NN) outputCoef(sums, outputs, expected, coef []float32) {
+	layer := &nn.Layers[len(nn.Layers)-1]
+
+	switch {
+	case (layer.FunctionID == FunctionSoftmax) && (nn.LossID == LossCrossEntropy):
+		/* NOTE(anton2920): fused gradient of softmax with cross-entropy is stable even for zero probabilities. */
+		for n := 0; n < len(coef); n++ {
+			coef[n] = outputs[n] - expected[n]
+		}
+	case layer.FunctionID == FunctionSoftmax:
+		var dot float32
+
+		Losses[nn.LossID].Gradient(outputs, expected, coef)
+		for n := 0; n < len(coef); n++ {
+			dot += coef[n] * outputs[n]
+		}
+		for n := 0; n < len(coef); n++ {
+			coef[n] = outputs[n] * (coef[n] - dot)
+		}
+	case !layer.activated():
+		/* NOTE(anton2920): recurrent, pooling and flatten layers take derivatives with respect to their outputs. */
+		Losses[nn.LossID].Gradient(outputs, expected, coef)
+	default:
+		f := Functions[layer.FunctionID]
+		Losses[nn.LossID].Gradient(outputs, expected, coef)
+		for n := 0; n < len(coef); n++ {
+			coef[n] *= f.Derivative(sums[n])
+		}
+	}
+}
+
+/* Backpropagate adds gradients of loss for the last NN.QueryBatch(inputs) to g. Rows with nil expected outputs are skipped. */
+func (nn *NN) Backpropagate(inputs *Matrix, expected [][]float32, g *Gradients) {
+	nn.backward(&nn.ws, inputs, expected, g)
+}
+
+/* backward adds gradients of loss for outputs stored in ws by NN.forward to g. Like NN.forward, it only reads NN. */
+func (nn *NN) backward(ws *workspace, inputs *Matrix, expected [][]float32, g *Gradients) {
+	l := len(nn.Layers) - 1
+	outputs := &ws.outputs[l]
+	ws.deltas[l].Resize(outputs.Rows, outputs.Cols)
+	for r := 0; r < outputs.Rows; r++ {
+		deltas := ws.deltas[l].Row(r)
+		if expected[r] == nil {
+			for n := 0; n < len(deltas); n++ {
+				deltas[n] = 0
+			}
+			continue
+		}
+		nn.outputCoef(ws.sums[l].Row(r), outputs.Row(r), expected[r], deltas)
+		g.Count++
+	}
+
+	for ; l >= 0; l-- {
+		layer := &nn.Layers[l]
+		deltas := &ws.deltas[l]
+
+		layerInputs := inputs
+		if l > 0 {
+			layerInputs = &ws.outputs[l-1]
+		}
+
+		var prevDeltas *Matrix
+		if l > 0 {
+			prevDeltas = &ws.deltas[l-1]
+		}
+
+		switch {
+		case layer.Recurrent():
+			layer.backwardRecurrent(ws, &ws.sums[l], deltas, layerInputs, prevDeltas, &g.Weights[l], g.Biases[l])
+		case layer.Convolutional():
+			layer.backwardConv(deltas, layerInputs, prevDeltas, &g.Weights[l], g.Biases[l])
+		case layer.Normalization():
+			layer.backwardNorm(&ws.stats[l], deltas, layerInputs, prevDeltas, &g.Weights[l], g.Biases[l], ws.training)
+		case layer.Pooling():
+			if prevDeltas != nil {
+				layer.backwardPool(&ws.sums[l], deltas, layerInputs, prevDeltas)
+			}
+		case layer.Kind == LayerFlatten:
+			if prevDeltas != nil {
+				prevDeltas.Resize(deltas.Rows, deltas.Cols)
+				copy(prevDeltas.Data, deltas.Data)
+			}
+		default:
+			AddTransposedMul(&g.Weights[l], deltas, layerInputs)
+			for r := 0; r < deltas.Rows; r++ {
+				axpy(1, deltas.Row(r), g.Biases[l])
+			}
+			if prevDeltas != nil {
+				Mul(prevDeltas, deltas, &layer.Weights)
+			}
+		}
+
+		if l > 0 {
+			prevLayer := &nn.Layers[l-1]
+			if prevLayer.activated() {
+				prevSums := &ws.sums[l-1]
+				f := Functions[prevLayer.FunctionID]
+				for i := 0; i < len(prevDeltas.Data); i++ {
+					prevDeltas.Data[i] *= f.Derivative(prevSums.Data[i])
+				}
+			}
+			if (ws.training) && (prevLayer.Dropout > 0) {
+				mask := ws.masks[l-1].Data
+				for i := 0; i < len(prevDeltas.Data); i++ {
+					prevDeltas.Data[i] *= mask[i]
+				}
+			}
+		}
+	}
+}
+
+func shuffle(rng *rand.Rand, order []int) {
+	rng.Shuffle(len(order), func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+}
+
+func identityOrder(n int) []int {
+	order := make([]int, n)
+	for i := 0; i < len(order); i++ {
+		order[i] = i
+	}
+	return order
+}
+
+/* packRows copies rows of data selected by indices into m. */
+func packRows(m *Matrix, data [][]float32, indices []int) {
+	m.Resize(len(indices), len(data[indices[0]]))
+	for r, i := range indices {
+		copy(m.Row(r), data[i])
+	}
+}
+
+/* epsShard returns function which trains worker on samples with outputs outside of eps. */
+func (nn *NN) epsShard(inputs, outputs [][]float32, eps float32) func(*worker, []int) {
+	/* NOTE(anton2920): only samples with outputs outside of eps contribute to gradients. */
+	loss := Losses[nn.LossID]
+	return func(w *worker, indices []int) {
+		var needsTraining bool
+
+		packRows(&w.batch, inputs, indices)
+		results := nn.forward(&w.ws, &w.batch, w.rng)
+
+		for r, i := range indices {
+			w.loss += loss.Loss(results.Row(r), outputs[i])
+			w.expected[r] = nil
+			for j := 0; j < len(outputs[i]); j++ {
+				if math.Abs(float64(outputs[i][j]-results.Row(r)[j])) > float64(eps) {
+					w.expected[r] = outputs[i]
+					needsTraining = true
+					break
+				}
+			}
+		}
+
+		if needsTraining {
+			nn.backward(&w.ws, &w.batch, w.expected[:len(indices)], w.g)
+		}
+	}
+}
+
+/* validateBatchSize checks that NN can be trained on batches of batchSize samples. BatchNorm turns every input of batch of one sample into zero. */
+func (nn *NN) validateBatchSize(batchSize int) error {
+	for l := 0; l < len(nn.Layers); l++ {
+		if (nn.Layers[l].Kind == LayerBatchNorm) && (batchSize < 2) {
+			return fmt.Errorf("layer #%d: %s layer needs batches of at least 2 samples, got %d", l, LayerNames[LayerBatchNorm], batchSize)
+		}
+	}
+	return nil
+}
+
+/* Train trains NN until every output is within eps of expected one, updating weights after every batchSize samples (0 means all), split between workers goroutines. Schedule, if not nil, sets learning rate for every epoch. */
+func (nn *NN) Train(inputs, outputs [][]float32, batchSize int, workers int, optimizer Optimizer, schedule Schedule, eps float32, maxTrainingCount int) (int, error) {
+	var done bool
+	var count int
+
+	if err := nn.Validate(len(inputs[0])); err != nil {
+		return 0, err
+	}
+	rng := rand.New(rand.NewSource(Seed))
+	nn.Init(len(inputs[0]), rng)
+	optimizer.Reset()
+	if schedule != nil {
+		schedule.Reset()
+	}
+
+	if (batchSize <= 0) || (batchSize > len(inputs)) {
+		batchSize = len(inputs)
+	}
+	if err := nn.validateBatchSize(batchSize); err != nil {
+		return 0, err
+	}
+	p := nn.newPool(workers, batchSize)
+	order := identityOrder(len(inputs))
+
+	trainShard := nn.epsShard(inputs, outputs, eps)
+
+	for !done {
+		if count > maxTrainingCount {
+			return 0, fmt.Errorf("count exceeded %d", maxTrainingCount)
+		}
+
+		var epochLoss float32
+
+		if schedule != nil {
+			optimizer.SetRate(schedule.At(count))
+		}
+
+		done = true
+		shuffle(rng, order)
+		for k := 0; k < len(order); k += batchSize {
+			g := p.run(order[k:min(k+batchSize, len(order))], trainShard)
+			nn.updateStatistics(p)
+			if g.Count > 0 {
+				done = false
+				nn.regularize(g)
+				optimizer.Update(nn, g)
+			}
+			epochLoss += p.loss()
+		}
+		observe(schedule, epochLoss/float32(len(order)))
+
+		count++
+	}
+
+	return count, nil
+}
+
+/* TrainSource trains NN like Train, but reads samples from source, starting it over every epoch, so they do not have to fit in memory. Every row of source has ninputs inputs followed by outputs. Samples are used in order of source, wrap it in ShuffleSource to shuffle them. */
+func (nn *NN) TrainSource(source Source, ninputs int, batchSize int, workers int, optimizer Optimizer, schedule Schedule, eps float32, maxTrainingCount int) (int, error) {
+	var done bool
+	var count int
+
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+
+	if err := nn.Validate(ninputs); err != nil {
+		return 0, err
+	}
+	if err := nn.validateBatchSize(batchSize); err != nil {
+		return 0, err
+	}
+	rng := rand.New(rand.NewSource(Seed))
+	nn.Init(ninputs, rng)
+	optimizer.Reset()
+	if schedule != nil {
+		schedule.Reset()
+	}
+
+	p := nn.newPool(workers, batchSize)
+	order := identityOrder(batchSize)
+	rows := make([][]float32, batchSize)
+	inputs := make([][]float32, batchSize)
+	outputs := make([][]float32, batchSize)
+	trainShard := nn.epsShard(inputs, outputs, eps)
+
+	for !done {
+		if count > maxTrainingCount {
+			return 0, fmt.Errorf("count exceeded %d", maxTrainingCount)
+		}
+
+		var epochLoss float32
+		var nsamples int
+
+		if schedule != nil {
+			optimizer.SetRate(schedule.At(count))
+		}
+		if err := source.Reset(); err != nil {
+			return 0, err
+		}
+
+		done = true
+		for {
+			n, err := readBatch(source, ninputs, rows, inputs, outputs)
+			if err != nil {
+				return 0, fmt.Errorf("sample #%d: %w", nsamples+n, err)
+			}
+			if n == 0 {
+				break
+			}
+
+			g := p.run(order[:n], trainShard)
+			nn.updateStatistics(p)
+			if g.Count > 0 {
+				done = false
+				nn.regularize(g)
+				optimizer.Update(nn, g)
+			}
+			epochLoss += p.loss()
+			nsamples += n
+		}
+		if nsamples == 0 {
+			return 0, errors.New("source has no samples")
+		}
+		observe(schedule, epochLoss/float32(nsamples))
+
+		count++
+	}
+
+	return count, nil
+}
+
+/* TrainingSamples returns number of the first samples out of nsamples TrainValidate trains on with the given validation split. The rest are used for validation. */
+func TrainingSamples(nsamples int, validationSplit float32) int {
+	return int(float32(nsamples) * (1 - validationSplit))
+}
+
+/* TrainValidate trains NN for at most options.MaxEpochs, stopping early if validation loss does not improve and restoring the best NN. The last options.ValidationSplit of samples are used for validation only. */
+func (nn *NN) TrainValidate(inputs, outputs [][]float32, options *TrainOptions) (int, error) {
+	var currentEpoch, countdown int
+	var validation Matrix
+	var best NN
+
+	if (options.ValidationSplit < 0) || (options.ValidationSplit >= 1) {
+		return 0, fmt.Errorf("validation split %g is out of range [0; 1)", options.ValidationSplit)
+	}
+	ntraining := TrainingSamples(len(inputs), options.ValidationSplit)
+	if ntraining == 0 {
+		return 0, fmt.Errorf("validation split %g leaves no training samples out of %d", options.ValidationSplit, len(inputs))
+	}
+
+	if err := nn.Validate(len(inputs[0])); err != nil {
+		return 0, err
+	}
+	rng := rand.New(rand.NewSource(Seed))
+	nn.Init(len(inputs[0]), rng)
+	options.Optimizer.Reset()
+	if options.Schedule != nil {
+		options.Schedule.Reset()
+	}
+
+	order := identityOrder(ntraining)
+	batchSize := options.BatchSize
+	if (batchSize <= 0) || (batchSize > len(order)) {
+		batchSize = len(order)
+	}
+	if err := nn.validateBatchSize(batchSize); err != nil {
+		return 0, err
+	}
+	p := nn.newPool(options.Workers, batchSize)
+
+	/* NOTE(anton2920): validation samples are the last ones, right after training ones. Without them training samples are used. */
+	validationStart := len(order)
+	if validationStart == len(inputs) {
+		validationStart = 0
+	}
+	validation.SetRows(inputs[validationStart:])
+	validationOutputs := outputs[validationStart:]
+
+	loss := Losses[nn.LossID]
+	trainShard := func(w *worker, indices []int) {
+		packRows(&w.batch, inputs, indices)
+		results := nn.forward(&w.ws, &w.batch, w.rng)
+
+		for r, i := range indices {
+			w.loss += loss.Loss(results.Row(r), outputs[i])
+			w.expected[r] = outputs[i]
+		}
+		nn.backward(&w.ws, &w.batch, w.expected[:len(indices)], w.g)
+	}
+
+	minLoss := float32(math.Inf(1))
+	restoreBest := func() {
+		if best.Layers != nil {
+			nn.copyParameters(&best)
+		}
+	}
+
+	for currentEpoch < options.MaxEpochs {
+		var metrics EpochMetrics
+
+		if options.Schedule != nil {
+			options.Optimizer.SetRate(options.Schedule.At(currentEpoch))
+		}
+
+		shuffle(rng, order)
+		for k := 0; k < len(order); k += batchSize {
+			g := p.run(order[k:min(k+batchSize, len(order))], trainShard)
+			nn.updateStatistics(p)
+			nn.regularize(g)
+			options.Optimizer.Update(nn, g)
+			metrics.TrainingLoss += p.loss()
+		}
+		metrics.TrainingLoss /= float32(len(order))
+
+		results := nn.QueryBatch(&validation)
+		for r := 0; r < results.Rows; r++ {
+			metrics.ValidationLoss += loss.Loss(results.Row(r), validationOutputs[r])
+		}
+		metrics.ValidationLoss /= float32(results.Rows)
+		observe(options.Schedule, metrics.ValidationLoss)
+		metrics.Epoch = currentEpoch
+		currentEpoch++
+
+		if metrics.ValidationLoss < minLoss-options.MinDelta {
+			minLoss = metrics.ValidationLoss
+			metrics.Improved = true
+			countdown = 0
+
+			best.copyParameters(nn)
+			if options.CheckpointFile != "" {
+				if err := nn.Store(options.CheckpointFile); err != nil {
+					return currentEpoch, fmt.Errorf("failed to store checkpoint: %w", err)
+				}
+			}
+		}
+
+		if options.Callback != nil {
+			if err := options.Callback(metrics); err != nil {
+				restoreBest()
+				if err == StopTraining {
+					return currentEpoch, nil
+				}
+				return currentEpoch, err
+			}
+		}
+
+		countdown++
+		if (options.Patience > 0) && (countdown > options.Patience) {
+			break
+		}
+	}
+	restoreBest()
+
+	return currentEpoch, nil
+}
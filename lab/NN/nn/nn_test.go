@@ -0,0 +1,2438 @@
+package nn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var (
+	testInputs  = [][]float32{{0, 0}, {0, 1}, {1, 0}, {1, 1}}
+	testOutputs = [][]float32{{0, 0}, {0, 1}, {0, 1}, {1, 1}}
+)
+
+func testNN() NN {
+	return NN{
+		Layers: []Layer{
+			{Neurons: 4, FunctionID: FunctionTh},
+			{Neurons: 2, FunctionID: FunctionSigmoid},
+		},
+	}
+}
+
+func TestNNTrain(t *testing.T) {
+	const eps = 0.1
+
+	for _, batchSize := range [...]int{1, 2, 0} {
+		t.Run(fmt.Sprintf("batch=%d", batchSize), func(t *testing.T) {
+			nn := testNN()
+			if _, err := nn.Train(testInputs, testOutputs, batchSize, 1, NewSGD(0.5), nil, eps, 100000); err != nil {
+				t.Fatalf("Failed to train NN: %s", err.Error())
+			}
+
+			for i := 0; i < len(testInputs); i++ {
+				outputs := nn.Query(testInputs[i])
+				for j, output := range outputs {
+					if math.Abs(float64(output-testOutputs[i][j])) > eps {
+						t.Errorf("NN failed to compute output #%d of %v: expected %.2f, got %.2f", j, testInputs[i], testOutputs[i][j], output)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNNTrainWorkers(t *testing.T) {
+	const eps = 0.1
+
+	for _, workers := range [...]int{2, 3, 8} {
+		t.Run(fmt.Sprintf("workers=%d", workers), func(t *testing.T) {
+			var counts [2]int
+			var nns [2]NN
+
+			for k := 0; k < len(nns); k++ {
+				var err error
+
+				nns[k] = testNN()
+				counts[k], err = nns[k].Train(testInputs, testOutputs, 0, workers, NewSGD(0.5), nil, eps, 100000)
+				if err != nil {
+					t.Fatalf("Failed to train NN: %s", err.Error())
+				}
+			}
+
+			if counts[0] != counts[1] {
+				t.Errorf("Expected the same number of epochs, got %d and %d", counts[0], counts[1])
+			}
+			for l := 0; l < len(nns[0].Layers); l++ {
+				for w, weight := range nns[0].Layers[l].Weights.Data {
+					if weight != nns[1].Layers[l].Weights.Data[w] {
+						t.Fatalf("Weight #%d of layer #%d differs between runs: %f and %f", w, l, weight, nns[1].Layers[l].Weights.Data[w])
+					}
+				}
+			}
+
+			for i := 0; i < len(testInputs); i++ {
+				outputs := nns[0].Query(testInputs[i])
+				for j, output := range outputs {
+					if math.Abs(float64(output-testOutputs[i][j])) > eps {
+						t.Errorf("NN failed to compute output #%d of %v: expected %.2f, got %.2f", j, testInputs[i], testOutputs[i][j], output)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNNQueryBatch(t *testing.T) {
+	var inputs Matrix
+
+	nn := testNN()
+	nn.Init(len(testInputs[0]), rand.New(rand.NewSource(Seed)))
+
+	inputs.SetRows(testInputs)
+	results := nn.QueryBatch(&inputs)
+	batchOutputs := append([]float32(nil), results.Data...)
+
+	for i := 0; i < len(testInputs); i++ {
+		outputs := nn.Query(testInputs[i])
+		for j, output := range outputs {
+			if batchOutputs[i*len(outputs)+j] != output {
+				t.Errorf("Batch output #%d of %v differs from single query: expected %f, got %f", j, testInputs[i], output, batchOutputs[i*len(outputs)+j])
+			}
+		}
+	}
+}
+
+func TestOptimizers(t *testing.T) {
+	const eps = 0.1
+
+	optimizers := [...]struct {
+		Name      string
+		Optimizer Optimizer
+	}{
+		{"sgd", NewSGD(0.5)},
+		{"momentum", NewMomentum(0.5, 0.9)},
+		{"nesterov", NewNesterov(0.5, 0.9)},
+		{"rmsprop", NewRMSProp(0.01)},
+		{"adam", NewAdam(0.01)},
+	}
+
+	for _, o := range optimizers {
+		optimizer := o.Optimizer
+		t.Run(o.Name, func(t *testing.T) {
+			nn := testNN()
+			if _, err := nn.Train(testInputs, testOutputs, 0, 1, optimizer, nil, eps, 100000); err != nil {
+				t.Fatalf("Failed to train NN: %s", err.Error())
+			}
+
+			for i := 0; i < len(testInputs); i++ {
+				outputs := nn.Query(testInputs[i])
+				for j, output := range outputs {
+					if math.Abs(float64(output-testOutputs[i][j])) > eps {
+						t.Errorf("NN failed to compute output #%d of %v: expected %.2f, got %.2f", j, testInputs[i], testOutputs[i][j], output)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLosses(t *testing.T) {
+	const eps = 0.1
+
+	losses := [...]struct {
+		Name       string
+		LossID     int
+		FunctionID int
+	}{
+		{"mse", LossMSE, FunctionSigmoid},
+		{"mae", LossMAE, FunctionSigmoid},
+		{"huber", LossHuber, FunctionSigmoid},
+		{"softmax-mse", LossMSE, FunctionSoftmax},
+		{"softmax-cross-entropy", LossCrossEntropy, FunctionSoftmax},
+	}
+
+	/* NOTE(anton2920): one-hot encoded AND. */
+	outputs := [][]float32{{1, 0}, {1, 0}, {1, 0}, {0, 1}}
+
+	for _, l := range losses {
+		lossID := l.LossID
+		functionID := l.FunctionID
+		t.Run(l.Name, func(t *testing.T) {
+			nn := testNN()
+			nn.Layers[len(nn.Layers)-1].FunctionID = functionID
+			nn.LossID = lossID
+			if _, err := nn.Train(testInputs, outputs, 0, 1, NewAdam(0.05), nil, eps, 100000); err != nil {
+				t.Fatalf("Failed to train NN: %s", err.Error())
+			}
+
+			for i := 0; i < len(testInputs); i++ {
+				results := nn.Query(testInputs[i])
+
+				var sum float32
+				for j, result := range results {
+					if math.Abs(float64(result-outputs[i][j])) > eps {
+						t.Errorf("NN failed to compute output #%d of %v: expected %.2f, got %.2f", j, testInputs[i], outputs[i][j], result)
+					}
+					sum += result
+				}
+				if (functionID == FunctionSoftmax) && (math.Abs(float64(sum-1)) > 1e-5) {
+					t.Errorf("Softmax outputs for %v do not sum up to 1: %f", testInputs[i], sum)
+				}
+			}
+		})
+	}
+
+	t.Run("hidden-softmax", func(t *testing.T) {
+		for _, kind := range [...]int{LayerDense, LayerBatchNorm, LayerLayerNorm} {
+			nn := NN{
+				Layers: []Layer{
+					{Neurons: 2, FunctionID: FunctionTh},
+					{Kind: kind, Neurons: 2, FunctionID: FunctionSoftmax},
+					{Neurons: 2, FunctionID: FunctionSoftmax},
+				},
+				LossID: LossCrossEntropy,
+			}
+			if err := nn.Validate(len(testInputs[0])); err == nil {
+				t.Errorf("Expected error for softmax in hidden %s layer", LayerNames[kind])
+			}
+			nn.Layers[1].FunctionID = FunctionTh
+			if err := nn.Validate(len(testInputs[0])); err != nil {
+				t.Errorf("Failed to validate NN with softmax output layer: %s", err.Error())
+			}
+		}
+	})
+}
+
+func TestActivations(t *testing.T) {
+	const eps = 0.1
+
+	for functionID := 0; functionID < len(Functions); functionID++ {
+		if functionID == FunctionSoftmax {
+			continue
+		}
+		t.Run(FunctionNames[functionID], func(t *testing.T) {
+			if id, ok := FunctionByName(FunctionNames[functionID]); (!ok) || (id != functionID) {
+				t.Errorf("Expected %q to have ID %d, got %d", FunctionNames[functionID], functionID, id)
+			}
+
+			nn := testNN()
+			nn.Layers[0].FunctionID = functionID
+			if _, err := nn.Train(testInputs, testOutputs, 0, 1, NewAdam(0.05), nil, eps, 100000); err != nil {
+				t.Fatalf("Failed to train NN: %s", err.Error())
+			}
+
+			for i := 0; i < len(testInputs); i++ {
+				outputs := nn.Query(testInputs[i])
+				for j, output := range outputs {
+					if math.Abs(float64(output-testOutputs[i][j])) > eps {
+						t.Errorf("NN failed to compute output #%d of %v: expected %.2f, got %.2f", j, testInputs[i], testOutputs[i][j], output)
+					}
+				}
+			}
+		})
+	}
+
+	values := [...]struct {
+		FunctionID int
+		X, Y       float32
+	}{
+		{FunctionLeakyReLU, -2, -0.2},
+		{FunctionELU, -1, -0.63212055},
+		{FunctionGELU, 1, 0.8413447},
+		{FunctionSoftplus, 0, math.Ln2},
+		{FunctionSoftplus, 100, 100},
+		{FunctionSwish, 1, 0.7310586},
+	}
+	for _, v := range values {
+		if y := Functions[v.FunctionID].Activate(v.X); math.Abs(float64(y-v.Y)) > 1e-6 {
+			t.Errorf("Expected %s(%f) to be %f, got %f", FunctionNames[v.FunctionID], v.X, v.Y, y)
+		}
+	}
+}
+
+func TestSchedules(t *testing.T) {
+	schedules := [...]struct {
+		Name     string
+		Schedule Schedule
+		Rates    []float32
+	}{
+		{"constant", NewConstantRate(0.1), []float32{0.1, 0.1, 0.1, 0.1}},
+		{"step", NewStepDecay(0.1, 0.5, 2), []float32{0.1, 0.1, 0.05, 0.05}},
+		{"exponential", NewExponentialDecay(0.1, 1), []float32{0.1, 0.1 / math.E, 0.1 / (math.E * math.E), 0.1 / (math.E * math.E * math.E)}},
+		{"cosine", NewCosineAnnealing(0.1, 0, 2), []float32{0.1, 0.05, 0, 0}},
+		{"warmup", NewWarmup(2, NewConstantRate(0.1)), []float32{0.05, 0.1, 0.1, 0.1}},
+	}
+
+	for _, s := range schedules {
+		schedule := s.Schedule
+		rates := s.Rates
+		t.Run(s.Name, func(t *testing.T) {
+			schedule.Reset()
+			for step, expected := range rates {
+				if rate := schedule.At(step); math.Abs(float64(rate-expected)) > 1e-6 {
+					t.Errorf("Expected rate %f at step %d, got %f", expected, step, rate)
+				}
+			}
+		})
+	}
+
+	t.Run("plateau", func(t *testing.T) {
+		schedule := NewReduceOnPlateau(0.1, 0.5, 1)
+
+		losses := [...]float32{1, 0.5, 0.6, 0.7, 0.4, 0.4, 0.4}
+		expected := [...]float32{0.1, 0.1, 0.1, 0.05, 0.05, 0.05, 0.025}
+		for i, loss := range losses {
+			schedule.Observe(loss)
+			if rate := schedule.At(i); rate != expected[i] {
+				t.Errorf("Expected rate %f after loss #%d, got %f", expected[i], i, rate)
+			}
+		}
+
+		schedule.Reset()
+		if rate := schedule.At(0); rate != 0.1 {
+			t.Errorf("Expected rate %f after reset, got %f", 0.1, rate)
+		}
+	})
+
+	t.Run("train", func(t *testing.T) {
+		optimizer := NewSGD(0)
+		schedule := NewCosineAnnealing(1, 0.1, 10)
+
+		nn := testNN()
+		if _, err := nn.TrainValidate(testInputs, testOutputs, &TrainOptions{
+			Optimizer: optimizer,
+			Schedule:  schedule,
+			MaxEpochs: 20,
+			Callback: func(metrics EpochMetrics) error {
+				if expected := schedule.At(metrics.Epoch); optimizer.Rate != expected {
+					t.Errorf("Expected rate %f at epoch %d, got %f", expected, metrics.Epoch, optimizer.Rate)
+				}
+				return nil
+			},
+		}); err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+	})
+}
+
+func TestGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(Seed))
+
+	for outputID := 0; outputID < len(Functions); outputID++ {
+		for hiddenID := 0; hiddenID < len(Functions); hiddenID++ {
+			if hiddenID == FunctionSoftmax {
+				continue
+			}
+			for lossID := 0; lossID < len(Losses); lossID++ {
+				/* NOTE(anton2920): cross-entropy needs outputs to be probabilities. */
+				if (lossID == LossCrossEntropy) && (outputID != FunctionSigmoid) && (outputID != FunctionSoftmax) {
+					continue
+				}
+				t.Run(fmt.Sprintf("%s-%s-%s", FunctionNames[hiddenID], FunctionNames[outputID], LossNames[lossID]), func(t *testing.T) {
+					nn := NN{
+						Layers: []Layer{
+							{Neurons: 4, FunctionID: hiddenID, Initializer: XavierUniform},
+							{Neurons: 3, FunctionID: hiddenID, Initializer: XavierUniform},
+							{Neurons: 3, FunctionID: outputID, Initializer: XavierUniform},
+						},
+						LossID: lossID,
+					}
+					nn.Init(2, rng)
+					for l := 0; l < len(nn.Layers); l++ {
+						for n := 0; n < len(nn.Layers[l].Biases); n++ {
+							nn.Layers[l].Biases[n] = 2*rng.Float32() - 1
+						}
+					}
+
+					inputs := make([][]float32, 5)
+					outputs := make([][]float32, len(inputs))
+					for i := 0; i < len(inputs); i++ {
+						inputs[i] = []float32{2*rng.Float32() - 1, 2*rng.Float32() - 1}
+						outputs[i] = make([]float32, 3)
+						if lossID == LossCrossEntropy {
+							outputs[i][rng.Intn(len(outputs[i]))] = 1
+						} else {
+							for j := 0; j < len(outputs[i]); j++ {
+								outputs[i][j] = 2*rng.Float32() - 1
+							}
+						}
+					}
+
+					if err := nn.CheckGradients(inputs, outputs, 1e-2); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		}
+	}
+}
+
+/* testSequences returns n random sequences of steps with ninputs values each and random outputs. */
+func testSequences(rng *rand.Rand, n, steps, ninputs, noutputs int) ([][]float32, [][]float32) {
+	inputs := make([][]float32, n)
+	outputs := make([][]float32, n)
+	for i := 0; i < n; i++ {
+		inputs[i] = make([]float32, steps*ninputs)
+		for j := 0; j < len(inputs[i]); j++ {
+			inputs[i][j] = 2*rng.Float32() - 1
+		}
+		outputs[i] = make([]float32, noutputs)
+		for j := 0; j < len(outputs[i]); j++ {
+			outputs[i][j] = 2*rng.Float32() - 1
+		}
+	}
+	return inputs, outputs
+}
+
+func TestRecurrent(t *testing.T) {
+	rng := rand.New(rand.NewSource(Seed))
+
+	t.Run("gradients", func(t *testing.T) {
+		for _, kind := range [...]int{LayerRNN, LayerLSTM, LayerGRU} {
+			for _, truncate := range [...]int{0, 2} {
+				for _, sequence := range [...]bool{false, true} {
+					t.Run(fmt.Sprintf("%s-truncate=%d-sequence=%v", LayerNames[kind], truncate, sequence), func(t *testing.T) {
+						noutputs := 2
+						if sequence {
+							noutputs = 4 * 3
+						}
+						nn := NN{
+							Layers: []Layer{
+								{Kind: kind, Neurons: 3, FunctionID: FunctionTh, Steps: 4, Sequence: true, Initializer: XavierUniform},
+								{Kind: kind, Neurons: 3, FunctionID: FunctionTh, Steps: 4, Sequence: sequence, Truncate: truncate, Initializer: XavierUniform},
+							},
+							LossID: LossMSE,
+						}
+						if !sequence {
+							nn.Layers = append(nn.Layers, Layer{Neurons: noutputs, FunctionID: FunctionIdentity, Initializer: XavierUniform})
+						}
+						nn.Init(4*2, rng)
+						for l := 0; l < len(nn.Layers); l++ {
+							for n := 0; n < len(nn.Layers[l].Biases); n++ {
+								nn.Layers[l].Biases[n] = 2*rng.Float32() - 1
+							}
+						}
+
+						/* NOTE(anton2920): truncation makes gradients approximate, so only full BPTT is compared with finite differences. */
+						inputs, outputs := testSequences(rng, 3, 4, 2, noutputs)
+						if truncate == 0 {
+							if err := nn.CheckGradients(inputs, outputs, 1e-2); err != nil {
+								t.Error(err)
+							}
+							return
+						}
+
+						var batch Matrix
+						batch.SetRows(inputs)
+						nn.forward(&nn.ws, &batch, nil)
+
+						gradients := make([]*Gradients, 3)
+						for i, truncate := range [...]int{0, 4, truncate} {
+							nn.Layers[1].Truncate = truncate
+							gradients[i] = nn.NewGradients()
+							nn.Backpropagate(&batch, outputs, gradients[i])
+						}
+						if !reflect.DeepEqual(gradients[0], gradients[1]) {
+							t.Errorf("Expected truncation to the number of steps to be the same as full BPTT")
+						}
+						if reflect.DeepEqual(gradients[0], gradients[2]) {
+							t.Errorf("Expected truncation to change gradients")
+						}
+					})
+				}
+			}
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		var states, outputs, deltas, prevDeltas Matrix
+
+		layer := Layer{Kind: LayerLSTM, Neurons: 2, FunctionID: FunctionTh, Steps: 5, Truncate: 2}
+		layer.Weights.Resize(4*2, 3+2)
+		layer.Biases = make([]float32, 4*2)
+		for i := 0; i < len(layer.Weights.Data); i++ {
+			layer.Weights.Data[i] = 2*rng.Float32() - 1
+		}
+
+		inputs, _ := testSequences(rng, 1, 5, 3, 0)
+		var batch Matrix
+		batch.SetRows(inputs)
+		layer.Query(&states, &outputs, &batch)
+		deltas.SetRows([][]float32{{1, -1}})
+
+		var ws workspace
+		var weights Matrix
+		weights.Resize(layer.Weights.Rows, layer.Weights.Cols)
+		layer.backwardRecurrent(&ws, &states, &deltas, &batch, &prevDeltas, &weights, make([]float32, len(layer.Biases)))
+		for i, delta := range prevDeltas.Row(0) {
+			if step := i / 3; (step < 3) && (delta != 0) {
+				t.Errorf("Expected no derivatives for step %d outside of the last 2, got %g", step, delta)
+			} else if (step >= 3) && (delta == 0) {
+				t.Errorf("Expected derivatives for step %d", step)
+			}
+		}
+	})
+
+	t.Run("train", func(t *testing.T) {
+		const eps = 0.1
+
+		for _, kind := range [...]int{LayerRNN, LayerLSTM, LayerGRU} {
+			nn := NN{
+				Layers: []Layer{
+					{Kind: kind, Neurons: 4, FunctionID: FunctionTh, Steps: 2},
+					{Neurons: 2, FunctionID: FunctionSigmoid},
+				},
+			}
+			if _, err := nn.Train(testInputs, testOutputs, 0, 1, NewAdam(0.05), nil, eps, 100000); err != nil {
+				t.Fatalf("Failed to train NN with %s layer: %s", LayerNames[kind], err.Error())
+			}
+			for i := 0; i < len(testInputs); i++ {
+				outputs := nn.Query(testInputs[i])
+				for j, output := range outputs {
+					if math.Abs(float64(output-testOutputs[i][j])) > eps {
+						t.Errorf("NN with %s layer failed to compute output #%d of %v: expected %.2f, got %.2f", LayerNames[kind], j, testInputs[i], testOutputs[i][j], output)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		nn := NN{
+			Layers: []Layer{
+				{Kind: LayerLSTM, Neurons: 3, FunctionID: FunctionTh, Steps: 3, Sequence: true},
+				{Kind: LayerGRU, Neurons: 2, FunctionID: FunctionTh, Steps: 3, Truncate: 2},
+				{Neurons: 1, FunctionID: FunctionIdentity},
+			},
+		}
+		nn.Init(3*2, rng)
+		inputs, _ := testSequences(rng, 1, 3, 2, 0)
+
+		dir := t.TempDir()
+		for _, format := range [...]string{"bin", "json"} {
+			var loaded NN
+
+			filename := filepath.Join(dir, "nn."+format)
+			store, load := nn.Store, loaded.Load
+			if format == "json" {
+				store, load = nn.StoreJSON, loaded.LoadJSON
+			}
+			if err := store(filename); err != nil {
+				t.Fatalf("Failed to store NN as %s: %s", format, err.Error())
+			}
+			if err := load(filename); err != nil {
+				t.Fatalf("Failed to load NN from %s: %s", format, err.Error())
+			}
+
+			if (loaded.Layers[0].Kind != LayerLSTM) || (!loaded.Layers[0].Sequence) || (loaded.Layers[1].Kind != LayerGRU) || (loaded.Layers[1].Steps != 3) || (loaded.Layers[1].Truncate != 2) {
+				t.Errorf("Loaded layers from %s differ: %+v", format, loaded.Layers[:2])
+			}
+			expected := nn.Query(inputs[0])[0]
+			if actual := loaded.Query(inputs[0])[0]; actual != expected {
+				t.Errorf("Loaded NN from %s differs: expected %f, got %f", format, expected, actual)
+			}
+		}
+
+		if err := nn.ExportONNX(io.Discard); err == nil {
+			t.Errorf("Expected error exporting recurrent layers to ONNX")
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		for _, test := range [...]struct {
+			Layer   Layer
+			NInputs int
+		}{
+			{Layer{Kind: LayerRNN, Neurons: 2, FunctionID: FunctionTh}, 4},
+			{Layer{Kind: LayerRNN, Neurons: 2, FunctionID: FunctionTh, Steps: 3}, 4},
+			{Layer{Kind: LayerLSTM, Neurons: 2, FunctionID: FunctionSoftmax, Steps: 2}, 4},
+			{Layer{Kind: LayerGRU, Neurons: 2, FunctionID: FunctionTh, Steps: 2, Truncate: -1}, 4},
+			{Layer{Kind: len(LayerNames), Neurons: 2}, 4},
+		} {
+			nn := NN{Layers: []Layer{test.Layer}}
+			if err := nn.Validate(test.NInputs); err == nil {
+				t.Errorf("Expected error for layer %+v with %d inputs", test.Layer, test.NInputs)
+			}
+		}
+	})
+}
+
+func TestConvolution(t *testing.T) {
+	rng := rand.New(rand.NewSource(Seed))
+
+	t.Run("query", func(t *testing.T) {
+		for _, test := range [...]struct {
+			Layer    Layer
+			Weights  []float32
+			Inputs   []float32
+			Expected []float32
+		}{
+			{Layer{Kind: LayerConv1D, Neurons: 1, FunctionID: FunctionIdentity, Width: 4, Kernel: 2}, []float32{1, -1}, []float32{1, 3, 2, 5}, []float32{-1.5, 1.5, -2.5}},
+			{Layer{Kind: LayerConv1D, Neurons: 2, FunctionID: FunctionIdentity, Width: 3, Kernel: 1, Stride: 2}, []float32{1, 0, 0, 1}, []float32{1, 2, 3, 4, 5, 6}, []float32{1.5, 2.5, 5.5, 6.5}},
+			{Layer{Kind: LayerConv2D, Neurons: 1, FunctionID: FunctionIdentity, Height: 3, Width: 3, Kernel: 2}, []float32{1, 0, 1, 0, 1, 0, 1, 0}, []float32{1, 0, 2, 0, 3, 0, 4, 0, 5, 0, 6, 0, 7, 0, 8, 0, 9, 0}, []float32{12.5, 16.5, 24.5, 28.5}},
+			{Layer{Kind: LayerMaxPool1D, Width: 4, Kernel: 2}, nil, []float32{1, -1, 3, 2, -5, 0, 4, 7}, []float32{3, 2, 4, 7}},
+			{Layer{Kind: LayerMaxPool2D, Height: 2, Width: 3, Kernel: 2}, nil, []float32{1, 5, 2, 3, 4, 6}, []float32{5}},
+			{Layer{Kind: LayerAvgPool2D, Height: 2, Width: 4, Kernel: 2}, nil, []float32{1, 2, 3, 4, 5, 6, 7, 8}, []float32{3.5, 5.5}},
+			{Layer{Kind: LayerFlatten}, nil, []float32{1, 2, 3}, []float32{1, 2, 3}},
+		} {
+			var sums, outputs, batch Matrix
+
+			layer := test.Layer
+			rows, cols := layer.shape(len(test.Inputs))
+			layer.Weights = Matrix{Rows: rows, Cols: cols, Data: test.Weights}
+			layer.Biases = make([]float32, rows)
+			for n := 0; n < rows; n++ {
+				layer.Biases[n] = 0.5
+			}
+			if err := validateLayers([]Layer{layer}, len(test.Inputs), true); err != nil {
+				t.Fatalf("Failed to validate %s layer: %s", LayerNames[layer.Kind], err.Error())
+			}
+
+			batch.SetRows([][]float32{test.Inputs})
+			layer.Query(&sums, &outputs, &batch)
+			if !reflect.DeepEqual(outputs.Row(0), test.Expected) {
+				t.Errorf("Expected outputs of %s layer %v, got %v", LayerNames[layer.Kind], test.Expected, outputs.Row(0))
+			}
+			if layer.NumOutputs() != len(test.Expected) {
+				t.Errorf("Expected %s layer to have %d outputs, got %d", LayerNames[layer.Kind], len(test.Expected), layer.NumOutputs())
+			}
+		}
+	})
+
+	t.Run("gradients", func(t *testing.T) {
+		for _, test := range [...]struct {
+			Name    string
+			Layers  []Layer
+			NInputs int
+		}{
+			{"conv1d-maxpool1d", []Layer{
+				{Kind: LayerConv1D, Neurons: 2, FunctionID: FunctionTh, Width: 6, Kernel: 3},
+				{Kind: LayerMaxPool1D, Width: 4, Kernel: 2},
+				{Kind: LayerFlatten},
+			}, 6 * 2},
+			{"conv2d-stride-avgpool2d", []Layer{
+				{Kind: LayerConv2D, Neurons: 2, FunctionID: FunctionTh, Height: 5, Width: 5, Kernel: 3, Stride: 2},
+				{Kind: LayerAvgPool2D, Height: 2, Width: 2, Kernel: 2},
+			}, 5 * 5},
+			{"conv2d-conv2d-maxpool2d", []Layer{
+				{Kind: LayerConv2D, Neurons: 2, FunctionID: FunctionTh, Height: 4, Width: 4, Kernel: 2},
+				{Kind: LayerConv2D, Neurons: 2, FunctionID: FunctionSigmoid, Height: 3, Width: 3, Kernel: 2},
+				{Kind: LayerMaxPool2D, Height: 2, Width: 2, Kernel: 2, Stride: 1},
+			}, 4 * 4 * 2},
+			{"conv1d-avgpool1d-gru", []Layer{
+				{Kind: LayerConv1D, Neurons: 3, FunctionID: FunctionTh, Width: 6, Kernel: 2},
+				{Kind: LayerAvgPool1D, Width: 5, Kernel: 2, Stride: 1},
+				{Kind: LayerGRU, Neurons: 2, FunctionID: FunctionTh, Steps: 4},
+			}, 6 * 2},
+		} {
+			t.Run(test.Name, func(t *testing.T) {
+				nn := NN{Layers: append(test.Layers, Layer{Neurons: 2, FunctionID: FunctionIdentity}), LossID: LossMSE}
+				for l := 0; l < len(nn.Layers); l++ {
+					nn.Layers[l].Initializer = XavierUniform
+				}
+				if err := nn.Validate(test.NInputs); err != nil {
+					t.Fatalf("Failed to validate NN: %s", err.Error())
+				}
+				nn.Init(test.NInputs, rng)
+				for l := 0; l < len(nn.Layers); l++ {
+					for n := 0; n < len(nn.Layers[l].Biases); n++ {
+						nn.Layers[l].Biases[n] = 2*rng.Float32() - 1
+					}
+				}
+
+				inputs, outputs := testSequences(rng, 3, 1, test.NInputs, 2)
+				if err := nn.CheckGradients(inputs, outputs, 1e-2); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	})
+
+	t.Run("train", func(t *testing.T) {
+		const eps = 0.1
+
+		/* NOTE(anton2920): 4x4 images with a single horizontal or vertical line, which are told apart by 2x2 filters wherever the line is. */
+		var inputs, outputs [][]float32
+		for i := 0; i < 4; i++ {
+			for vertical := 0; vertical < 2; vertical++ {
+				image := make([]float32, 4*4)
+				for j := 0; j < 4; j++ {
+					if vertical == 1 {
+						image[j*4+i] = 1
+					} else {
+						image[i*4+j] = 1
+					}
+				}
+				inputs = append(inputs, image)
+				outputs = append(outputs, []float32{float32(1 - vertical), float32(vertical)})
+			}
+		}
+
+		nn := NN{
+			Layers: []Layer{
+				{Kind: LayerConv2D, Neurons: 4, FunctionID: FunctionReLU, Height: 4, Width: 4, Kernel: 2, Initializer: HeUniform},
+				{Kind: LayerMaxPool2D, Height: 3, Width: 3, Kernel: 3},
+				{Kind: LayerFlatten},
+				{Neurons: 2, FunctionID: FunctionSigmoid},
+			},
+		}
+		if _, err := nn.Train(inputs, outputs, 0, 1, NewAdam(0.05), nil, eps, 100000); err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+		for i := 0; i < len(inputs); i++ {
+			for j, output := range nn.Query(inputs[i]) {
+				if math.Abs(float64(output-outputs[i][j])) > eps {
+					t.Errorf("NN failed to compute output #%d of image #%d: expected %.2f, got %.2f", j, i, outputs[i][j], output)
+				}
+			}
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		nn := NN{
+			Layers: []Layer{
+				{Kind: LayerConv2D, Neurons: 3, FunctionID: FunctionReLU, Height: 4, Width: 4, Kernel: 2, Stride: 2},
+				{Kind: LayerMaxPool2D, Height: 2, Width: 2, Kernel: 2},
+				{Kind: LayerFlatten},
+				{Neurons: 1, FunctionID: FunctionIdentity},
+			},
+		}
+		nn.Init(4*4*2, rng)
+		inputs, _ := testSequences(rng, 1, 1, 4*4*2, 0)
+
+		dir := t.TempDir()
+		for _, format := range [...]string{"bin", "json"} {
+			var loaded NN
+
+			filename := filepath.Join(dir, "nn."+format)
+			store, load := nn.Store, loaded.Load
+			if format == "json" {
+				store, load = nn.StoreJSON, loaded.LoadJSON
+			}
+			if err := store(filename); err != nil {
+				t.Fatalf("Failed to store NN as %s: %s", format, err.Error())
+			}
+			if err := load(filename); err != nil {
+				t.Fatalf("Failed to load NN from %s: %s", format, err.Error())
+			}
+
+			if (loaded.Layers[0].Height != 4) || (loaded.Layers[0].Width != 4) || (loaded.Layers[0].Kernel != 2) || (loaded.Layers[0].Stride != 2) || (loaded.Layers[1].Kind != LayerMaxPool2D) || (loaded.Layers[2].Kind != LayerFlatten) {
+				t.Errorf("Loaded layers from %s differ: %+v", format, loaded.Layers[:3])
+			}
+			if loaded.NumInputs() != 4*4*2 {
+				t.Errorf("Expected loaded NN from %s to have %d inputs, got %d", format, 4*4*2, loaded.NumInputs())
+			}
+			expected := nn.Query(inputs[0])[0]
+			if actual := loaded.Query(inputs[0])[0]; actual != expected {
+				t.Errorf("Loaded NN from %s differs: expected %f, got %f", format, expected, actual)
+			}
+		}
+
+		if err := nn.ExportONNX(io.Discard); err == nil {
+			t.Errorf("Expected error exporting convolution layers to ONNX")
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		for _, test := range [...]struct {
+			Layer   Layer
+			NInputs int
+		}{
+			{Layer{Kind: LayerConv1D, Neurons: 2, FunctionID: FunctionTh, Kernel: 2}, 4},
+			{Layer{Kind: LayerConv1D, Neurons: 2, FunctionID: FunctionTh, Width: 3, Kernel: 2}, 4},
+			{Layer{Kind: LayerConv1D, Neurons: 2, FunctionID: FunctionTh, Width: 4, Kernel: 5}, 4},
+			{Layer{Kind: LayerConv1D, FunctionID: FunctionTh, Width: 4, Kernel: 2}, 4},
+			{Layer{Kind: LayerConv2D, Neurons: 2, FunctionID: FunctionSoftmax, Height: 2, Width: 2, Kernel: 2}, 4},
+			{Layer{Kind: LayerConv2D, Neurons: 2, FunctionID: FunctionTh, Width: 4, Kernel: 1}, 4},
+			{Layer{Kind: LayerMaxPool2D, Height: 2, Width: 2, Kernel: 0}, 4},
+			{Layer{Kind: LayerAvgPool1D, Width: 4, Kernel: 2, Stride: -1}, 4},
+		} {
+			nn := NN{Layers: []Layer{test.Layer}}
+			if err := nn.Validate(test.NInputs); err == nil {
+				t.Errorf("Expected error for layer %+v with %d inputs", test.Layer, test.NInputs)
+			}
+		}
+	})
+}
+
+func TestNormalization(t *testing.T) {
+	rng := rand.New(rand.NewSource(Seed))
+
+	t.Run("query", func(t *testing.T) {
+		var sums, stats, outputs, batch Matrix
+
+		layer := Layer{Kind: LayerLayerNorm, Neurons: 4, FunctionID: FunctionIdentity, Weights: Matrix{Rows: 4, Cols: 1, Data: []float32{1, 1, 2, 2}}, Biases: []float32{0, 0, 0, 1}}
+		batch.SetRows([][]float32{{1, 2, 3, 4}, {5, 5, 5, 5}})
+		layer.Query(&sums, &outputs, &batch)
+		for j, expected := range [...]float32{-1.3416, -0.4472, 0.8944, 3.6833, 0, 0, 0, 1} {
+			testNear(t, fmt.Sprintf("layernorm output #%d", j), expected, outputs.Data[j])
+		}
+
+		layer = Layer{Kind: LayerBatchNorm, Neurons: 2, FunctionID: FunctionIdentity, Weights: Matrix{Rows: 2, Cols: 1, Data: []float32{1, 2}}, Biases: []float32{0, 1}, Mean: []float32{1, -1}, Variance: []float32{4, 1}}
+		if err := validateLayers([]Layer{layer}, 2, true); err != nil {
+			t.Fatalf("Failed to validate batchnorm layer: %s", err.Error())
+		}
+		batch.SetRows([][]float32{{3, -1}, {-1, 1}})
+		layer.Query(&sums, &outputs, &batch)
+		for j, expected := range [...]float32{1, 1, -1, 5} {
+			testNear(t, fmt.Sprintf("batchnorm output #%d", j), expected, outputs.Data[j])
+		}
+
+		/* NOTE(anton2920): in training mode batch is normalized with its own statistics, ignoring running ones. */
+		layer.query(&sums, &stats, &outputs, &batch, true)
+		for j, expected := range [...]float32{1, -1, -1, 3} {
+			testNear(t, fmt.Sprintf("batchnorm training output #%d", j), expected, outputs.Data[j])
+		}
+	})
+
+	t.Run("gradients", func(t *testing.T) {
+		for _, test := range [...]struct {
+			Name   string
+			Layers []Layer
+		}{
+			{"dense-batchnorm", []Layer{
+				{Neurons: 4, FunctionID: FunctionIdentity},
+				{Kind: LayerBatchNorm, Neurons: 4, FunctionID: FunctionTh},
+			}},
+			{"dense-layernorm", []Layer{
+				{Neurons: 4, FunctionID: FunctionIdentity},
+				{Kind: LayerLayerNorm, Neurons: 4, FunctionID: FunctionTh},
+			}},
+			{"batchnorm-dense-layernorm", []Layer{
+				{Kind: LayerBatchNorm, Neurons: 6, FunctionID: FunctionIdentity},
+				{Neurons: 4, FunctionID: FunctionSigmoid, Dropout: 0.25},
+				{Kind: LayerLayerNorm, Neurons: 4, FunctionID: FunctionReLU},
+			}},
+			{"gru-batchnorm", []Layer{
+				{Kind: LayerGRU, Neurons: 3, FunctionID: FunctionTh, Steps: 2},
+				{Kind: LayerBatchNorm, Neurons: 3, FunctionID: FunctionSigmoid},
+			}},
+		} {
+			t.Run(test.Name, func(t *testing.T) {
+				nn := NN{Layers: append(test.Layers, Layer{Neurons: 2, FunctionID: FunctionIdentity}), LossID: LossMSE}
+				for l := 0; l < len(nn.Layers); l++ {
+					nn.Layers[l].Initializer = XavierUniform
+				}
+				if err := nn.Validate(3 * 2); err != nil {
+					t.Fatalf("Failed to validate NN: %s", err.Error())
+				}
+				nn.Init(3*2, rng)
+				for l := 0; l < len(nn.Layers); l++ {
+					layer := &nn.Layers[l]
+					for n := 0; n < len(layer.Biases); n++ {
+						layer.Biases[n] = 2*rng.Float32() - 1
+						if layer.Normalization() {
+							layer.Weights.Data[n] = 0.5 + rng.Float32()
+						}
+					}
+				}
+
+				inputs, outputs := testSequences(rng, 4, 2, 3, 2)
+				if err := nn.CheckGradients(inputs, outputs, 1e-2); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	})
+
+	t.Run("statistics", func(t *testing.T) {
+		/* NOTE(anton2920): running statistics of the first layer must approach means and variances of inputs. */
+		var means, variances [2]float32
+		inputs := make([][]float32, 256)
+		outputs := make([][]float32, len(inputs))
+		for i := 0; i < len(inputs); i++ {
+			inputs[i] = []float32{5 + 2*float32(rng.NormFloat64()), -3 + 0.5*float32(rng.NormFloat64())}
+			outputs[i] = []float32{float32(rng.Intn(2))}
+			for j := 0; j < len(means); j++ {
+				means[j] += inputs[i][j] / float32(len(inputs))
+			}
+		}
+		for i := 0; i < len(inputs); i++ {
+			for j := 0; j < len(variances); j++ {
+				variances[j] += (inputs[i][j] - means[j]) * (inputs[i][j] - means[j]) / float32(len(inputs))
+			}
+		}
+
+		for _, workers := range [...]int{1, 2} {
+			nn := NN{
+				Layers: []Layer{
+					{Kind: LayerBatchNorm, Neurons: 2, FunctionID: FunctionIdentity},
+					{Neurons: 1, FunctionID: FunctionSigmoid},
+				},
+			}
+			if _, err := nn.TrainValidate(inputs, outputs, &TrainOptions{BatchSize: 32, Workers: workers, Optimizer: NewSGD(0.01), MaxEpochs: 20}); err != nil {
+				t.Fatalf("Failed to train NN: %s", err.Error())
+			}
+
+			layer := &nn.Layers[0]
+			for j := 0; j < len(means); j++ {
+				if math.Abs(float64(layer.Mean[j]-means[j])) > 0.2 {
+					t.Errorf("Expected running mean #%d near %.2f with %d workers, got %f", j, means[j], workers, layer.Mean[j])
+				}
+				if math.Abs(float64(layer.Variance[j]/variances[j]-1)) > 0.2 {
+					t.Errorf("Expected running variance #%d near %.2f with %d workers, got %f", j, variances[j], workers, layer.Variance[j])
+				}
+			}
+		}
+	})
+
+	t.Run("train", func(t *testing.T) {
+		const eps = 0.1
+
+		/* NOTE(anton2920): with momentum of 1 running statistics are those of the last epoch over whole dataset, so Query matches training outputs exactly. */
+		for _, kind := range [...]int{LayerBatchNorm, LayerLayerNorm} {
+			nn := NN{
+				Layers: []Layer{
+					{Neurons: 4, FunctionID: FunctionIdentity},
+					{Kind: kind, Neurons: 4, FunctionID: FunctionTh, Momentum: 1},
+					{Neurons: 2, FunctionID: FunctionSigmoid},
+				},
+			}
+			if _, err := nn.Train(testInputs, testOutputs, 0, 1, NewAdam(0.05), nil, eps, 100000); err != nil {
+				t.Fatalf("Failed to train NN with %s layer: %s", LayerNames[kind], err.Error())
+			}
+			for i := 0; i < len(testInputs); i++ {
+				for j, output := range nn.Query(testInputs[i]) {
+					if math.Abs(float64(output-testOutputs[i][j])) > eps {
+						t.Errorf("NN with %s layer failed to compute output #%d of %v: expected %.2f, got %.2f", LayerNames[kind], j, testInputs[i], testOutputs[i][j], output)
+					}
+				}
+			}
+		}
+	})
+
+	t.Run("store", func(t *testing.T) {
+		nn := NN{
+			Layers: []Layer{
+				{Neurons: 3, FunctionID: FunctionIdentity},
+				{Kind: LayerBatchNorm, Neurons: 3, FunctionID: FunctionTh, Momentum: 0.25},
+				{Kind: LayerLayerNorm, Neurons: 3, FunctionID: FunctionIdentity},
+				{Neurons: 1, FunctionID: FunctionIdentity},
+			},
+		}
+		nn.Init(2, rng)
+		for j := 0; j < 3; j++ {
+			nn.Layers[1].Mean[j] = 2*rng.Float32() - 1
+			nn.Layers[1].Variance[j] = rng.Float32()
+			nn.Layers[2].Weights.Data[j] = 0.5 + rng.Float32()
+		}
+		inputs, _ := testSequences(rng, 1, 1, 2, 0)
+
+		dir := t.TempDir()
+		for _, format := range [...]string{"bin", "json"} {
+			var loaded NN
+
+			filename := filepath.Join(dir, "nn."+format)
+			store, load := nn.Store, loaded.Load
+			if format == "json" {
+				store, load = nn.StoreJSON, loaded.LoadJSON
+			}
+			if err := store(filename); err != nil {
+				t.Fatalf("Failed to store NN as %s: %s", format, err.Error())
+			}
+			if err := load(filename); err != nil {
+				t.Fatalf("Failed to load NN from %s: %s", format, err.Error())
+			}
+
+			if (loaded.Layers[1].Kind != LayerBatchNorm) || (loaded.Layers[1].Momentum != 0.25) || (!reflect.DeepEqual(loaded.Layers[1].Mean, nn.Layers[1].Mean)) || (!reflect.DeepEqual(loaded.Layers[1].Variance, nn.Layers[1].Variance)) || (loaded.Layers[2].Kind != LayerLayerNorm) {
+				t.Errorf("Loaded layers from %s differ: %+v", format, loaded.Layers[1:3])
+			}
+			expected := nn.Query(inputs[0])[0]
+			if actual := loaded.Query(inputs[0])[0]; actual != expected {
+				t.Errorf("Loaded NN from %s differs: expected %f, got %f", format, expected, actual)
+			}
+		}
+
+		var buffer bytes.Buffer
+		if err := nn.ExportONNX(&buffer); err != nil {
+			t.Fatalf("Failed to export NN to ONNX: %s", err.Error())
+		}
+		graph := testProtoFields(t, testProtoFields(t, buffer.Bytes())[7][0])
+		expectedOps := []string{"Gemm", "Identity", "BatchNormalization", "Tanh", "ReduceMean", "Sub", "Mul", "ReduceMean", "Add", "Sqrt", "Div", "Mul", "Add", "Identity", "Gemm", "Identity"}
+		if len(graph[1]) != len(expectedOps) {
+			t.Fatalf("Expected %d nodes, got %d", len(expectedOps), len(graph[1]))
+		}
+		for i, node := range graph[1] {
+			if op := string(testProtoFields(t, node)[4][0]); op != expectedOps[i] {
+				t.Errorf("Expected node #%d to be %s, got %s", i, expectedOps[i], op)
+			}
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		for _, layer := range [...]Layer{
+			{Kind: LayerBatchNorm, Neurons: 3, FunctionID: FunctionTh},
+			{Kind: LayerLayerNorm, Neurons: 0, FunctionID: FunctionTh},
+			{Kind: LayerBatchNorm, Neurons: 4, FunctionID: FunctionTh, Momentum: 1.5},
+			{Kind: LayerBatchNorm, Neurons: 4, FunctionID: FunctionTh, Momentum: -0.1},
+		} {
+			nn := NN{Layers: []Layer{layer}}
+			if err := nn.Validate(4); err == nil {
+				t.Errorf("Expected error for layer %+v with %d inputs", layer, 4)
+			}
+		}
+
+		batchNorm := NN{Layers: []Layer{{Kind: LayerBatchNorm, Neurons: 2, FunctionID: FunctionTh}}}
+		if _, err := batchNorm.Train(testInputs, testOutputs, 1, 1, NewSGD(0.1), nil, 0.1, 10); (err == nil) || (!strings.Contains(err.Error(), "at least 2 samples")) {
+			t.Errorf("Expected error for BatchNorm layer with batches of one sample in NN.Train, got %v", err)
+		}
+		if _, err := batchNorm.Train(testInputs[:1], testOutputs[:1], 0, 1, NewSGD(0.1), nil, 0.1, 10); (err == nil) || (!strings.Contains(err.Error(), "at least 2 samples")) {
+			t.Errorf("Expected error for BatchNorm layer with one sample in NN.Train, got %v", err)
+		}
+		if _, err := batchNorm.TrainValidate(testInputs, testOutputs, &TrainOptions{BatchSize: 1, Optimizer: NewSGD(0.1), MaxEpochs: 10}); (err == nil) || (!strings.Contains(err.Error(), "at least 2 samples")) {
+			t.Errorf("Expected error for BatchNorm layer with batches of one sample in NN.TrainValidate, got %v", err)
+		}
+		rows := make([][]float32, len(testInputs))
+		for i := 0; i < len(rows); i++ {
+			rows[i] = append(append([]float32(nil), testInputs[i]...), testOutputs[i]...)
+		}
+		if _, err := batchNorm.TrainSource(NewSliceSource(rows), 2, 1, 1, NewSGD(0.1), nil, 0.1, 10); (err == nil) || (!strings.Contains(err.Error(), "at least 2 samples")) {
+			t.Errorf("Expected error for BatchNorm layer with batches of one sample in NN.TrainSource, got %v", err)
+		}
+
+		nn := NN{Layers: []Layer{{Kind: LayerBatchNorm, Neurons: 2, FunctionID: FunctionTh}}}
+		nn.Init(2, rng)
+		nn.Layers[0].Variance[1] = -1
+		if err := nn.validateInitialized(); err == nil {
+			t.Errorf("Expected error for negative running variance")
+		}
+		nn.Layers[0].Variance = nn.Layers[0].Variance[:1]
+		if err := nn.validateInitialized(); err == nil {
+			t.Errorf("Expected error for missing running variance")
+		}
+	})
+}
+
+func TestInitializers(t *testing.T) {
+	const fanIn, fanOut = 400, 100
+
+	initializers := [...]struct {
+		Name        string
+		Initializer Initializer
+		Limit       float64
+		Stddev      float64
+	}{
+		{"xavier-uniform", XavierUniform, math.Sqrt(6.0 / (fanIn + fanOut)), math.Sqrt(2.0 / (fanIn + fanOut))},
+		{"xavier-normal", XavierNormal, math.Inf(1), math.Sqrt(2.0 / (fanIn + fanOut))},
+		{"he-uniform", HeUniform, math.Sqrt(6.0 / fanIn), math.Sqrt(2.0 / fanIn)},
+		{"he-normal", HeNormal, math.Inf(1), math.Sqrt(2.0 / fanIn)},
+		{"constant", Constant(0.25), 0.25, 0},
+	}
+
+	for _, i := range initializers {
+		initializer := i
+		t.Run(i.Name, func(t *testing.T) {
+			nn := NN{Layers: []Layer{{Neurons: fanOut, Initializer: initializer.Initializer}}}
+			nn.Init(fanIn, rand.New(rand.NewSource(Seed)))
+
+			var sum, sumSquares float64
+			layer := &nn.Layers[0]
+			for _, bias := range layer.Biases {
+				if bias != 0 {
+					t.Errorf("Expected zero bias, got %f", bias)
+				}
+			}
+			for _, weight := range layer.Weights.Data {
+				if math.Abs(float64(weight)) > initializer.Limit {
+					t.Errorf("Weight %f is outside of [-%f; %f]", weight, initializer.Limit, initializer.Limit)
+				}
+				sum += float64(weight)
+				sumSquares += float64(weight) * float64(weight)
+			}
+
+			mean := sum / (fanIn * fanOut)
+			stddev := math.Sqrt(sumSquares/(fanIn*fanOut) - mean*mean)
+			if math.Abs(stddev-initializer.Stddev) > 0.05*initializer.Stddev+1e-6 {
+				t.Errorf("Expected standard deviation %f, got %f", initializer.Stddev, stddev)
+			}
+		})
+	}
+
+	t.Run("xor", func(t *testing.T) {
+		const eps = 0.1
+
+		inputs := testInputs
+		outputs := [][]float32{{0}, {1}, {1}, {0}}
+
+		nn := NN{
+			Layers: []Layer{
+				{Neurons: 4, FunctionID: FunctionTh, Initializer: XavierUniform},
+				{Neurons: 1, FunctionID: FunctionSigmoid, Initializer: XavierUniform},
+			},
+		}
+		if _, err := nn.Train(inputs, outputs, 1, 1, NewSGD(0.5), nil, eps, 100000); err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+		for i := 0; i < len(inputs); i++ {
+			if output := nn.Query(inputs[i])[0]; math.Abs(float64(output-outputs[i][0])) > eps {
+				t.Errorf("NN failed to compute XOR of %v: expected %.2f, got %.2f", inputs[i], outputs[i][0], output)
+			}
+		}
+	})
+}
+
+func TestNNTrainValidate(t *testing.T) {
+	inputs := append(testInputs, testInputs...)
+	outputs := append(testOutputs, testOutputs...)
+
+	t.Run("patience", func(t *testing.T) {
+		var lastImproved, last int
+
+		nn := testNN()
+		epochs, err := nn.TrainValidate(inputs, outputs, &TrainOptions{
+			Optimizer:       NewSGD(0.5),
+			MaxEpochs:       100000,
+			ValidationSplit: 0.5,
+			Patience:        5,
+			MinDelta:        1e-3,
+			Callback: func(metrics EpochMetrics) error {
+				if metrics.Improved {
+					lastImproved = metrics.Epoch
+				}
+				last = metrics.Epoch
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+		if epochs == 100000 {
+			t.Errorf("Training did not stop early")
+		}
+		if last-lastImproved != 5 {
+			t.Errorf("Expected training to stop 5 epochs after last improvement at %d, stopped at %d", lastImproved, last)
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		nn := testNN()
+		epochs, err := nn.TrainValidate(inputs, outputs, &TrainOptions{
+			Optimizer:       NewSGD(0.5),
+			MaxEpochs:       100,
+			ValidationSplit: 0.5,
+			Callback: func(metrics EpochMetrics) error {
+				if metrics.Epoch == 9 {
+					return StopTraining
+				}
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+		if epochs != 10 {
+			t.Errorf("Expected training to stop after 10 epochs, got %d", epochs)
+		}
+	})
+
+	t.Run("checkpoint", func(t *testing.T) {
+		var loaded NN
+
+		filename := filepath.Join(t.TempDir(), "nn.bin")
+		nn := testNN()
+		if _, err := nn.TrainValidate(inputs, outputs, &TrainOptions{
+			Optimizer:       NewSGD(0.5),
+			MaxEpochs:       100,
+			ValidationSplit: 0.5,
+			CheckpointFile:  filename,
+		}); err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+
+		if err := loaded.Load(filename); err != nil {
+			t.Fatalf("Failed to load checkpoint: %s", err.Error())
+		}
+		for i := 0; i < len(testInputs); i++ {
+			expected := nn.Query(testInputs[i])
+			actual := loaded.Query(testInputs[i])
+			for j := 0; j < len(expected); j++ {
+				if expected[j] != actual[j] {
+					t.Errorf("Checkpoint differs from the best NN for %v: expected %f, got %f", testInputs[i], expected[j], actual[j])
+				}
+			}
+		}
+
+		nn = testNN()
+		if _, err := nn.TrainValidate(inputs, outputs, &TrainOptions{
+			Optimizer:       NewSGD(0.5),
+			MaxEpochs:       100,
+			ValidationSplit: 0.5,
+			CheckpointFile:  filepath.Join(t.TempDir(), "missing", "nn.bin"),
+		}); err == nil {
+			t.Errorf("Expected error for unwritable checkpoint file, got nil")
+		}
+	})
+
+	t.Run("split", func(t *testing.T) {
+		for _, split := range [...]float32{-0.5, 1, 0.95} {
+			nn := testNN()
+			if _, err := nn.TrainValidate(inputs, outputs, &TrainOptions{Optimizer: NewSGD(0.5), MaxEpochs: 10, ValidationSplit: split}); err == nil {
+				t.Errorf("Expected error for validation split %g of %d samples, got nil", split, len(inputs))
+			}
+		}
+		/* NOTE(anton2920): validation part is rounded up, not training one. */
+		if ntraining := TrainingSamples(10, 0.25); ntraining != 7 {
+			t.Errorf("Expected 7 training samples out of 10 with validation split 0.25, got %d", ntraining)
+		}
+
+		nn := testNN()
+		nn.Init(len(inputs[0]), rand.New(rand.NewSource(Seed)))
+		if g := nn.newPool(2, 1).run(nil, func(*worker, []int) { t.Errorf("Expected no shards for empty indices") }); g.Count != 0 {
+			t.Errorf("Expected no gradients for empty indices, got %d", g.Count)
+		}
+	})
+}
+
+func testWeightsNorm(nn *NN) float64 {
+	var sum float64
+
+	for l := 0; l < len(nn.Layers); l++ {
+		for _, weight := range nn.Layers[l].Weights.Data {
+			sum += float64(weight) * float64(weight)
+		}
+	}
+
+	return math.Sqrt(sum)
+}
+
+func TestRegularization(t *testing.T) {
+	const eps = 0.1
+
+	train := func(t *testing.T, nn *NN) {
+		t.Helper()
+
+		if _, err := nn.TrainValidate(testInputs, testOutputs, &TrainOptions{Optimizer: NewSGD(0.5), MaxEpochs: 2000}); err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+	}
+
+	plain := testNN()
+	train(t, &plain)
+
+	for _, r := range [...]struct {
+		Name           string
+		Regularization Regularization
+	}{
+		{"l1", Regularization{L1: 1e-2}},
+		{"l2", Regularization{L2: 1e-2}},
+	} {
+		regularization := r.Regularization
+		t.Run(r.Name, func(t *testing.T) {
+			nn := testNN()
+			nn.Regularization = regularization
+			train(t, &nn)
+
+			if norm, plainNorm := testWeightsNorm(&nn), testWeightsNorm(&plain); norm >= plainNorm {
+				t.Errorf("Expected weights norm to be less than %f, got %f", plainNorm, norm)
+			}
+		})
+	}
+
+	t.Run("clipping", func(t *testing.T) {
+		const maxNorm = 0.01
+
+		nn := testNN()
+		nn.Init(len(testInputs[0]), rand.New(rand.NewSource(Seed)))
+		nn.Regularization.MaxGradNorm = maxNorm
+
+		var inputs Matrix
+		inputs.SetRows(testInputs)
+		g := nn.NewGradients()
+		nn.QueryBatch(&inputs)
+		nn.Backpropagate(&inputs, testOutputs, g)
+		nn.regularize(g)
+
+		var sum float64
+		for l := 0; l < len(g.Weights); l++ {
+			for _, grad := range append(g.Weights[l].Data, g.Biases[l]...) {
+				sum += float64(grad/float32(g.Count)) * float64(grad/float32(g.Count))
+			}
+		}
+		if norm := math.Sqrt(sum); norm > maxNorm*(1+1e-5) {
+			t.Errorf("Expected gradients norm to be at most %f, got %f", maxNorm, norm)
+		}
+	})
+
+	t.Run("dropout", func(t *testing.T) {
+		nn := testNN()
+		nn.Layers[0].Neurons = 16
+		nn.Layers[0].Dropout = 0.25
+		if _, err := nn.Train(testInputs, testOutputs, 0, 1, NewAdam(0.05), nil, eps, 100000); err != nil {
+			t.Fatalf("Failed to train NN: %s", err.Error())
+		}
+
+		for i := 0; i < len(testInputs); i++ {
+			expected := append([]float32(nil), nn.Query(testInputs[i])...)
+			actual := nn.Query(testInputs[i])
+			for j := 0; j < len(expected); j++ {
+				if expected[j] != actual[j] {
+					t.Errorf("NN.Query is not deterministic for %v: %f and %f", testInputs[i], expected[j], actual[j])
+				}
+				if math.Abs(float64(actual[j]-testOutputs[i][j])) > 2*eps {
+					t.Errorf("NN failed to compute output #%d of %v: expected %.2f, got %.2f", j, testInputs[i], testOutputs[i][j], actual[j])
+				}
+			}
+		}
+	})
+
+	t.Run("dropout-range", func(t *testing.T) {
+		for _, c := range [...]struct {
+			Name    string
+			Layer   int
+			Kind    int
+			Dropout float32
+		}{
+			{"negative", 0, LayerDense, -0.1},
+			{"one", 0, LayerDense, 1},
+			{"above-one", 0, LayerDense, 1.5},
+			{"nan", 0, LayerDense, float32(math.NaN())},
+			{"layernorm", 1, LayerLayerNorm, 0.25},
+			{"output", 2, LayerDense, 0.25},
+		} {
+			nn := NN{
+				Layers: []Layer{
+					{Neurons: 4, FunctionID: FunctionTh},
+					{Neurons: 4, FunctionID: FunctionTh},
+					{Neurons: 2, FunctionID: FunctionSigmoid},
+				},
+			}
+			nn.Layers[c.Layer].Kind = c.Kind
+			nn.Layers[c.Layer].Dropout = c.Dropout
+			if err := nn.Validate(len(testInputs[0])); err == nil {
+				t.Errorf("Expected error for %s dropout, got nil", c.Name)
+			}
+		}
+	})
+}
+
+func testDataset(n, nclasses int) Dataset {
+	var rows [][]float32
+
+	for i := 0; i < n; i++ {
+		row := make([]float32, 1+nclasses)
+		row[0] = float32(i)
+		row[1+i%nclasses] = 1
+		rows = append(rows, row)
+	}
+
+	return NewDataset(rows, 1)
+}
+
+/* testSamples returns sorted IDs of samples in Dataset, checking that inputs and outputs still match. */
+func testSamples(t *testing.T, d Dataset) []int {
+	ids := make([]int, d.Len())
+	for i := 0; i < d.Len(); i++ {
+		ids[i] = int(d.Inputs[i][0])
+		if d.Outputs[i][ids[i]%len(d.Outputs[i])] != 1 {
+			t.Fatalf("Sample #%d has outputs %v of another sample", ids[i], d.Outputs[i])
+		}
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func testClasses(d Dataset, nclasses int) []int {
+	counts := make([]int, nclasses)
+	for i := 0; i < d.Len(); i++ {
+		counts[Class(d.Outputs[i])]++
+	}
+	return counts
+}
+
+func TestDataset(t *testing.T) {
+	t.Run("shuffle", func(t *testing.T) {
+		d1, d2 := testDataset(20, 3), testDataset(20, 3)
+		d1.Shuffle(Seed)
+		d2.Shuffle(Seed)
+		for i := 0; i < d1.Len(); i++ {
+			if d1.Inputs[i][0] != d2.Inputs[i][0] {
+				t.Fatalf("Shuffling with the same seed gave different orders")
+			}
+		}
+		if ids := testSamples(t, d1); len(ids) != 20 {
+			t.Errorf("Expected 20 samples, got %d", len(ids))
+		}
+	})
+
+	t.Run("split", func(t *testing.T) {
+		d := testDataset(20, 4)
+
+		training, validation, test, err := d.Split(&SplitOptions{ValidationSplit: 0.2, TestSplit: 0.2})
+		if err != nil {
+			t.Fatalf("Failed to split dataset: %s", err.Error())
+		}
+		if (training.Len() != 12) || (validation.Len() != 4) || (test.Len() != 4) {
+			t.Fatalf("Expected parts of 12, 4 and 4 samples, got %d, %d and %d", training.Len(), validation.Len(), test.Len())
+		}
+		/* NOTE(anton2920): without shuffling order is kept. */
+		if (training.Inputs[11][0] != 11) || (validation.Inputs[0][0] != 12) || (test.Inputs[0][0] != 16) {
+			t.Errorf("Expected parts to follow each other, got %v, %v and %v", training.Inputs, validation.Inputs, test.Inputs)
+		}
+	})
+
+	t.Run("stratified", func(t *testing.T) {
+		d := testDataset(40, 4)
+
+		training, validation, test, err := d.Split(&SplitOptions{ValidationSplit: 0.25, TestSplit: 0.25, Shuffle: true, Seed: Seed, Stratified: true})
+		if err != nil {
+			t.Fatalf("Failed to split dataset: %s", err.Error())
+		}
+
+		var all []int
+		for _, part := range [...]struct {
+			Dataset Dataset
+			Count   int
+		}{{training, 4}, {validation, 3}, {test, 3}} {
+			for class, count := range testClasses(part.Dataset, 4) {
+				if count != part.Count {
+					t.Errorf("Expected %d samples of class %d, got %d", part.Count, class, count)
+				}
+			}
+			all = append(all, testSamples(t, part.Dataset)...)
+		}
+		sort.Ints(all)
+		for i := 0; i < len(all); i++ {
+			if all[i] != i {
+				t.Fatalf("Parts overlap or miss samples: %v", all)
+			}
+		}
+
+		/* NOTE(anton2920): without shuffling samples of different classes must stay interleaved. */
+		training, _, _, err = d.Split(&SplitOptions{ValidationSplit: 0.25, TestSplit: 0.25, Stratified: true})
+		if err != nil {
+			t.Fatalf("Failed to split dataset: %s", err.Error())
+		}
+		for i := 1; i < training.Len(); i++ {
+			if training.Inputs[i-1][0] >= training.Inputs[i][0] {
+				t.Fatalf("Expected training samples in original order, got %v", training.Inputs)
+			}
+		}
+	})
+
+	t.Run("folds", func(t *testing.T) {
+		for _, stratified := range [...]bool{false, true} {
+			d := testDataset(23, 3)
+
+			folds, err := d.Folds(5, &SplitOptions{Shuffle: true, Seed: Seed, Stratified: stratified})
+			if err != nil {
+				t.Fatalf("Failed to split dataset into folds: %s", err.Error())
+			}
+
+			validated := make([]int, d.Len())
+			for _, fold := range folds {
+				if fold.Training.Len()+fold.Validation.Len() != d.Len() {
+					t.Errorf("Expected fold to have %d samples, got %d", d.Len(), fold.Training.Len()+fold.Validation.Len())
+				}
+				if (fold.Validation.Len() < 4) || (fold.Validation.Len() > 5) {
+					t.Errorf("Expected 4 or 5 validation samples, got %d", fold.Validation.Len())
+				}
+				for _, id := range testSamples(t, fold.Validation) {
+					validated[id]++
+				}
+			}
+			for id, count := range validated {
+				if count != 1 {
+					t.Errorf("Sample #%d is validated %d times", id, count)
+				}
+			}
+		}
+	})
+
+	t.Run("cross-validate", func(t *testing.T) {
+		d := testDataset(8, 2)
+
+		means, stddevs, err := d.CrossValidate(4, &SplitOptions{}, func(fold int, training, validation Dataset) ([]float32, error) {
+			return []float32{float32(fold), float32(training.Len())}, nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to cross-validate: %s", err.Error())
+		}
+		if (means[0] != 1.5) || (math.Abs(float64(stddevs[0])-math.Sqrt(1.25)) > 1e-6) {
+			t.Errorf("Expected mean 1.5 and stddev %f, got %f and %f", math.Sqrt(1.25), means[0], stddevs[0])
+		}
+		if (means[1] != 6) || (stddevs[1] != 0) {
+			t.Errorf("Expected mean 6 and stddev 0, got %f and %f", means[1], stddevs[1])
+		}
+	})
+}
+
+/* testSeries returns n rows of linear trend plus seasonal pattern in the first column and row index in the second. */
+func testSeries(n int, pattern []float32) [][]float32 {
+	series := make([][]float32, n)
+	for t := 0; t < n; t++ {
+		series[t] = []float32{0.5*float32(t) + pattern[t%len(pattern)], float32(t)}
+	}
+	return series
+}
+
+func TestTimeSeries(t *testing.T) {
+	pattern := []float32{2, -1, 0, -1}
+	series := testSeries(24, pattern)
+
+	t.Run("windows", func(t *testing.T) {
+		d, err := Windows(series, &WindowOptions{Lookback: 3, Horizon: 2, InputColumns: []int{1, 0}, OutputColumns: []int{1}})
+		if err != nil {
+			t.Fatalf("Failed to make windows: %s", err.Error())
+		}
+		if d.Len() != len(series)-3-2+1 {
+			t.Fatalf("Expected %d samples, got %d", len(series)-4, d.Len())
+		}
+		if expected := []float32{5, series[5][0], 6, series[6][0], 7, series[7][0]}; !reflect.DeepEqual(d.Inputs[5], expected) {
+			t.Errorf("Expected inputs %v, got %v", expected, d.Inputs[5])
+		}
+		if expected := []float32{8, 9}; !reflect.DeepEqual(d.Outputs[5], expected) {
+			t.Errorf("Expected outputs %v, got %v", expected, d.Outputs[5])
+		}
+
+		for _, options := range [...]WindowOptions{{Lookback: 0, Horizon: 1}, {Lookback: 20, Horizon: 5}, {Lookback: 1, Horizon: 1, OutputColumns: []int{2}}} {
+			if _, err := Windows(series, &options); err == nil {
+				t.Errorf("Expected error for options %+v", options)
+			}
+		}
+	})
+
+	t.Run("walk-forward", func(t *testing.T) {
+		d := testDataset(20, 2)
+		for _, test := range [...]struct {
+			Options WalkForwardOptions
+			Firsts  []int
+		}{
+			{WalkForwardOptions{Folds: 3, ValidationSize: 4}, []int{0, 0, 0}},
+			{WalkForwardOptions{Folds: 3, ValidationSize: 4, Window: 5, Gap: 2}, []int{1, 5, 9}},
+		} {
+			folds, err := d.WalkForward(&test.Options)
+			if err != nil {
+				t.Fatalf("Failed to make folds with %+v: %s", test.Options, err.Error())
+			}
+			for f, fold := range folds {
+				training, validation := testSamples(t, fold.Training), testSamples(t, fold.Validation)
+				if expected := ColumnRange(8+4*f, 12+4*f); !reflect.DeepEqual(validation, expected) {
+					t.Errorf("Expected validation samples %v in fold #%d of %+v, got %v", expected, f, test.Options, validation)
+				}
+				if expected := ColumnRange(test.Firsts[f], 8+4*f-test.Options.Gap); !reflect.DeepEqual(training, expected) {
+					t.Errorf("Expected training samples %v in fold #%d of %+v, got %v", expected, f, test.Options, training)
+				}
+			}
+		}
+
+		if _, err := d.WalkForward(&WalkForwardOptions{Folds: 5, ValidationSize: 4}); err == nil {
+			t.Errorf("Expected error when no samples are left for training")
+		}
+
+		means, _, err := d.WalkForwardValidate(&WalkForwardOptions{Folds: 2, ValidationSize: 5}, func(fold int, training, validation Dataset) ([]float32, error) {
+			return []float32{float32(training.Len())}, nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to validate: %s", err.Error())
+		}
+		testNear(t, "mean training size", 12.5, means[0])
+	})
+
+	t.Run("difference", func(t *testing.T) {
+		for _, lag := range [...]int{1, 4} {
+			diffs, err := Difference(series, lag)
+			if err != nil {
+				t.Fatalf("Failed to difference series: %s", err.Error())
+			}
+			if len(diffs) != len(series)-lag {
+				t.Fatalf("Expected %d differences, got %d", len(series)-lag, len(diffs))
+			}
+			if lag == len(pattern) {
+				for _, diff := range diffs {
+					testNear(t, "seasonal difference", 0.5*float32(lag), diff[0])
+				}
+			}
+
+			rows, err := Integrate(series[:lag+3], diffs[3:], lag)
+			if err != nil {
+				t.Fatalf("Failed to integrate differences: %s", err.Error())
+			}
+			if !reflect.DeepEqual(rows, series[lag+3:]) {
+				t.Errorf("Expected integrated rows %v, got %v", series[lag+3:], rows)
+			}
+		}
+		if _, err := Integrate(series[:1], series, 2); err == nil {
+			t.Errorf("Expected error for short history")
+		}
+	})
+
+	t.Run("decompose", func(t *testing.T) {
+		d, err := Decompose(series, len(pattern))
+		if err != nil {
+			t.Fatalf("Failed to decompose series: %s", err.Error())
+		}
+
+		mean := (pattern[0] + pattern[1] + pattern[2] + pattern[3]) / 4
+		for p := 0; p < len(pattern); p++ {
+			testNear(t, fmt.Sprintf("season #%d", p), pattern[p]-mean, d.Seasons[p][0])
+		}
+		for tm := 0; tm < len(series); tm++ {
+			defined := (tm >= 2) && (tm < len(series)-2)
+			if math.IsNaN(float64(d.Trend[tm][0])) == defined {
+				t.Errorf("Expected trend of row %d to be defined: %v", tm, defined)
+			}
+			if defined {
+				testNear(t, fmt.Sprintf("trend of row %d", tm), 0.5*float32(tm)+mean, d.Trend[tm][0])
+				testNear(t, fmt.Sprintf("residual of row %d", tm), 0, d.Residual[tm][0])
+			}
+		}
+
+		if _, err := Decompose(series[:7], 4); err == nil {
+			t.Errorf("Expected error for series shorter than two periods")
+		}
+	})
+
+	t.Run("baselines", func(t *testing.T) {
+		options := WindowOptions{Lookback: 4, Horizon: 2, OutputColumns: []int{1}}
+		for _, test := range [...]struct {
+			Kind     int
+			Period   int
+			Expected []float32
+		}{
+			{BaselineNaive, 0, []float32{3, 3}},
+			{BaselineSeasonalNaive, 3, []float32{1, 2}},
+			{BaselineMovingAverage, 2, []float32{2.5, 2.5}},
+		} {
+			predictions, err := Baseline(test.Kind, series, &options, test.Period)
+			if err != nil {
+				t.Fatalf("Failed to compute %s baseline: %s", BaselineNames[test.Kind], err.Error())
+			}
+			if len(predictions) != len(series)-5 {
+				t.Errorf("Expected %d predictions, got %d", len(series)-5, len(predictions))
+			}
+			if !reflect.DeepEqual(predictions[0], test.Expected) {
+				t.Errorf("Expected %s predictions %v, got %v", BaselineNames[test.Kind], test.Expected, predictions[0])
+			}
+		}
+
+		/* NOTE(anton2920): seasonal naive forecast is exact for seasonal series without trend. */
+		seasonal := make([][]float32, len(series))
+		for tm := 0; tm < len(seasonal); tm++ {
+			seasonal[tm] = []float32{pattern[tm%len(pattern)]}
+		}
+		d, err := Windows(seasonal, &WindowOptions{Lookback: 4, Horizon: 6})
+		if err != nil {
+			t.Fatalf("Failed to make windows: %s", err.Error())
+		}
+		predictions, err := Baseline(BaselineSeasonalNaive, seasonal, &WindowOptions{Lookback: 4, Horizon: 6}, 4)
+		if err != nil {
+			t.Fatalf("Failed to compute seasonal naive baseline: %s", err.Error())
+		}
+		if !reflect.DeepEqual(predictions, d.Outputs) {
+			t.Errorf("Expected seasonal naive predictions to match outputs")
+		}
+
+		if _, err := Baseline(BaselineMovingAverage, series, &options, 5); err == nil {
+			t.Errorf("Expected error for period longer than lookback")
+		}
+	})
+}
+
+/* testExtrapolator returns NN which extrapolates line through the last two values of series for every one of steps ahead. */
+func testExtrapolator(steps int) *NN {
+	layer := Layer{Neurons: steps, FunctionID: FunctionIdentity, Weights: Matrix{Rows: steps, Cols: 2, Data: make([]float32, 2*steps)}, Biases: make([]float32, steps)}
+	for h := 1; h <= steps; h++ {
+		copy(layer.Weights.Row(h-1), []float32{-float32(h), float32(h + 1)})
+	}
+	return &NN{Layers: []Layer{layer}}
+}
+
+func TestForecast(t *testing.T) {
+	line := make([][]float32, 20)
+	noisy := make([][]float32, 20)
+	for tm := 0; tm < len(line); tm++ {
+		line[tm] = []float32{0.5*float32(tm) + 1}
+		noisy[tm] = []float32{line[tm][0] + 0.2*float32(tm%3-1)}
+	}
+
+	for kind := 0; kind < len(ForecastNames); kind++ {
+		options := ForecastOptions{Kind: kind, Steps: 3, Window: WindowOptions{Lookback: 2, Horizon: 1}, Level: 0.8, Samples: 200, Seed: Seed}
+		network := testExtrapolator(1)
+		if kind == ForecastDirect {
+			options.Window.Horizon = 3
+			network = testExtrapolator(3)
+		}
+
+		f := NewForecaster(network, &options)
+		forecast, err := f.Forecast(line[:10])
+		if err != nil {
+			t.Fatalf("Failed to make %s forecast: %s", ForecastNames[kind], err.Error())
+		}
+		if forecast.Lower != nil {
+			t.Errorf("Expected no %s prediction intervals before fit", ForecastNames[kind])
+		}
+		for s := 0; s < options.Steps; s++ {
+			testNear(t, fmt.Sprintf("%s forecast of step %d", ForecastNames[kind], s), line[10+s][0], forecast.Predictions[s][0])
+		}
+
+		if err := f.Fit(line[10:]); err != nil {
+			t.Fatalf("Failed to fit %s forecaster: %s", ForecastNames[kind], err.Error())
+		}
+		forecast, err = f.Forecast(line[:10])
+		if err != nil {
+			t.Fatalf("Failed to make %s forecast: %s", ForecastNames[kind], err.Error())
+		}
+		for s := 0; s < options.Steps; s++ {
+			testNear(t, fmt.Sprintf("%s interval width of step %d", ForecastNames[kind], s), 0, forecast.Upper[s][0]-forecast.Lower[s][0])
+		}
+
+		if err := f.Fit(noisy[10:]); err != nil {
+			t.Fatalf("Failed to fit %s forecaster: %s", ForecastNames[kind], err.Error())
+		}
+		if len(f.Residuals) != options.Steps {
+			t.Fatalf("Expected residuals for %d steps, got %d", options.Steps, len(f.Residuals))
+		}
+		forecast, err = f.Forecast(noisy[:10])
+		if err != nil {
+			t.Fatalf("Failed to make %s forecast: %s", ForecastNames[kind], err.Error())
+		}
+		for s := 0; s < options.Steps; s++ {
+			lower, prediction, upper := forecast.Lower[s][0], forecast.Predictions[s][0], forecast.Upper[s][0]
+			if !((lower < prediction) && (prediction < upper)) {
+				t.Errorf("Expected %s forecast of step %d to be inside interval, got %f not in (%f; %f)", ForecastNames[kind], s, prediction, lower, upper)
+			}
+		}
+		if forecast.Upper[2][0]-forecast.Lower[2][0] <= forecast.Upper[0][0]-forecast.Lower[0][0] {
+			t.Errorf("Expected %s prediction intervals to widen with steps, got %v and %v", ForecastNames[kind], forecast.Lower, forecast.Upper)
+		}
+	}
+
+	for _, options := range [...]ForecastOptions{
+		{Kind: ForecastRecursive, Steps: 3, Window: WindowOptions{Lookback: 2, Horizon: 3}},
+		{Kind: ForecastDirect, Steps: 4, Window: WindowOptions{Lookback: 2, Horizon: 3}},
+		{Kind: ForecastRecursive, Steps: 3, Window: WindowOptions{Lookback: 2, Horizon: 1, InputColumns: []int{0}, OutputColumns: []int{1}}},
+	} {
+		if _, err := NewForecaster(testExtrapolator(options.Window.Horizon), &options).Forecast(testSeries(10, []float32{0})); err == nil {
+			t.Errorf("Expected error for options %+v", options)
+		}
+	}
+}
+
+func TestScalers(t *testing.T) {
+	rows := [][]float32{{1, 5, -2}, {2, 5, 0}, {3, 5, 2}, {4, 5, 4}, {100, 5, 6}}
+
+	scalers := [...]struct {
+		Kind     int
+		Expected []float32 /* First column. */
+	}{
+		{ScalerMinMax01, []float32{0, 1.0 / 99, 2.0 / 99, 3.0 / 99, 1}},
+		{ScalerMinMax11, []float32{-1, -1 + 2.0/99, -1 + 4.0/99, -1 + 6.0/99, 1}},
+		{ScalerZScore, []float32{-0.5383, -0.5127, -0.4870, -0.4614, 1.9993}},
+		{ScalerRobust, []float32{-1, -0.5, 0, 0.5, 48.5}},
+	}
+
+	for _, test := range scalers {
+		kind := test.Kind
+		expected := test.Expected
+		t.Run(ScalerNames[kind], func(t *testing.T) {
+			data := make([][]float32, len(rows))
+			for i := 0; i < len(rows); i++ {
+				data[i] = append([]float32(nil), rows[i]...)
+			}
+
+			s := NewScaler(kind, 0, 1)
+			if err := s.FitTransform(data); err != nil {
+				t.Fatalf("Failed to fit scaler: %s", err.Error())
+			}
+			for i := 0; i < len(data); i++ {
+				if math.Abs(float64(data[i][0]-expected[i])) > 1e-4 {
+					t.Errorf("Expected row #%d to be scaled to %f, got %f", i, expected[i], data[i][0])
+				}
+				/* NOTE(anton2920): column with zero range is only shifted, column that is not selected is left intact. */
+				if data[i][1] != 0 {
+					t.Errorf("Expected constant column to be scaled to 0, got %f", data[i][1])
+				}
+				if data[i][2] != rows[i][2] {
+					t.Errorf("Expected column 2 to be intact, got %f instead of %f", data[i][2], rows[i][2])
+				}
+			}
+
+			s.InverseTransform(data)
+			for i := 0; i < len(data); i++ {
+				for j := 0; j < len(data[i]); j++ {
+					if math.Abs(float64(data[i][j]-rows[i][j])) > 1e-4 {
+						t.Errorf("Inverse transform of row #%d gave %v instead of %v", i, data[i], rows[i])
+						break
+					}
+				}
+			}
+		})
+	}
+
+	t.Run("predict", func(t *testing.T) {
+		nn := testNN()
+		nn.Init(len(testInputs[0]), rand.New(rand.NewSource(Seed)))
+		nn.InputScaler = &Scaler{Kind: ScalerMinMax01, Columns: []int{0, 1}, Offsets: []float32{10, 20}, Scales: []float32{10, 10}}
+		nn.OutputScaler = &Scaler{Kind: ScalerMinMax01, Columns: []int{1}, Offsets: []float32{-5}, Scales: []float32{100}}
+
+		for i := 0; i < len(testInputs); i++ {
+			expected := append([]float32(nil), nn.Query(testInputs[i])...)
+			expected[1] = expected[1]*100 - 5
+
+			inputs := []float32{10 + 10*testInputs[i][0], 20 + 10*testInputs[i][1]}
+			actual := nn.Predict(inputs)
+			if (actual[0] != expected[0]) || (math.Abs(float64(actual[1]-expected[1])) > 1e-4) {
+				t.Errorf("Expected prediction %v for %v, got %v", expected, inputs, actual)
+			}
+			if inputs[0] != 10+10*testInputs[i][0] {
+				t.Errorf("Predict modified inputs")
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if err := NewScaler(ScalerZScore, 3).Fit(rows); err == nil {
+			t.Errorf("Expected error for missing column, got nil")
+		}
+		if err := NewScaler(len(ScalerNames)).Fit(rows); err == nil {
+			t.Errorf("Expected error for unknown scaler, got nil")
+		}
+
+		nn := testNN()
+		nn.Init(len(testInputs[0]), rand.New(rand.NewSource(Seed)))
+		nn.OutputScaler = &Scaler{Kind: ScalerZScore, Columns: []int{2}, Offsets: []float32{0}, Scales: []float32{1}}
+		if err := nn.Encode(io.Discard); err == nil {
+			t.Errorf("Expected error for scaler column out of range, got nil")
+		}
+	})
+}
+
+func testNear(t *testing.T, name string, expected, actual float32) {
+	t.Helper()
+	if math.Abs(float64(expected-actual)) > 1e-4 {
+		t.Errorf("Expected %s to be %f, got %f", name, expected, actual)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("regression", func(t *testing.T) {
+		expected := [][]float32{{1, 10}, {2, 20}, {3, 30}, {4, 40}}
+		predicted := [][]float32{{1.5, 10}, {2, 18}, {2, 30}, {4, 44}}
+
+		m := Regression(predicted, expected)
+		testNear(t, "MSE", (0.25+4+1+16)/8, m.MSE)
+		testNear(t, "RMSE", float32(math.Sqrt((0.25+4+1+16)/8)), m.RMSE)
+		testNear(t, "MAE", (0.5+2+1+4)/8, m.MAE)
+		testNear(t, "MAPE", 100*(0.5+0.1+1.0/3+0.1)/8, m.MAPE)
+		testNear(t, "R²", 1-(0.25+4+1+16)/(5+500), m.R2)
+	})
+
+	t.Run("classification", func(t *testing.T) {
+		expected := [][]float32{{1, 0, 0}, {1, 0, 0}, {0, 1, 0}, {0, 1, 0}, {0, 0, 1}, {0, 0, 1}}
+		predicted := [][]float32{{0.8, 0.1, 0.1}, {0.4, 0.5, 0.1}, {0.2, 0.7, 0.1}, {0.1, 0.6, 0.3}, {0.3, 0.3, 0.4}, {0.6, 0.1, 0.3}}
+
+		m := Classification(predicted, expected)
+		testNear(t, "accuracy", 4.0/6, m.Accuracy)
+		if !reflect.DeepEqual(m.Confusion, [][]int{{1, 1, 0}, {0, 2, 0}, {1, 0, 1}}) {
+			t.Errorf("Unexpected confusion matrix %v", m.Confusion)
+		}
+		testNear(t, "precision of class 1", 2.0/3, m.Precision[1])
+		testNear(t, "recall of class 0", 0.5, m.Recall[0])
+		testNear(t, "F1 of class 2", 2.0/3, m.F1[2])
+		/* NOTE(anton2920): scores of class 0 are 0.8 and 0.4 for positives, 0.2, 0.1, 0.3 and 0.6 for negatives. */
+		testNear(t, "ROC-AUC of class 0", 7.0/8, m.AUC[0])
+		testNear(t, "ROC-AUC of class 1", 1, m.AUC[1])
+
+		m.Names = []string{"a", "b", "c"}
+
+		var text bytes.Buffer
+		if err := m.WriteText(&text); err != nil {
+			t.Fatalf("Failed to write report: %s", err.Error())
+		}
+		if !strings.Contains(text.String(), "Accuracy: 0.6667") || !strings.Contains(text.String(), "Expected \\ predicted") {
+			t.Errorf("Unexpected report:\n%s", text.String())
+		}
+
+		var buffer bytes.Buffer
+		if err := m.WriteCSV(&buffer); err != nil {
+			t.Fatalf("Failed to write CSV report: %s", err.Error())
+		}
+		records, err := csv.NewReader(&buffer).ReadAll()
+		if err != nil {
+			t.Fatalf("Failed to read CSV report: %s", err.Error())
+		}
+		if len(records) != 1+1+5*3+3*3 {
+			t.Errorf("Expected %d records, got %d", 1+1+5*3+3*3, len(records))
+		}
+		if last := records[len(records)-1]; !reflect.DeepEqual(last, []string{"confusion", "c", "c", "1"}) {
+			t.Errorf("Unexpected last record %v", last)
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		expected := [][]float32{{0}, {0}, {1}, {1}}
+		predicted := [][]float32{{0.1}, {0.6}, {0.7}, {0.9}}
+
+		m := Classification(predicted, expected)
+		testNear(t, "accuracy", 0.75, m.Accuracy)
+		testNear(t, "ROC-AUC", 1, m.AUC[1])
+		testNear(t, "recall of class 0", 0.5, m.Recall[0])
+	})
+
+	t.Run("query", func(t *testing.T) {
+		nn := testNN()
+		nn.Init(len(testInputs[0]), rand.New(rand.NewSource(Seed)))
+
+		outputs := nn.QueryAll(testInputs)
+		for i := 0; i < len(testInputs); i++ {
+			if !reflect.DeepEqual(outputs[i], nn.Query(testInputs[i])) {
+				t.Errorf("Expected outputs %v for %v, got %v", nn.Query(testInputs[i]), testInputs[i], outputs[i])
+			}
+		}
+	})
+}
+
+func TestCSV(t *testing.T) {
+	const data = "city; lat; lon; rain\n" +
+		"Orel; 52,9651; 36,0785; 1\n" +
+		"Tula; 54,1961; NA; 0\n" +
+		"Orel; 53,0; 36,1; \n" +
+		"Kaluga; ; 36,2754; 1\n"
+
+	t.Run("read", func(t *testing.T) {
+		table, err := DecodeCSV(strings.NewReader(data), "cities.csv", &CSVOptions{
+			Comma:         ';',
+			Decimal:       ',',
+			Header:        true,
+			Columns:       []string{"lat", "2", "city"},
+			Missing:       MissingMean,
+			MissingValues: []string{"NA"},
+			Categorical:   []string{"city"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to read CSV: %s", err.Error())
+		}
+
+		if expected := []string{"lat", "lon", "city=Orel", "city=Tula", "city=Kaluga"}; !reflect.DeepEqual(table.Names, expected) {
+			t.Errorf("Expected columns %v, got %v", expected, table.Names)
+		}
+		expected := [][]float32{
+			{52.9651, 36.0785, 1, 0, 0},
+			{54.1961, (36.0785 + 36.1 + 36.2754) / 3, 0, 1, 0},
+			{53.0, 36.1, 1, 0, 0},
+			{(52.9651 + 54.1961 + 53.0) / 3, 36.2754, 0, 0, 1},
+		}
+		for i := 0; i < len(expected); i++ {
+			for j := 0; j < len(expected[i]); j++ {
+				testNear(t, fmt.Sprintf("%s of row #%d", table.Names[j], i), expected[i][j], table.Rows[i][j])
+			}
+		}
+
+		/* NOTE(anton2920): indices are of CSV columns, so "0" selects every one-hot encoded column of city. */
+		for _, inputs := range [...][]string{{"city", "lon"}, {"0", "2"}} {
+			dataset, err := table.Dataset(inputs, []string{"lat"})
+			if err != nil {
+				t.Fatalf("Failed to select dataset: %s", err.Error())
+			}
+			if (len(dataset.Inputs[3]) != 4) || (dataset.Inputs[3][2] != 1) || (dataset.Inputs[3][3] != 36.2754) || (dataset.Outputs[2][0] != 53) {
+				t.Errorf("Wrong dataset selected by %v: %v, %v", inputs, dataset.Inputs, dataset.Outputs)
+			}
+		}
+		if _, err := table.Dataset([]string{"rain"}, nil); err == nil {
+			t.Errorf("Expected error for column which was not read")
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		for _, test := range [...]struct {
+			Missing int
+			Rows    int
+			Rain    float32
+		}{{MissingSkip, 1, 1}, {MissingZero, 4, 0}, {MissingMedian, 4, 1}} {
+			table, err := DecodeCSV(strings.NewReader(data), "cities.csv", &CSVOptions{Comma: ';', Decimal: ',', Header: true, Columns: []string{"rain", "lat", "lon"}, Missing: test.Missing, MissingValues: []string{"NA"}})
+			if err != nil {
+				t.Fatalf("Failed to read CSV with %s strategy: %s", MissingNames[test.Missing], err.Error())
+			}
+			if len(table.Rows) != test.Rows {
+				t.Errorf("Expected %d rows with %s strategy, got %d", test.Rows, MissingNames[test.Missing], len(table.Rows))
+			}
+			if (test.Rows == 4) && (table.Rows[2][0] != test.Rain) {
+				t.Errorf("Expected missing rain to be %g with %s strategy, got %g", test.Rain, MissingNames[test.Missing], table.Rows[2][0])
+			}
+		}
+	})
+
+	t.Run("categories", func(t *testing.T) {
+		options := CSVOptions{Comma: ';', Header: true, Columns: []string{"city"}, Categorical: []string{"city"}, Categories: map[string][]string{"city": {"Kaluga", "Orel", "Tula"}}}
+		table, err := DecodeCSV(strings.NewReader(data), "cities.csv", &options)
+		if err != nil {
+			t.Fatalf("Failed to read CSV: %s", err.Error())
+		}
+		if (table.Names[0] != "city=Kaluga") || (table.Rows[3][0] != 1) {
+			t.Errorf("Expected fixed categories to be kept, got %v and %v", table.Names, table.Rows)
+		}
+
+		options.Categories["city"] = []string{"Orel"}
+		if _, err := DecodeCSV(strings.NewReader(data), "cities.csv", &options); (err == nil) || (err.Error() != `cities.csv:3:1: unknown category "Tula" of column "city"`) {
+			t.Errorf("Expected unknown category error, got %v", err)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, test := range [...]struct {
+			Data    string
+			Options CSVOptions
+			Error   string
+		}{
+			{"1,2\n3,x\n", CSVOptions{}, `test.csv:2:3: invalid number "x" in column "1"`},
+			{"a,b\n1,2\n3\n", CSVOptions{Header: true}, "test.csv:3:1: wrong number of fields"},
+			{"a,b\n1,\n", CSVOptions{Header: true}, `test.csv:2:3: missing value of column "b"`},
+			{"1,\"2\n", CSVOptions{}, `test.csv:1:6: extraneous or missing " in quoted-field`},
+			{"a,b\n1,2\n", CSVOptions{Header: true, Columns: []string{"c"}}, `test.csv: unknown column "c"`},
+			{"a,b\n1,2\n", CSVOptions{Header: true, Columns: []string{"a"}, Categorical: []string{"b"}}, `test.csv: categorical column "b" is not selected`},
+			{"a,b\n,2\n", CSVOptions{Header: true, Missing: MissingMean}, `test.csv: column "a" has no values`},
+		} {
+			_, err := DecodeCSV(strings.NewReader(test.Data), "test.csv", &test.Options)
+			if (err == nil) || (err.Error() != test.Error) {
+				t.Errorf("Expected error %q for %q, got %v", test.Error, test.Data, err)
+			}
+		}
+	})
+}
+
+func testReadSource(t *testing.T, source Source) [][]float32 {
+	var rows [][]float32
+
+	if err := source.Reset(); err != nil {
+		t.Fatalf("Failed to reset source: %s", err.Error())
+	}
+	for {
+		row, err := source.Next()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatalf("Failed to read source: %s", err.Error())
+		}
+		rows = append(rows, append([]float32(nil), row...))
+	}
+}
+
+func TestSources(t *testing.T) {
+	rows := make([][]float32, 50)
+	for i := 0; i < len(rows); i++ {
+		rows[i] = []float32{float32(i), float32(i * i)}
+	}
+
+	t.Run("shuffle", func(t *testing.T) {
+		for _, size := range [...]int{1, 7, len(rows), 2 * len(rows)} {
+			source := NewShuffleSource(NewSliceSource(rows), size, Seed)
+			first := testReadSource(t, source)
+			second := testReadSource(t, source)
+
+			for _, shuffled := range [...][][]float32{first, second} {
+				sorted := append([][]float32(nil), shuffled...)
+				sort.Slice(sorted, func(i, j int) bool { return sorted[i][0] < sorted[j][0] })
+				if !reflect.DeepEqual(sorted, rows) {
+					t.Fatalf("Expected permutation of rows with buffer of %d, got %v", size, shuffled)
+				}
+			}
+			if (size > 1) && (reflect.DeepEqual(first, rows) || reflect.DeepEqual(first, second)) {
+				t.Errorf("Expected different order of rows with buffer of %d", size)
+			}
+			if again := testReadSource(t, NewShuffleSource(NewSliceSource(rows), size, Seed)); !reflect.DeepEqual(again, first) {
+				t.Errorf("Expected the same order of rows with the same seed and buffer of %d", size)
+			}
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		const data = "a;b;c\n1;x;2,5\n3;y;\n5;x;4\n"
+
+		filename := filepath.Join(t.TempDir(), "test.csv")
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write CSV: %s", err.Error())
+		}
+		options := CSVOptions{Comma: ';', Decimal: ',', Header: true, Columns: []string{"b", "c", "0"}, Missing: MissingSkip, Categorical: []string{"b"}, Categories: map[string][]string{"b": {"x", "y"}}}
+
+		table, err := ReadCSV(filename, &options)
+		if err != nil {
+			t.Fatalf("Failed to read CSV: %s", err.Error())
+		}
+		source, err := NewCSVSource(filename, &options)
+		if err != nil {
+			t.Fatalf("Failed to open CSV source: %s", err.Error())
+		}
+		defer source.Close()
+
+		if !reflect.DeepEqual(source.Names(), table.Names) {
+			t.Errorf("Expected names %v, got %v", table.Names, source.Names())
+		}
+		for pass := 0; pass < 2; pass++ {
+			if rows := testReadSource(t, source); !reflect.DeepEqual(rows, table.Rows) {
+				t.Errorf("Expected rows %v, got %v", table.Rows, rows)
+			}
+		}
+
+		for _, options := range [...]CSVOptions{{Header: true, Missing: MissingMean}, {Header: true, Categorical: []string{"b"}}} {
+			if _, err := NewCSVSource(filename, &options); err == nil {
+				t.Errorf("Expected error for options %+v", options)
+			}
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		dir := t.TempDir()
+		filename := filepath.Join(dir, "test.cache")
+
+		count, err := StoreCache(filename, NewSliceSource(rows))
+		if err != nil {
+			t.Fatalf("Failed to store cache: %s", err.Error())
+		}
+		if count != len(rows) {
+			t.Errorf("Expected %d rows to be stored, got %d", len(rows), count)
+		}
+
+		source, err := OpenCache(filename)
+		if err != nil {
+			t.Fatalf("Failed to open cache: %s", err.Error())
+		}
+		defer source.Close()
+		if source.Cols() != len(rows[0]) {
+			t.Errorf("Expected %d columns, got %d", len(rows[0]), source.Cols())
+		}
+		for pass := 0; pass < 2; pass++ {
+			if cached := testReadSource(t, source); !reflect.DeepEqual(cached, rows) {
+				t.Errorf("Expected rows %v, got %v", rows, cached)
+			}
+		}
+
+		if _, err := WriteCache(io.Discard, NewSliceSource([][]float32{{1, 2}, {3}})); err == nil {
+			t.Errorf("Expected error for rows of different length")
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatalf("Failed to read cache: %s", err.Error())
+		}
+		truncated := filepath.Join(dir, "truncated.cache")
+		if err := os.WriteFile(truncated, data[:len(data)-2], 0644); err != nil {
+			t.Fatalf("Failed to write cache: %s", err.Error())
+		}
+		source, err = OpenCache(truncated)
+		if err != nil {
+			t.Fatalf("Failed to open truncated cache: %s", err.Error())
+		}
+		defer source.Close()
+		for err == nil {
+			_, err = source.Next()
+		}
+		if (err == io.EOF) || (!strings.Contains(err.Error(), "truncated")) {
+			t.Errorf("Expected truncated cache error, got %v", err)
+		}
+
+		copy(data, "NNMF")
+		if err := os.WriteFile(truncated, data, 0644); err != nil {
+			t.Fatalf("Failed to write cache: %s", err.Error())
+		}
+		if _, err := OpenCache(truncated); !errors.Is(err, ErrBadCacheMagic) {
+			t.Errorf("Expected %v, got %v", ErrBadCacheMagic, err)
+		}
+	})
+
+	t.Run("train", func(t *testing.T) {
+		const eps = 0.1
+
+		data := make([][]float32, len(testInputs))
+		for i := 0; i < len(testInputs); i++ {
+			data[i] = append(append([]float32(nil), testInputs[i]...), testOutputs[i]...)
+		}
+
+		for _, batchSize := range [...]int{1, 3} {
+			nn := testNN()
+			source := NewShuffleSource(NewSliceSource(data), len(data), Seed)
+			if _, err := nn.TrainSource(source, len(testInputs[0]), batchSize, 1, NewSGD(0.5), nil, eps, 100000); err != nil {
+				t.Fatalf("Failed to train NN with batch of %d: %s", batchSize, err.Error())
+			}
+			for i := 0; i < len(testInputs); i++ {
+				outputs := nn.Query(testInputs[i])
+				for j, output := range outputs {
+					if math.Abs(float64(output-testOutputs[i][j])) > eps {
+						t.Errorf("NN failed to compute output #%d of %v with batch of %d: expected %.2f, got %.2f", j, testInputs[i], batchSize, testOutputs[i][j], output)
+					}
+				}
+			}
+		}
+
+		nn := testNN()
+		if _, err := nn.TrainSource(NewSliceSource(nil), 2, 1, 1, NewSGD(0.5), nil, eps, 100000); err == nil {
+			t.Errorf("Expected error for empty source")
+		}
+		if _, err := nn.TrainSource(NewSliceSource(testInputs), 2, 1, 1, NewSGD(0.5), nil, eps, 100000); err == nil {
+			t.Errorf("Expected error for rows without outputs")
+		}
+	})
+}
+
+func TestNNStoreLoad(t *testing.T) {
+	var loaded NN
+
+	nn := testNN()
+	nn.Layers[0].Dropout = 0.1
+	nn.Regularization = Regularization{L2: 1e-4, MaxGradNorm: 5}
+	nn.InputScaler = &Scaler{Kind: ScalerMinMax11, Columns: []int{1}, Offsets: []float32{0.5}, Scales: []float32{0.5}}
+	nn.OutputScaler = &Scaler{Kind: ScalerZScore, Columns: []int{0, 1}, Offsets: []float32{0.25, 0.75}, Scales: []float32{0.4, 0.4}}
+	if _, err := nn.Train(testInputs, testOutputs, 1, 1, NewSGD(0.5), nil, 0.1, 100000); err != nil {
+		t.Fatalf("Failed to train NN: %s", err.Error())
+	}
+
+	filename := filepath.Join(t.TempDir(), "nn.bin")
+	if err := nn.Store(filename); err != nil {
+		t.Fatalf("Failed to store NN: %s", err.Error())
+	}
+
+	if err := loaded.Load(filename); err != nil {
+		t.Fatalf("Failed to load NN: %s", err.Error())
+	}
+
+	if loaded.Regularization != nn.Regularization {
+		t.Errorf("Expected regularization %v, got %v", nn.Regularization, loaded.Regularization)
+	}
+	if loaded.Layers[0].Dropout != nn.Layers[0].Dropout {
+		t.Errorf("Expected dropout %f, got %f", nn.Layers[0].Dropout, loaded.Layers[0].Dropout)
+	}
+	if (!reflect.DeepEqual(loaded.InputScaler, nn.InputScaler)) || (!reflect.DeepEqual(loaded.OutputScaler, nn.OutputScaler)) {
+		t.Errorf("Expected scalers %v and %v, got %v and %v", nn.InputScaler, nn.OutputScaler, loaded.InputScaler, loaded.OutputScaler)
+	}
+
+	for i := 0; i < len(testInputs); i++ {
+		expected := nn.Predict(testInputs[i])
+		actual := loaded.Predict(testInputs[i])
+		for j := 0; j < len(expected); j++ {
+			if expected[j] != actual[j] {
+				t.Errorf("Loaded NN differs for %v: expected %f, got %f", testInputs[i], expected[j], actual[j])
+			}
+		}
+	}
+}
+
+func TestModelFormat(t *testing.T) {
+	var buffer bytes.Buffer
+
+	nn := testNN()
+	nn.Init(len(testInputs[0]), rand.New(rand.NewSource(Seed)))
+	if err := nn.Encode(&buffer); err != nil {
+		t.Fatalf("Failed to encode NN: %s", err.Error())
+	}
+	data := buffer.Bytes()
+
+	corrupt := func(offset int, value byte) []byte {
+		corrupted := append([]byte(nil), data...)
+		corrupted[offset] = value
+		return corrupted
+	}
+
+	for _, test := range [...]struct {
+		Name  string
+		Data  []byte
+		Error string
+	}{
+		{"magic", corrupt(0, 'X'), ErrBadMagic.Error()},
+		{"version", corrupt(4, FormatVersion+1), fmt.Sprintf("unsupported model file version %d, expected %d", FormatVersion+1, FormatVersion)},
+		{"checksum", corrupt(len(data)/2, data[len(data)/2]+1), ErrChecksum.Error()},
+		{"truncated", data[:len(data)-10], ErrChecksum.Error()},
+		{"header", data[:5], "truncated model file: unexpected EOF"},
+		{"empty", nil, "truncated model file: EOF"},
+	} {
+		data := test.Data
+		expected := test.Error
+		t.Run(test.Name, func(t *testing.T) {
+			var loaded NN
+
+			err := loaded.Decode(bytes.NewReader(data))
+			if err == nil {
+				t.Fatalf("Expected error %q, got nil", expected)
+			}
+			if err.Error() != expected {
+				t.Errorf("Expected error %q, got %q", expected, err.Error())
+			}
+		})
+	}
+
+	t.Run("function", func(t *testing.T) {
+		nn := testNN()
+		nn.Init(len(testInputs[0]), rand.New(rand.NewSource(Seed)))
+		nn.Layers[1].FunctionID = len(Functions)
+		if err := nn.Encode(&buffer); err == nil {
+			t.Errorf("Expected error for unknown activation function, got nil")
+		}
+	})
+
+	t.Run("layers", func(t *testing.T) {
+		var empty, loaded NN
+		var buffer bytes.Buffer
+
+		if err := empty.Encode(&buffer); err != nil {
+			t.Fatalf("Failed to encode NN: %s", err.Error())
+		}
+		if err := loaded.Decode(&buffer); (err == nil) || (err.Error() != "NN has no layers") {
+			t.Errorf("Expected error %q, got %v", "NN has no layers", err)
+		}
+	})
+
+	t.Run("names", func(t *testing.T) {
+		if !bytes.Contains(data, []byte(FunctionNames[FunctionTh])) || !bytes.Contains(data, []byte(FunctionNames[FunctionSigmoid])) {
+			t.Errorf("Expected activation functions to be stored by name")
+		}
+	})
+}
+
+/* testProtoFields splits protobuf message into length-delimited fields, ignoring others. */
+func testProtoFields(t *testing.T, data []byte) map[int][][]byte {
+	t.Helper()
+
+	fields := make(map[int][][]byte)
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			t.Fatalf("Invalid protobuf key")
+		}
+		data = data[n:]
+
+		switch key & 7 {
+		case 0:
+			_, n = binary.Uvarint(data)
+			data = data[n:]
+		case 2:
+			length, n := binary.Uvarint(data)
+			if (n <= 0) || (int(length) > len(data)-n) {
+				t.Fatalf("Invalid protobuf length")
+			}
+			fields[int(key>>3)] = append(fields[int(key>>3)], data[n:n+int(length)])
+			data = data[n+int(length):]
+		case 5:
+			data = data[4:]
+		default:
+			t.Fatalf("Unexpected protobuf wire type %d", key&7)
+		}
+	}
+
+	return fields
+}
+
+func TestExport(t *testing.T) {
+	nn := testNN()
+	nn.LossID = LossHuber
+	nn.InputScaler = &Scaler{Kind: ScalerRobust, Columns: []int{0, 1}, Offsets: []float32{0.5, 0.5}, Scales: []float32{1, 1}}
+	if _, err := nn.Train(testInputs, testOutputs, 1, 1, NewSGD(0.5), nil, 0.1, 100000); err != nil {
+		t.Fatalf("Failed to train NN: %s", err.Error())
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buffer bytes.Buffer
+		var imported NN
+
+		if err := nn.ExportJSON(&buffer); err != nil {
+			t.Fatalf("Failed to export NN: %s", err.Error())
+		}
+		data := buffer.String()
+		if !strings.Contains(data, `"activation": "tanh"`) || !strings.Contains(data, `"loss": "huber"`) {
+			t.Errorf("Expected activation and loss to be exported by name, got %s", data)
+		}
+
+		if err := imported.ImportJSON(strings.NewReader(data)); err != nil {
+			t.Fatalf("Failed to import NN: %s", err.Error())
+		}
+		if imported.LossID != nn.LossID {
+			t.Errorf("Expected loss %d, got %d", nn.LossID, imported.LossID)
+		}
+		if (!reflect.DeepEqual(imported.InputScaler, nn.InputScaler)) || (imported.OutputScaler != nil) {
+			t.Errorf("Expected scalers %v and nil, got %v and %v", nn.InputScaler, imported.InputScaler, imported.OutputScaler)
+		}
+		for i := 0; i < len(testInputs); i++ {
+			expected := nn.Query(testInputs[i])
+			actual := imported.Query(testInputs[i])
+			for j := 0; j < len(expected); j++ {
+				if expected[j] != actual[j] {
+					t.Errorf("Imported NN differs for %v: expected %f, got %f", testInputs[i], expected[j], actual[j])
+				}
+			}
+		}
+
+		if err := imported.ImportJSON(strings.NewReader(strings.Replace(data, `"tanh"`, `"unknown"`, 1))); err == nil {
+			t.Errorf("Expected error for unknown activation function, got nil")
+		}
+		if err := imported.ImportJSON(strings.NewReader(strings.Replace(data, `"inputs": 4`, `"inputs": 3`, 1))); err == nil {
+			t.Errorf("Expected error for inconsistent number of inputs, got nil")
+		}
+		if err := imported.ImportJSON(strings.NewReader(strings.Replace(data, `"dropout": 0`, `"dropout": 1`, 1))); err == nil {
+			t.Errorf("Expected error for dropout out of range, got nil")
+		}
+
+		var empty NN
+		buffer.Reset()
+		if err := empty.ExportJSON(&buffer); err != nil {
+			t.Fatalf("Failed to export NN: %s", err.Error())
+		}
+		if err := imported.ImportJSON(&buffer); (err == nil) || (err.Error() != "NN has no layers") {
+			t.Errorf("Expected error %q for NN without layers, got %v", "NN has no layers", err)
+		}
+	})
+
+	t.Run("onnx", func(t *testing.T) {
+		var buffer bytes.Buffer
+
+		if err := nn.ExportONNX(&buffer); err != nil {
+			t.Fatalf("Failed to export NN: %s", err.Error())
+		}
+
+		model := testProtoFields(t, buffer.Bytes())
+		if len(model[7]) != 1 {
+			t.Fatalf("Expected one graph, got %d", len(model[7]))
+		}
+		graph := testProtoFields(t, model[7][0])
+
+		expectedOps := []string{"Gemm", "Tanh", "Gemm", "Sigmoid"}
+		if len(graph[1]) != len(expectedOps) {
+			t.Fatalf("Expected %d nodes, got %d", len(expectedOps), len(graph[1]))
+		}
+		for i, node := range graph[1] {
+			if op := string(testProtoFields(t, node)[4][0]); op != expectedOps[i] {
+				t.Errorf("Expected node #%d to be %s, got %s", i, expectedOps[i], op)
+			}
+		}
+
+		if len(graph[5]) != 2*len(nn.Layers) {
+			t.Fatalf("Expected %d initializers, got %d", 2*len(nn.Layers), len(graph[5]))
+		}
+		for l := 0; l < len(nn.Layers); l++ {
+			weights := testProtoFields(t, graph[5][2*l])
+			if name := string(weights[8][0]); name != fmt.Sprintf("layer%d_weights", l) {
+				t.Errorf("Unexpected initializer name %s", name)
+			}
+			raw := weights[9][0]
+			for i, weight := range nn.Layers[l].Weights.Data {
+				if actual := math.Float32frombits(binary.LittleEndian.Uint32(raw[4*i:])); actual != weight {
+					t.Errorf("Weight #%d of layer #%d: expected %f, got %f", i, l, weight, actual)
+				}
+			}
+		}
+
+		/* NOTE(anton2920): some functions are composed of several nodes, but the last one must always produce output. */
+		for functionID := 0; functionID < len(Functions); functionID++ {
+			buffer.Reset()
+			nn.Layers[len(nn.Layers)-1].FunctionID = functionID
+			if err := nn.ExportONNX(&buffer); err != nil {
+				t.Fatalf("Failed to export NN with %s output: %s", FunctionNames[functionID], err.Error())
+			}
+
+			graph := testProtoFields(t, testProtoFields(t, buffer.Bytes())[7][0])
+			nodes := graph[1]
+			if output := string(testProtoFields(t, nodes[len(nodes)-1])[2][0]); output != "output" {
+				t.Errorf("Expected the last node of NN with %s output to produce output, got %s", FunctionNames[functionID], output)
+			}
+		}
+	})
+}
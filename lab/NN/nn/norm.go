@@ -0,0 +1,161 @@
+package nn
+
+import "math"
+
+/* NormEpsilon is added to variances of normalization layers, so constant inputs are not divided by zero. */
+const NormEpsilon = 1e-5
+
+/* DefaultNormMomentum is used by BatchNorm layers with zero Momentum. */
+const DefaultNormMomentum = 0.1
+
+/* moments returns mean and variance used to normalize input j of row r. BatchNorm stores them in rows of stats, a value per column, LayerNorm stores them in columns, a row per sample. */
+func (l *Layer) moments(stats *Matrix, r, j int) (float32, float32) {
+	if l.Kind == LayerLayerNorm {
+		return stats.Row(r)[0], stats.Row(r)[1]
+	}
+	return stats.Row(0)[j], stats.Row(1)[j]
+}
+
+/* normalized returns input j of row r normalized with its mean and variance, as well as reciprocal of standard deviation. */
+func (l *Layer) normalized(stats, inputs *Matrix, r, j int) (float32, float32) {
+	mean, variance := l.moments(stats, r, j)
+	rstd := float32(1 / math.Sqrt(float64(variance)+NormEpsilon))
+	return (inputs.Row(r)[j] - mean) * rstd, rstd
+}
+
+/* queryNorm stores normalized inputs multiplied by scales and shifted by biases into sums. BatchNorm normalizes every column with statistics of batch in training mode and with running ones otherwise, LayerNorm normalizes every row. Means and variances are stored into stats. */
+func (l *Layer) queryNorm(sums, stats, inputs *Matrix, training bool) {
+	n := l.Neurons
+
+	switch {
+	case l.Kind == LayerLayerNorm:
+		stats.Resize(inputs.Rows, 2)
+		for r := 0; r < inputs.Rows; r++ {
+			var sum, squares float64
+
+			for _, x := range inputs.Row(r) {
+				sum += float64(x)
+				squares += float64(x) * float64(x)
+			}
+			mean := sum / float64(n)
+			stats.Row(r)[0] = float32(mean)
+			stats.Row(r)[1] = float32(max(squares/float64(n)-mean*mean, 0))
+		}
+	case training:
+		stats.Resize(2, n)
+		for j := 0; j < n; j++ {
+			var sum, squares float64
+
+			for r := 0; r < inputs.Rows; r++ {
+				x := float64(inputs.Row(r)[j])
+				sum += x
+				squares += x * x
+			}
+			mean := sum / float64(inputs.Rows)
+			stats.Row(0)[j] = float32(mean)
+			stats.Row(1)[j] = float32(max(squares/float64(inputs.Rows)-mean*mean, 0))
+		}
+	default:
+		stats.Resize(2, n)
+		copy(stats.Row(0), l.Mean)
+		copy(stats.Row(1), l.Variance)
+	}
+
+	sums.Resize(inputs.Rows, n)
+	for r := 0; r < inputs.Rows; r++ {
+		for j := 0; j < n; j++ {
+			xhat, _ := l.normalized(stats, inputs, r, j)
+			sums.Row(r)[j] = l.Weights.Data[j]*xhat + l.Biases[j]
+		}
+	}
+}
+
+/* backwardNorm adds gradients of scales and biases of normalization layer to weights and biases. If prevDeltas is not nil, derivatives with respect to inputs are stored into it. */
+func (l *Layer) backwardNorm(stats, deltas, inputs, prevDeltas, weights *Matrix, biases []float32, training bool) {
+	n := l.Neurons
+
+	for r := 0; r < inputs.Rows; r++ {
+		for j := 0; j < n; j++ {
+			xhat, _ := l.normalized(stats, inputs, r, j)
+			weights.Data[j] += deltas.Row(r)[j] * xhat
+			biases[j] += deltas.Row(r)[j]
+		}
+	}
+	if prevDeltas == nil {
+		return
+	}
+
+	/* NOTE(anton2920): with derivatives dxhat with respect to normalized inputs of a group of m, which share mean and variance, dx = rstd * (dxhat - mean(dxhat) - xhat * mean(dxhat * xhat)). */
+	prevDeltas.Resize(inputs.Rows, n)
+	switch {
+	case l.Kind == LayerLayerNorm:
+		for r := 0; r < inputs.Rows; r++ {
+			var sum, dot float32
+
+			for j := 0; j < n; j++ {
+				xhat, _ := l.normalized(stats, inputs, r, j)
+				dxhat := deltas.Row(r)[j] * l.Weights.Data[j]
+				sum += dxhat
+				dot += dxhat * xhat
+			}
+			for j := 0; j < n; j++ {
+				xhat, rstd := l.normalized(stats, inputs, r, j)
+				dxhat := deltas.Row(r)[j] * l.Weights.Data[j]
+				prevDeltas.Row(r)[j] = rstd * (dxhat - (sum+xhat*dot)/float32(n))
+			}
+		}
+	case training:
+		for j := 0; j < n; j++ {
+			var sum, dot float32
+
+			for r := 0; r < inputs.Rows; r++ {
+				xhat, _ := l.normalized(stats, inputs, r, j)
+				dxhat := deltas.Row(r)[j] * l.Weights.Data[j]
+				sum += dxhat
+				dot += dxhat * xhat
+			}
+			for r := 0; r < inputs.Rows; r++ {
+				xhat, rstd := l.normalized(stats, inputs, r, j)
+				dxhat := deltas.Row(r)[j] * l.Weights.Data[j]
+				prevDeltas.Row(r)[j] = rstd * (dxhat - (sum+xhat*dot)/float32(inputs.Rows))
+			}
+		}
+	default:
+		for r := 0; r < inputs.Rows; r++ {
+			for j := 0; j < n; j++ {
+				_, rstd := l.normalized(stats, inputs, r, j)
+				prevDeltas.Row(r)[j] = deltas.Row(r)[j] * l.Weights.Data[j] * rstd
+			}
+		}
+	}
+}
+
+/* updateStatistics moves running means and variances of BatchNorm layers towards statistics of batch workers computed during the last run. Every worker normalizes its shard with statistics of that shard, they are averaged here. */
+func (nn *NN) updateStatistics(p *pool) {
+	if p.nshards == 0 {
+		return
+	}
+
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+		if layer.Kind != LayerBatchNorm {
+			continue
+		}
+
+		momentum := layer.Momentum
+		if momentum == 0 {
+			momentum = DefaultNormMomentum
+		}
+		for j := 0; j < layer.Neurons; j++ {
+			var mean, variance float32
+
+			for w := 0; w < p.nshards; w++ {
+				stats := &p.workers[w].ws.stats[l]
+				mean += stats.Row(0)[j]
+				variance += stats.Row(1)[j]
+			}
+			layer.Mean[j] += momentum * (mean/float32(p.nshards) - layer.Mean[j])
+			layer.Variance[j] += momentum * (variance/float32(p.nshards) - layer.Variance[j])
+		}
+	}
+}
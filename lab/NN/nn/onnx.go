@@ -0,0 +1,288 @@
+package nn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+/* NOTE(anton2920): protobuf is written by hand, field numbers come from onnx.proto. */
+const (
+	onnxIRVersion = 8
+	onnxOpset     = 13
+
+	onnxFloat = 1 /* TensorProto.DataType.FLOAT */
+
+	onnxAttributeFloat = 1
+	onnxAttributeInt   = 2
+	onnxAttributeInts  = 7
+)
+
+/* protoBuffer accumulates protobuf-encoded message. */
+type protoBuffer []byte
+
+func (b *protoBuffer) tag(field, wireType int) {
+	*b = binary.AppendUvarint(*b, uint64(field<<3|wireType))
+}
+
+func (b *protoBuffer) varint(field int, v int64) {
+	b.tag(field, 0)
+	*b = binary.AppendUvarint(*b, uint64(v))
+}
+
+func (b *protoBuffer) float(field int, v float32) {
+	b.tag(field, 5)
+	*b = binary.LittleEndian.AppendUint32(*b, math.Float32bits(v))
+}
+
+func (b *protoBuffer) bytes(field int, v []byte) {
+	b.tag(field, 2)
+	*b = binary.AppendUvarint(*b, uint64(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *protoBuffer) string(field int, v string) {
+	b.bytes(field, []byte(v))
+}
+
+func (b *protoBuffer) message(field int, fn func(m *protoBuffer)) {
+	var m protoBuffer
+	fn(&m)
+	b.bytes(field, m)
+}
+
+/* onnxTensor writes TensorProto with float data. */
+func onnxTensor(m *protoBuffer, name string, dims []int, data []float32) {
+	raw := make([]byte, 0, 4*len(data))
+	for _, x := range data {
+		raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(x))
+	}
+
+	for _, dim := range dims {
+		m.varint(1, int64(dim))
+	}
+	m.varint(2, onnxFloat)
+	m.string(8, name)
+	m.bytes(9, raw)
+}
+
+/* onnxValueInfo writes ValueInfoProto of float tensor with dynamic batch dimension. */
+func onnxValueInfo(m *protoBuffer, name string, size int) {
+	m.string(1, name)
+	m.message(2, func(m *protoBuffer) { /* TypeProto. */
+		m.message(1, func(m *protoBuffer) { /* TypeProto.Tensor. */
+			m.varint(1, onnxFloat)
+			m.message(2, func(m *protoBuffer) { /* TensorShapeProto. */
+				m.message(1, func(m *protoBuffer) { m.string(2, "N") })
+				m.message(1, func(m *protoBuffer) { m.varint(1, int64(size)) })
+			})
+		})
+	})
+}
+
+/* onnxNode writes NodeProto with an optional attribute, which is either int64, float32 or list of int64. */
+func onnxNode(m *protoBuffer, opType string, inputs []string, output string, attribute string, value interface{}) {
+	for _, input := range inputs {
+		m.string(1, input)
+	}
+	m.string(2, output)
+	m.string(3, output)
+	m.string(4, opType)
+	if attribute != "" {
+		m.message(5, func(m *protoBuffer) {
+			m.string(1, attribute)
+			switch v := value.(type) {
+			case int:
+				m.varint(3, int64(v))
+				m.varint(20, onnxAttributeInt)
+			case float32:
+				m.float(2, v)
+				m.varint(20, onnxAttributeFloat)
+			case []int:
+				for _, x := range v {
+					m.varint(8, int64(x))
+				}
+				m.varint(20, onnxAttributeInts)
+			}
+		})
+	}
+}
+
+/* onnxActivation writes nodes applying activation function to input. Functions missing in opset are composed of several nodes, with constants stored as initializers. */
+func onnxActivation(graph *protoBuffer, functionID int, prefix string, input string, output string) {
+	node := func(opType string, inputs []string, output string, attribute string, value interface{}) {
+		graph.message(1, func(m *protoBuffer) { onnxNode(m, opType, inputs, output, attribute, value) })
+	}
+	constant := func(name string, value float32) string {
+		graph.message(5, func(m *protoBuffer) { onnxTensor(m, prefix+name, nil, []float32{value}) })
+		return prefix + name
+	}
+
+	switch functionID {
+	case FunctionSigmoid:
+		node("Sigmoid", []string{input}, output, "", nil)
+	case FunctionTh:
+		node("Tanh", []string{input}, output, "", nil)
+	case FunctionReLU:
+		/* NOTE(anton2920): ReLU is leaky, see activation.go. */
+		node("LeakyRelu", []string{input}, output, "alpha", float32(0.01))
+	case FunctionIdentity:
+		node("Identity", []string{input}, output, "", nil)
+	case FunctionSoftmax:
+		node("Softmax", []string{input}, output, "axis", 1)
+	case FunctionLeakyReLU:
+		node("LeakyRelu", []string{input}, output, "alpha", Functions[functionID].(LeakyReLU).Alpha)
+	case FunctionELU:
+		node("Elu", []string{input}, output, "alpha", Functions[functionID].(ELU).Alpha)
+	case FunctionGELU:
+		/* NOTE(anton2920): Gelu appears only in opset 20, so 0.5*x*(1+erf(x/sqrt(2))) is used. */
+		node("Div", []string{input, constant("sqrt2", math.Sqrt2)}, prefix+"scaled", "", nil)
+		node("Erf", []string{prefix + "scaled"}, prefix+"erf", "", nil)
+		node("Add", []string{prefix + "erf", constant("one", 1)}, prefix+"cdf2", "", nil)
+		node("Mul", []string{input, prefix + "cdf2"}, prefix+"gelu2", "", nil)
+		node("Mul", []string{prefix + "gelu2", constant("half", 0.5)}, output, "", nil)
+	case FunctionSoftplus:
+		node("Softplus", []string{input}, output, "", nil)
+	case FunctionSwish:
+		node("Sigmoid", []string{input}, prefix+"sigmoid", "", nil)
+		node("Mul", []string{input, prefix + "sigmoid"}, output, "", nil)
+	}
+}
+
+func formatVector(xs []float32) string {
+	var buf strings.Builder
+
+	for i, x := range xs {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.FormatFloat(float64(x), 'g', -1, 32))
+	}
+
+	return buf.String()
+}
+
+/* onnxScaler writes scaler into model metadata as its kind and comma-separated columns, offsets and scales. */
+func onnxScaler(model *protoBuffer, name string, s *Scaler) {
+	if s == nil {
+		return
+	}
+
+	columns := make([]string, len(s.Columns))
+	for k, c := range s.Columns {
+		columns[k] = strconv.Itoa(c)
+	}
+
+	for _, prop := range [...][2]string{
+		{name, ScalerNames[s.Kind]},
+		{name + "_columns", strings.Join(columns, ",")},
+		{name + "_offsets", formatVector(s.Offsets)},
+		{name + "_scales", formatVector(s.Scales)},
+	} {
+		model.message(14, func(m *protoBuffer) {
+			m.string(1, prop[0])
+			m.string(2, prop[1])
+		})
+	}
+}
+
+/* onnxLayerNorm writes nodes normalizing every row of input, since LayerNormalization appears only in opset 17. */
+func onnxLayerNorm(graph *protoBuffer, prefix string, input string, output string) {
+	node := func(opType string, inputs []string, output string, attribute string, value interface{}) {
+		graph.message(1, func(m *protoBuffer) { onnxNode(m, opType, inputs, output, attribute, value) })
+	}
+
+	node("ReduceMean", []string{input}, prefix+"mean", "axes", []int{1})
+	node("Sub", []string{input, prefix + "mean"}, prefix+"centered", "", nil)
+	node("Mul", []string{prefix + "centered", prefix + "centered"}, prefix+"squares", "", nil)
+	node("ReduceMean", []string{prefix + "squares"}, prefix+"variance", "axes", []int{1})
+	graph.message(5, func(m *protoBuffer) { onnxTensor(m, prefix+"epsilon", nil, []float32{NormEpsilon}) })
+	node("Add", []string{prefix + "variance", prefix + "epsilon"}, prefix+"shifted", "", nil)
+	node("Sqrt", []string{prefix + "shifted"}, prefix+"std", "", nil)
+	node("Div", []string{prefix + "centered", prefix + "std"}, prefix+"normalized", "", nil)
+	node("Mul", []string{prefix + "normalized", prefix + "weights"}, prefix+"scaled", "", nil)
+	node("Add", []string{prefix + "scaled", prefix + "biases"}, output, "", nil)
+}
+
+/* ExportONNX writes NN of dense and normalization layers as ONNX model with Gemm, BatchNormalization or nodes normalizing rows, followed by activation node for every layer. BatchNorm uses running statistics. Input is "input", output is "output". Scalers are stored in model metadata, so they have to be applied outside of the model. */
+func (nn *NN) ExportONNX(w io.Writer) error {
+	var model protoBuffer
+
+	if len(nn.Layers) == 0 {
+		return fmt.Errorf("NN has no layers")
+	}
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+		if (layer.Kind != LayerDense) && (!layer.Normalization()) {
+			return fmt.Errorf("layer #%d: only dense and normalization layers can be exported to ONNX", l)
+		}
+		if (layer.FunctionID < 0) || (layer.FunctionID >= len(FunctionNames)) {
+			return fmt.Errorf("layer #%d: unknown activation function %d", l, layer.FunctionID)
+		}
+		if (layer.Weights.Rows != layer.Neurons) || (len(layer.Biases) != layer.Neurons) || ((layer.Kind == LayerBatchNorm) && ((len(layer.Mean) != layer.Neurons) || (len(layer.Variance) != layer.Neurons))) {
+			return fmt.Errorf("layer #%d is not initialized", l)
+		}
+	}
+	if err := nn.validateScalers(); err != nil {
+		return err
+	}
+
+	model.varint(1, onnxIRVersion)
+	model.string(2, "github.com/anton2920/go/lab/NN/nn")
+	model.message(8, func(m *protoBuffer) {
+		m.string(1, "")
+		m.varint(2, onnxOpset)
+	})
+	model.message(7, func(graph *protoBuffer) {
+		input := "input"
+		for l := 0; l < len(nn.Layers); l++ {
+			layer := &nn.Layers[l]
+			prefix := fmt.Sprintf("layer%d_", l)
+
+			output := prefix + "activation"
+			if l == len(nn.Layers)-1 {
+				output = "output"
+			}
+
+			switch layer.Kind {
+			case LayerDense:
+				/* NOTE(anton2920): weights have a row per neuron, so Gemm computes input * W^T + b. */
+				graph.message(1, func(m *protoBuffer) {
+					onnxNode(m, "Gemm", []string{input, prefix + "weights", prefix + "biases"}, prefix+"gemm", "transB", 1)
+				})
+				onnxActivation(graph, layer.FunctionID, prefix, prefix+"gemm", output)
+				graph.message(5, func(m *protoBuffer) {
+					onnxTensor(m, prefix+"weights", []int{layer.Weights.Rows, layer.Weights.Cols}, layer.Weights.Data)
+				})
+			case LayerBatchNorm:
+				graph.message(1, func(m *protoBuffer) {
+					onnxNode(m, "BatchNormalization", []string{input, prefix + "weights", prefix + "biases", prefix + "mean", prefix + "variance"}, prefix+"norm", "epsilon", float32(NormEpsilon))
+				})
+				onnxActivation(graph, layer.FunctionID, prefix, prefix+"norm", output)
+				graph.message(5, func(m *protoBuffer) { onnxTensor(m, prefix+"weights", []int{layer.Neurons}, layer.Weights.Data) })
+				graph.message(5, func(m *protoBuffer) { onnxTensor(m, prefix+"mean", []int{layer.Neurons}, layer.Mean) })
+				graph.message(5, func(m *protoBuffer) { onnxTensor(m, prefix+"variance", []int{layer.Neurons}, layer.Variance) })
+			case LayerLayerNorm:
+				onnxLayerNorm(graph, prefix, input, prefix+"norm")
+				onnxActivation(graph, layer.FunctionID, prefix, prefix+"norm", output)
+				graph.message(5, func(m *protoBuffer) { onnxTensor(m, prefix+"weights", []int{layer.Neurons}, layer.Weights.Data) })
+			}
+			graph.message(5, func(m *protoBuffer) {
+				onnxTensor(m, prefix+"biases", []int{len(layer.Biases)}, layer.Biases)
+			})
+
+			input = output
+		}
+		graph.string(2, "nn")
+		graph.message(11, func(m *protoBuffer) { onnxValueInfo(m, "input", nn.NumInputs()) })
+		graph.message(12, func(m *protoBuffer) { onnxValueInfo(m, "output", nn.Layers[len(nn.Layers)-1].Neurons) })
+	})
+	onnxScaler(&model, "input_scaler", nn.InputScaler)
+	onnxScaler(&model, "output_scaler", nn.OutputScaler)
+
+	_, err := w.Write(model)
+	return err
+}
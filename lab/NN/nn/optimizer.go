@@ -0,0 +1,200 @@
+package nn
+
+import "math"
+
+/* Optimizer moves NN weights and biases against accumulated gradients. It owns per-parameter state, if any. */
+type Optimizer interface {
+	Update(nn *NN, g *Gradients)
+	Reset()
+
+	/* SetRate changes learning rate, keeping the rest of state. It is used by schedules. */
+	SetRate(rate float32)
+}
+
+type SGD struct {
+	Rate float32
+}
+
+type Momentum struct {
+	Rate     float32
+	Momentum float32
+
+	velocity []float32
+}
+
+type Nesterov struct {
+	Rate     float32
+	Momentum float32
+
+	velocity []float32
+}
+
+type RMSProp struct {
+	Rate    float32
+	Decay   float32
+	Epsilon float32
+
+	meanSquares []float32
+}
+
+type Adam struct {
+	Rate    float32
+	Beta1   float32
+	Beta2   float32
+	Epsilon float32
+
+	step    int
+	moment1 []float32
+	moment2 []float32
+}
+
+/* NumParameters returns total number of weights and biases in NN. */
+func (nn *NN) NumParameters() int {
+	var count int
+
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+		count += len(layer.Weights.Data) + len(layer.Biases)
+	}
+
+	return count
+}
+
+/* eachParameter calls fn for every weight and bias of NN with its sequential index and gradient averaged over g.Count samples. */
+func (nn *NN) eachParameter(g *Gradients, fn func(i int, param *float32, grad float32)) {
+	var i int
+
+	count := float32(max(g.Count, 1))
+	for l := 0; l < len(nn.Layers); l++ {
+		layer := &nn.Layers[l]
+
+		weights := g.Weights[l].Data
+		for w := 0; w < len(layer.Weights.Data); w++ {
+			fn(i, &layer.Weights.Data[w], weights[w]/count)
+			i++
+		}
+		for n := 0; n < len(layer.Biases); n++ {
+			fn(i, &layer.Biases[n], g.Biases[l][n]/count)
+			i++
+		}
+	}
+}
+
+func resize(state []float32, n int) []float32 {
+	if len(state) != n {
+		return make([]float32, n)
+	}
+	return state
+}
+
+func NewSGD(rate float32) *SGD {
+	return &SGD{Rate: rate}
+}
+
+func (o *SGD) Update(nn *NN, g *Gradients) {
+	nn.eachParameter(g, func(i int, param *float32, grad float32) {
+		*param -= o.Rate * grad
+	})
+}
+
+func (o *SGD) Reset() {
+}
+
+func (o *SGD) SetRate(rate float32) {
+	o.Rate = rate
+}
+
+func NewMomentum(rate, momentum float32) *Momentum {
+	return &Momentum{Rate: rate, Momentum: momentum}
+}
+
+func (o *Momentum) Update(nn *NN, g *Gradients) {
+	o.velocity = resize(o.velocity, nn.NumParameters())
+	nn.eachParameter(g, func(i int, param *float32, grad float32) {
+		o.velocity[i] = o.Momentum*o.velocity[i] - o.Rate*grad
+		*param += o.velocity[i]
+	})
+}
+
+func (o *Momentum) Reset() {
+	o.velocity = nil
+}
+
+func (o *Momentum) SetRate(rate float32) {
+	o.Rate = rate
+}
+
+func NewNesterov(rate, momentum float32) *Nesterov {
+	return &Nesterov{Rate: rate, Momentum: momentum}
+}
+
+/* Update uses reformulation of Nesterov accelerated gradient from Bengio et al., which does not require gradient at look-ahead point. */
+func (o *Nesterov) Update(nn *NN, g *Gradients) {
+	o.velocity = resize(o.velocity, nn.NumParameters())
+	nn.eachParameter(g, func(i int, param *float32, grad float32) {
+		prevVelocity := o.velocity[i]
+		o.velocity[i] = o.Momentum*o.velocity[i] - o.Rate*grad
+		*param += -o.Momentum*prevVelocity + (1+o.Momentum)*o.velocity[i]
+	})
+}
+
+func (o *Nesterov) Reset() {
+	o.velocity = nil
+}
+
+func (o *Nesterov) SetRate(rate float32) {
+	o.Rate = rate
+}
+
+func NewRMSProp(rate float32) *RMSProp {
+	return &RMSProp{Rate: rate, Decay: 0.9, Epsilon: 1e-7}
+}
+
+func (o *RMSProp) Update(nn *NN, g *Gradients) {
+	o.meanSquares = resize(o.meanSquares, nn.NumParameters())
+	nn.eachParameter(g, func(i int, param *float32, grad float32) {
+		o.meanSquares[i] = o.Decay*o.meanSquares[i] + (1-o.Decay)*grad*grad
+		*param -= o.Rate * grad / (float32(math.Sqrt(float64(o.meanSquares[i]))) + o.Epsilon)
+	})
+}
+
+func (o *RMSProp) Reset() {
+	o.meanSquares = nil
+}
+
+func (o *RMSProp) SetRate(rate float32) {
+	o.Rate = rate
+}
+
+func NewAdam(rate float32) *Adam {
+	return &Adam{Rate: rate, Beta1: 0.9, Beta2: 0.999, Epsilon: 1e-7}
+}
+
+func (o *Adam) Update(nn *NN, g *Gradients) {
+	o.moment1 = resize(o.moment1, nn.NumParameters())
+	o.moment2 = resize(o.moment2, nn.NumParameters())
+	o.step++
+
+	/* NOTE(anton2920): bias correction for moments initialized with zeroes. */
+	correction1 := 1 - float32(math.Pow(float64(o.Beta1), float64(o.step)))
+	correction2 := 1 - float32(math.Pow(float64(o.Beta2), float64(o.step)))
+
+	nn.eachParameter(g, func(i int, param *float32, grad float32) {
+		o.moment1[i] = o.Beta1*o.moment1[i] + (1-o.Beta1)*grad
+		o.moment2[i] = o.Beta2*o.moment2[i] + (1-o.Beta2)*grad*grad
+
+		m := o.moment1[i] / correction1
+		v := o.moment2[i] / correction2
+		*param -= o.Rate * m / (float32(math.Sqrt(float64(v))) + o.Epsilon)
+	})
+}
+
+func (o *Adam) Reset() {
+	o.step = 0
+	o.moment1 = nil
+	o.moment2 = nil
+}
+
+func (o *Adam) SetRate(rate float32) {
+	o.Rate = rate
+}
@@ -0,0 +1,84 @@
+package nn
+
+import (
+	"math/rand"
+	"sync"
+)
+
+/* worker computes gradients for its shard of a batch. NN weights are read-only while workers run. */
+type worker struct {
+	ws       workspace
+	batch    Matrix
+	expected [][]float32
+	g        *Gradients
+
+	/* rng is used for dropout. Every worker has its own, so masks do not depend on scheduling. */
+	rng *rand.Rand
+
+	/* loss is a sum of losses over the shard, if worker function computes it. */
+	loss float32
+}
+
+/* pool shards mini-batches across workers. Gradients are summed in worker order, so results are bit-identical for a given number of workers. */
+type pool struct {
+	workers []worker
+	wg      sync.WaitGroup
+
+	/* nshards is the number of workers which got a shard during the last run. */
+	nshards int
+}
+
+func (nn *NN) newPool(nworkers, batchSize int) *pool {
+	p := pool{workers: make([]worker, max(nworkers, 1))}
+	for w := 0; w < len(p.workers); w++ {
+		p.workers[w].g = nn.NewGradients()
+		p.workers[w].expected = make([][]float32, batchSize)
+		p.workers[w].rng = rand.New(rand.NewSource(Seed + int64(w)))
+	}
+	return &p
+}
+
+/* run splits indices into contiguous shards, one per worker, calls fn for every shard concurrently and returns sum of gradients of all workers. Empty indices give zero gradients. */
+func (p *pool) run(indices []int, fn func(w *worker, indices []int)) *Gradients {
+	if len(indices) == 0 {
+		p.nshards = 0
+		p.workers[0].g.Reset()
+		return p.workers[0].g
+	}
+
+	shard := (len(indices) + len(p.workers) - 1) / len(p.workers)
+	nshards := (len(indices) + shard - 1) / shard
+	p.nshards = nshards
+
+	for s := 1; s < nshards; s++ {
+		p.wg.Add(1)
+		go func(w *worker, indices []int) {
+			defer p.wg.Done()
+			w.g.Reset()
+			fn(w, indices)
+		}(&p.workers[s], indices[s*shard:min((s+1)*shard, len(indices))])
+	}
+
+	/* NOTE(anton2920): first shard is processed by calling goroutine, so single worker does not spawn any. */
+	p.workers[0].g.Reset()
+	fn(&p.workers[0], indices[:shard])
+	p.wg.Wait()
+
+	g := p.workers[0].g
+	for s := 1; s < nshards; s++ {
+		g.Add(p.workers[s].g)
+	}
+	return g
+}
+
+/* loss returns sum of losses computed by workers during the last run. */
+func (p *pool) loss() float32 {
+	var loss float32
+
+	for w := 0; w < len(p.workers); w++ {
+		loss += p.workers[w].loss
+		p.workers[w].loss = 0
+	}
+
+	return loss
+}
@@ -0,0 +1,226 @@
+package nn
+
+/* stateWidth returns number of values stored for every step of recurrent layer: weighted sums of gates, cell state for LSTM and hidden state. */
+func (l *Layer) stateWidth() int {
+	width := (l.gates() + 1) * l.Neurons
+	if l.Kind == LayerLSTM {
+		width += l.Neurons
+	}
+	return width
+}
+
+/* stepStates returns weighted sums of gates, cell state and hidden state of step t from row of states. Cell state is nil unless layer is LSTM. */
+func (l *Layer) stepStates(states []float32, t int) (sums, cell, hidden []float32) {
+	width := l.stateWidth()
+	state := states[t*width : (t+1)*width]
+
+	sums = state[:l.gates()*l.Neurons]
+	if l.Kind == LayerLSTM {
+		cell = state[len(sums) : len(sums)+l.Neurons]
+	}
+	hidden = state[width-l.Neurons:]
+
+	return sums, cell, hidden
+}
+
+/* weigh stores weighted sums of step inputs and previous hidden state for rows of weights starting with first into sums. Hidden state is nil for the first step. */
+func (l *Layer) weigh(sums []float32, first int, inputs, hidden []float32) {
+	ninputs := l.Weights.Cols - l.Neurons
+	for j := 0; j < len(sums); j++ {
+		weights := l.Weights.Row(first + j)
+		sums[j] = dot(inputs, weights[:ninputs]) + l.Biases[first+j]
+		if hidden != nil {
+			sums[j] += dot(hidden, weights[ninputs:])
+		}
+	}
+}
+
+/* queryRecurrent runs layer over steps of every row of inputs, storing weighted sums and states of every step into states. */
+func (l *Layer) queryRecurrent(states, outputs, inputs *Matrix) {
+	h := l.Neurons
+	ninputs := l.Weights.Cols - h
+	f := Functions[l.FunctionID]
+
+	states.Resize(inputs.Rows, l.Steps*l.stateWidth())
+	outputs.Resize(inputs.Rows, l.NumOutputs())
+	for r := 0; r < inputs.Rows; r++ {
+		var prevCell, prevHidden []float32
+
+		for t := 0; t < l.Steps; t++ {
+			x := inputs.Row(r)[t*ninputs : (t+1)*ninputs]
+			sums, cell, hidden := l.stepStates(states.Row(r), t)
+
+			switch l.Kind {
+			case LayerRNN:
+				l.weigh(sums, 0, x, prevHidden)
+				for n := 0; n < h; n++ {
+					hidden[n] = f.Activate(sums[n])
+				}
+			case LayerLSTM:
+				/* NOTE(anton2920): rows of weights are grouped by gate: input, forget, candidate, output. */
+				l.weigh(sums, 0, x, prevHidden)
+				for n := 0; n < h; n++ {
+					cell[n] = sigmoid(sums[n]) * f.Activate(sums[2*h+n])
+					if prevCell != nil {
+						cell[n] += sigmoid(sums[h+n]) * prevCell[n]
+					}
+					hidden[n] = sigmoid(sums[3*h+n]) * f.Activate(cell[n])
+				}
+			case LayerGRU:
+				/* NOTE(anton2920): rows of weights are grouped by gate: update, reset, candidate. Candidate sees previous hidden state multiplied by reset gate, hidden is used to hold the product. */
+				l.weigh(sums[:2*h], 0, x, prevHidden)
+				if prevHidden != nil {
+					for n := 0; n < h; n++ {
+						hidden[n] = sigmoid(sums[h+n]) * prevHidden[n]
+					}
+					l.weigh(sums[2*h:], 2*h, x, hidden)
+				} else {
+					l.weigh(sums[2*h:], 2*h, x, nil)
+				}
+				for n := 0; n < h; n++ {
+					update := sigmoid(sums[n])
+					hidden[n] = (1 - update) * f.Activate(sums[2*h+n])
+					if prevHidden != nil {
+						hidden[n] += update * prevHidden[n]
+					}
+				}
+			}
+
+			if l.Sequence {
+				copy(outputs.Row(r)[t*h:], hidden)
+			}
+			prevCell, prevHidden = cell, hidden
+		}
+		if !l.Sequence {
+			copy(outputs.Row(r), prevHidden)
+		}
+	}
+}
+
+/* backwardRecurrent propagates derivatives of loss with respect to outputs of layer back through its steps, adding gradients of weights and biases to weights and biases. If prevDeltas is not nil, derivatives with respect to inputs are stored into it. */
+func (l *Layer) backwardRecurrent(ws *workspace, states, deltas, inputs, prevDeltas, weights *Matrix, biases []float32) {
+	h := l.Neurons
+	ninputs := l.Weights.Cols - h
+	f := Functions[l.FunctionID]
+
+	/* NOTE(anton2920): dh and dc are derivatives with respect to hidden and cell states of the current step, dhPrev collects ones of the previous step. */
+	ws.scratch = resize(ws.scratch, (5+l.gates())*h)
+	dh := ws.scratch[:h]
+	dhPrev := ws.scratch[h : 2*h]
+	dc := ws.scratch[2*h : 3*h]
+	gated := ws.scratch[3*h : 4*h]
+	resetHidden := ws.scratch[4*h : 5*h]
+	dz := ws.scratch[5*h:]
+
+	if prevDeltas != nil {
+		prevDeltas.Resize(inputs.Rows, inputs.Cols)
+		prevDeltas.Zero()
+	}
+	for r := 0; r < inputs.Rows; r++ {
+		out := deltas.Row(r)
+		for n := 0; n < h; n++ {
+			dh[n] = 0
+			dc[n] = 0
+		}
+		if !l.Sequence {
+			copy(dh, out)
+		}
+
+		for t := l.Steps - 1; t >= 0; t-- {
+			var prevCell, prevHidden []float32
+
+			x := inputs.Row(r)[t*ninputs : (t+1)*ninputs]
+			sums, cell, _ := l.stepStates(states.Row(r), t)
+			if t > 0 {
+				_, prevCell, prevHidden = l.stepStates(states.Row(r), t-1)
+			}
+			if l.Sequence {
+				axpy(1, out[t*h:(t+1)*h], dh)
+			}
+			for n := 0; n < h; n++ {
+				dhPrev[n] = 0
+			}
+
+			switch l.Kind {
+			case LayerRNN:
+				for n := 0; n < h; n++ {
+					dz[n] = dh[n] * f.Derivative(sums[n])
+				}
+			case LayerLSTM:
+				for n := 0; n < h; n++ {
+					input, forget, output := sigmoid(sums[n]), sigmoid(sums[h+n]), sigmoid(sums[3*h+n])
+
+					dc[n] += dh[n] * output * f.Derivative(cell[n])
+					dz[n] = dc[n] * f.Activate(sums[2*h+n]) * input * (1 - input)
+					dz[h+n] = 0
+					if prevCell != nil {
+						dz[h+n] = dc[n] * prevCell[n] * forget * (1 - forget)
+					}
+					dz[2*h+n] = dc[n] * input * f.Derivative(sums[2*h+n])
+					dz[3*h+n] = dh[n] * f.Activate(cell[n]) * output * (1 - output)
+					dc[n] *= forget
+				}
+			case LayerGRU:
+				for n := 0; n < h; n++ {
+					var prev float32
+
+					update := sigmoid(sums[n])
+					if prevHidden != nil {
+						prev = prevHidden[n]
+						dhPrev[n] = dh[n] * update
+					}
+					dz[n] = dh[n] * (prev - f.Activate(sums[2*h+n])) * update * (1 - update)
+					dz[h+n] = 0
+					dz[2*h+n] = dh[n] * (1 - update) * f.Derivative(sums[2*h+n])
+				}
+				if prevHidden != nil {
+					for n := 0; n < h; n++ {
+						gated[n] = 0
+						resetHidden[n] = sigmoid(sums[h+n]) * prevHidden[n]
+					}
+					for n := 0; n < h; n++ {
+						axpy(dz[2*h+n], l.Weights.Row(2*h + n)[ninputs:], gated)
+					}
+					for n := 0; n < h; n++ {
+						reset := sigmoid(sums[h+n])
+						dz[h+n] = gated[n] * prevHidden[n] * reset * (1 - reset)
+						dhPrev[n] += gated[n] * reset
+					}
+				}
+			}
+
+			for j := 0; j < len(dz); j++ {
+				if dz[j] == 0 {
+					continue
+				}
+
+				row := weights.Row(j)
+				axpy(dz[j], x, row[:ninputs])
+				biases[j] += dz[j]
+				if prevDeltas != nil {
+					axpy(dz[j], l.Weights.Row(j)[:ninputs], prevDeltas.Row(r)[t*ninputs:(t+1)*ninputs])
+				}
+
+				switch {
+				case prevHidden == nil:
+				case (l.Kind == LayerGRU) && (j >= 2*h):
+					/* NOTE(anton2920): derivatives of candidate with respect to previous hidden state are already in dhPrev. */
+					axpy(dz[j], resetHidden, row[ninputs:])
+				default:
+					axpy(dz[j], prevHidden, row[ninputs:])
+					axpy(dz[j], l.Weights.Row(j)[ninputs:], dhPrev)
+				}
+			}
+
+			/* NOTE(anton2920): truncated BPTT splits steps into blocks counting from the last one, derivatives do not flow between blocks. */
+			if (l.Truncate > 0) && ((l.Steps-t)%l.Truncate == 0) {
+				for n := 0; n < h; n++ {
+					dh[n] = 0
+					dc[n] = 0
+				}
+			} else {
+				copy(dh, dhPrev)
+			}
+		}
+	}
+}
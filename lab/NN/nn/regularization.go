@@ -0,0 +1,84 @@
+package nn
+
+import (
+	"math"
+	"math/rand"
+)
+
+/* Regularization penalizes large weights and limits size of updates. Zero value disables everything. */
+type Regularization struct {
+	/* L1 and L2 are coefficients of penalties L1*|w| and L2*w*w/2 added to loss for every weight. Biases and scales of normalization layers are not penalized. */
+	L1 float32
+	L2 float32
+
+	/* MaxGradNorm, if set, scales gradients down, so their global L2 norm does not exceed it. */
+	MaxGradNorm float32
+}
+
+/* regularize adds gradients of weight penalties to g and clips it. Since g is a sum over g.Count samples, penalties are multiplied by g.Count. */
+func (nn *NN) regularize(g *Gradients) {
+	r := &nn.Regularization
+	count := float32(max(g.Count, 1))
+
+	if (r.L1 != 0) || (r.L2 != 0) {
+		for l := 0; l < len(nn.Layers); l++ {
+			if nn.Layers[l].Normalization() {
+				continue
+			}
+			weights := nn.Layers[l].Weights.Data
+			grads := g.Weights[l].Data
+
+			for w := 0; w < len(weights); w++ {
+				var sign float32
+				switch {
+				case weights[w] > 0:
+					sign = 1
+				case weights[w] < 0:
+					sign = -1
+				}
+				grads[w] += count * (r.L1*sign + r.L2*weights[w])
+			}
+		}
+	}
+
+	if r.MaxGradNorm > 0 {
+		var sum float64
+
+		for l := 0; l < len(g.Weights); l++ {
+			for _, grad := range g.Weights[l].Data {
+				sum += float64(grad) * float64(grad)
+			}
+			for _, grad := range g.Biases[l] {
+				sum += float64(grad) * float64(grad)
+			}
+		}
+
+		norm := float32(math.Sqrt(sum)) / count
+		if norm > r.MaxGradNorm {
+			scale := r.MaxGradNorm / norm
+			for l := 0; l < len(g.Weights); l++ {
+				for w := 0; w < len(g.Weights[l].Data); w++ {
+					g.Weights[l].Data[w] *= scale
+				}
+				for n := 0; n < len(g.Biases[l]); n++ {
+					g.Biases[l][n] *= scale
+				}
+			}
+		}
+	}
+}
+
+/* dropout zeroes every output with probability rate and scales the rest by 1/(1-rate), so expected outputs stay the same. Applied scales are stored into mask. */
+func dropout(rng *rand.Rand, rate float32, outputs, mask *Matrix) {
+	mask.Resize(outputs.Rows, outputs.Cols)
+
+	scale := 1 / (1 - rate)
+	for i := 0; i < len(outputs.Data); i++ {
+		if rng.Float32() < rate {
+			mask.Data[i] = 0
+		} else {
+			mask.Data[i] = scale
+		}
+		outputs.Data[i] *= mask.Data[i]
+	}
+}
@@ -0,0 +1,202 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+/* Scaler maps selected columns of rows as (x-offset)/scale, with offset and scale of every column found by Fit. */
+type Scaler struct {
+	Kind int
+
+	/* Columns are indices of columns Scaler is applied to, other columns are left intact. Fit selects all columns if Columns are empty. */
+	Columns []int
+	Offsets []float32
+	Scales  []float32
+}
+
+const (
+	ScalerMinMax01 = iota /* [0; 1]. */
+	ScalerMinMax11        /* [-1; 1]. */
+	ScalerZScore          /* Zero mean and unit standard deviation. */
+	ScalerRobust          /* Zero median and unit interquartile range, so outliers do not squeeze other values. */
+)
+
+/* ScalerNames identify scalers in model files, so they must not change. */
+var ScalerNames = []string{
+	"min-max-01",
+	"min-max-11",
+	"z-score",
+	"robust",
+}
+
+/* ScalerByName returns kind of scaler with the given name. */
+func ScalerByName(name string) (int, bool) {
+	for kind := 0; kind < len(ScalerNames); kind++ {
+		if ScalerNames[kind] == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+func NewScaler(kind int, columns ...int) *Scaler {
+	return &Scaler{Kind: kind, Columns: columns}
+}
+
+/* quantile returns q-th quantile of sorted xs, interpolating between neighbouring values. */
+func quantile(xs []float64, q float64) float64 {
+	pos := q * float64(len(xs)-1)
+	i := int(pos)
+	if i+1 >= len(xs) {
+		return xs[len(xs)-1]
+	}
+	return xs[i] + (pos-float64(i))*(xs[i+1]-xs[i])
+}
+
+/* Fit finds offset and scale of every selected column from rows. Columns with zero range get scale of 1, so they are only shifted. */
+func (s *Scaler) Fit(rows [][]float32) error {
+	if (s.Kind < 0) || (s.Kind >= len(ScalerNames)) {
+		return fmt.Errorf("unknown scaler %d", s.Kind)
+	}
+	if len(rows) == 0 {
+		return fmt.Errorf("no rows to fit scaler to")
+	}
+	if len(s.Columns) == 0 {
+		s.Columns = identityOrder(len(rows[0]))
+	}
+
+	s.Offsets = make([]float32, len(s.Columns))
+	s.Scales = make([]float32, len(s.Columns))
+	xs := make([]float64, len(rows))
+	for k, c := range s.Columns {
+		for i := 0; i < len(rows); i++ {
+			if (c < 0) || (c >= len(rows[i])) {
+				return fmt.Errorf("row #%d has no column %d", i, c)
+			}
+			xs[i] = float64(rows[i][c])
+		}
+
+		var offset, scale float64
+		switch s.Kind {
+		case ScalerMinMax01, ScalerMinMax11:
+			minX, maxX := xs[0], xs[0]
+			for i := 1; i < len(xs); i++ {
+				minX = min(minX, xs[i])
+				maxX = max(maxX, xs[i])
+			}
+			if s.Kind == ScalerMinMax01 {
+				offset, scale = minX, maxX-minX
+			} else {
+				offset, scale = 0.5*(maxX+minX), 0.5*(maxX-minX)
+			}
+		case ScalerZScore:
+			var sum, squares float64
+			for i := 0; i < len(xs); i++ {
+				sum += xs[i]
+				squares += xs[i] * xs[i]
+			}
+			offset = sum / float64(len(xs))
+			scale = math.Sqrt(max(squares/float64(len(xs))-offset*offset, 0))
+		case ScalerRobust:
+			sort.Float64s(xs)
+			offset = quantile(xs, 0.5)
+			scale = quantile(xs, 0.75) - quantile(xs, 0.25)
+		}
+
+		/* NOTE(anton2920): tiny ranges come from rounding errors of constant columns. */
+		if scale <= 1e-12*max(math.Abs(offset), 1) {
+			scale = 1
+		}
+		s.Offsets[k] = float32(offset)
+		s.Scales[k] = float32(scale)
+	}
+
+	return nil
+}
+
+func (s *Scaler) TransformRow(row []float32) {
+	for k, c := range s.Columns {
+		row[c] = (row[c] - s.Offsets[k]) / s.Scales[k]
+	}
+}
+
+func (s *Scaler) InverseTransformRow(row []float32) {
+	for k, c := range s.Columns {
+		row[c] = row[c]*s.Scales[k] + s.Offsets[k]
+	}
+}
+
+/* Transform scales every row in place. */
+func (s *Scaler) Transform(rows [][]float32) {
+	for i := 0; i < len(rows); i++ {
+		s.TransformRow(rows[i])
+	}
+}
+
+/* InverseTransform undoes Transform of every row in place. */
+func (s *Scaler) InverseTransform(rows [][]float32) {
+	for i := 0; i < len(rows); i++ {
+		s.InverseTransformRow(rows[i])
+	}
+}
+
+/* FitTransform fits Scaler to rows and scales them in place. */
+func (s *Scaler) FitTransform(rows [][]float32) error {
+	if err := s.Fit(rows); err != nil {
+		return err
+	}
+	s.Transform(rows)
+	return nil
+}
+
+/* validate checks that Scaler was fitted and can be applied to rows of the given width. */
+func (s *Scaler) validate(width int) error {
+	if (s.Kind < 0) || (s.Kind >= len(ScalerNames)) {
+		return fmt.Errorf("unknown scaler %d", s.Kind)
+	}
+	if (len(s.Offsets) != len(s.Columns)) || (len(s.Scales) != len(s.Columns)) {
+		return fmt.Errorf("scaler has %d columns, but %d offsets and %d scales", len(s.Columns), len(s.Offsets), len(s.Scales))
+	}
+	for k, c := range s.Columns {
+		if (c < 0) || (c >= width) {
+			return fmt.Errorf("scaler column %d is out of range [0; %d)", c, width)
+		}
+		if s.Scales[k] == 0 {
+			return fmt.Errorf("scaler column %d has zero scale", c)
+		}
+	}
+	return nil
+}
+
+/* validateScalers checks that scalers can be applied to inputs and outputs of layers. */
+func validateScalers(layers []Layer, inputScaler, outputScaler *Scaler) error {
+	if len(layers) == 0 {
+		return nil
+	}
+	if inputScaler != nil {
+		if err := inputScaler.validate(layers[0].NumInputs()); err != nil {
+			return fmt.Errorf("input %w", err)
+		}
+	}
+	if outputScaler != nil {
+		if err := outputScaler.validate(layers[len(layers)-1].NumOutputs()); err != nil {
+			return fmt.Errorf("output %w", err)
+		}
+	}
+	return nil
+}
+
+func (nn *NN) validateScalers() error {
+	return validateScalers(nn.Layers, nn.InputScaler, nn.OutputScaler)
+}
+
+/* ColumnRange returns indices of columns from first up to, but not including, last. */
+func ColumnRange(first, last int) []int {
+	columns := make([]int, 0, max(last-first, 0))
+	for c := first; c < last; c++ {
+		columns = append(columns, c)
+	}
+	return columns
+}
@@ -0,0 +1,157 @@
+package nn
+
+import "math"
+
+/* Schedule returns learning rate for the given step, which is an epoch for NN trainers and an iteration for SOM. */
+type Schedule interface {
+	At(step int) float32
+	Reset()
+}
+
+/* LossObserver is implemented by schedules which depend on loss. Trainers call Observe after every epoch. */
+type LossObserver interface {
+	Observe(loss float32)
+}
+
+type ConstantRate struct {
+	Rate float32
+}
+
+/* StepDecay multiplies rate by Factor every StepSize steps. */
+type StepDecay struct {
+	Rate     float32
+	Factor   float32
+	StepSize int
+}
+
+/* ExponentialDecay multiplies rate by exp(-step/TimeConstant). */
+type ExponentialDecay struct {
+	Rate         float32
+	TimeConstant float32
+}
+
+/* CosineAnnealing decreases rate to MinRate along half of cosine period over Steps steps. */
+type CosineAnnealing struct {
+	Rate    float32
+	MinRate float32
+	Steps   int
+}
+
+/* Warmup increases rate linearly during the first Steps steps, then follows Schedule, which starts from step 0. */
+type Warmup struct {
+	Steps    int
+	Schedule Schedule
+}
+
+/* ReduceOnPlateau multiplies rate by Factor when loss has not improved for Patience epochs. Rate never goes below MinRate. */
+type ReduceOnPlateau struct {
+	Rate     float32
+	Factor   float32
+	Patience int
+	MinRate  float32
+
+	rate      float32
+	minLoss   float32
+	countdown int
+}
+
+func NewConstantRate(rate float32) *ConstantRate {
+	return &ConstantRate{Rate: rate}
+}
+
+func (s *ConstantRate) At(step int) float32 {
+	return s.Rate
+}
+
+func (s *ConstantRate) Reset() {
+}
+
+func NewStepDecay(rate, factor float32, stepSize int) *StepDecay {
+	return &StepDecay{Rate: rate, Factor: factor, StepSize: stepSize}
+}
+
+func (s *StepDecay) At(step int) float32 {
+	return s.Rate * float32(math.Pow(float64(s.Factor), float64(step/s.StepSize)))
+}
+
+func (s *StepDecay) Reset() {
+}
+
+func NewExponentialDecay(rate, timeConstant float32) *ExponentialDecay {
+	return &ExponentialDecay{Rate: rate, TimeConstant: timeConstant}
+}
+
+func (s *ExponentialDecay) At(step int) float32 {
+	return s.Rate * float32(math.Exp(-float64(step)/float64(s.TimeConstant)))
+}
+
+func (s *ExponentialDecay) Reset() {
+}
+
+func NewCosineAnnealing(rate, minRate float32, steps int) *CosineAnnealing {
+	return &CosineAnnealing{Rate: rate, MinRate: minRate, Steps: steps}
+}
+
+func (s *CosineAnnealing) At(step int) float32 {
+	progress := float64(min(step, s.Steps)) / float64(s.Steps)
+	return s.MinRate + 0.5*(s.Rate-s.MinRate)*float32(1+math.Cos(math.Pi*progress))
+}
+
+func (s *CosineAnnealing) Reset() {
+}
+
+func NewWarmup(steps int, schedule Schedule) *Warmup {
+	return &Warmup{Steps: steps, Schedule: schedule}
+}
+
+func (s *Warmup) At(step int) float32 {
+	if step < s.Steps {
+		return s.Schedule.At(0) * float32(step+1) / float32(s.Steps)
+	}
+	return s.Schedule.At(step - s.Steps)
+}
+
+func (s *Warmup) Reset() {
+	s.Schedule.Reset()
+}
+
+func (s *Warmup) Observe(loss float32) {
+	observe(s.Schedule, loss)
+}
+
+func NewReduceOnPlateau(rate, factor float32, patience int) *ReduceOnPlateau {
+	s := ReduceOnPlateau{Rate: rate, Factor: factor, Patience: patience}
+	s.Reset()
+	return &s
+}
+
+func (s *ReduceOnPlateau) At(step int) float32 {
+	return s.rate
+}
+
+func (s *ReduceOnPlateau) Reset() {
+	s.rate = s.Rate
+	s.minLoss = float32(math.Inf(1))
+	s.countdown = 0
+}
+
+func (s *ReduceOnPlateau) Observe(loss float32) {
+	if loss < s.minLoss {
+		s.minLoss = loss
+		s.countdown = 0
+		return
+	}
+
+	s.countdown++
+	if s.countdown > s.Patience {
+		s.rate = max(s.rate*s.Factor, s.MinRate)
+		s.countdown = 0
+	}
+}
+
+/* observe passes loss to schedule, if it needs one. */
+func observe(schedule Schedule, loss float32) {
+	if o, ok := schedule.(LossObserver); ok {
+		o.Observe(loss)
+	}
+}
@@ -0,0 +1,379 @@
+package nn
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+)
+
+/* Source yields rows of data one at a time, so they do not have to fit in memory. Returned row is only valid until the next call to Next. */
+type Source interface {
+	/* Next returns next row or io.EOF after the last one. */
+	Next() ([]float32, error)
+
+	/* Reset starts over from the first row. */
+	Reset() error
+}
+
+/* SliceSource yields rows which are already in memory. */
+type SliceSource struct {
+	Rows [][]float32
+
+	next int
+}
+
+/* CSVSource yields rows of CSV file, reading it anew every time it is reset. */
+type CSVSource struct {
+	f      *os.File
+	cr     *csv.Reader
+	parser *csvParser
+
+	numbers []float32
+	values  []string
+	row     []float32
+}
+
+/* ShuffleSource yields rows of another source in random order, keeping at most size of them in memory. */
+type ShuffleSource struct {
+	source Source
+	size   int
+	rng    *rand.Rand
+
+	buffer [][]float32
+	spare  []float32
+	eof    bool
+}
+
+/*
+Cache file format. All numbers are little-endian.
+
+	magic          [4]byte  "NNDC"
+	version        uint16   CacheVersion
+	ncolumns       uint32
+	rows           [ncolumns]float32 each, until end of file
+*/
+
+const CacheVersion = 1
+
+var CacheMagic = [4]byte{'N', 'N', 'D', 'C'}
+
+var ErrBadCacheMagic = errors.New("not a cache file")
+
+/* cacheHeaderSize is size of magic, version and ncolumns. */
+const cacheHeaderSize = 4 + 2 + 4
+
+/* CacheSource yields rows of cache file. */
+type CacheSource struct {
+	f     *os.File
+	r     *bufio.Reader
+	ncols int
+
+	buf []byte
+	row []float32
+}
+
+func NewSliceSource(rows [][]float32) *SliceSource {
+	return &SliceSource{Rows: rows}
+}
+
+func (s *SliceSource) Next() ([]float32, error) {
+	if s.next >= len(s.Rows) {
+		return nil, io.EOF
+	}
+	s.next++
+	return s.Rows[s.next-1], nil
+}
+
+func (s *SliceSource) Reset() error {
+	s.next = 0
+	return nil
+}
+
+/* NewCSVSource opens CSV file for reading rows of selected columns. Since rows are never all in memory, missing values can only be skipped, replaced with zeros or reported, and categorical columns must have fixed categories. */
+func NewCSVSource(filename string, options *CSVOptions) (*CSVSource, error) {
+	var s CSVSource
+	var err error
+
+	if options == nil {
+		options = new(CSVOptions)
+	}
+	if (options.Missing == MissingMean) || (options.Missing == MissingMedian) {
+		return nil, fmt.Errorf("%s: missing values cannot be replaced with %s of column", filename, MissingNames[options.Missing])
+	}
+
+	s.f, err = os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	/* NOTE(anton2920): without header names are indices of fields of the first record, so it is read to count them. */
+	s.cr = newCSVReader(s.f, options)
+	record, err := s.cr.Read()
+	if err == io.EOF {
+		return &s, nil
+	}
+	if err != nil {
+		s.f.Close()
+		return nil, csvReadError(filename, err)
+	}
+
+	s.parser, err = newCSVParser(filename, csvNames(record, options.Header), options)
+	if err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	for k, c := range s.parser.columns {
+		if (s.parser.categorical[k]) && (options.Categories[s.parser.names[c]] == nil) {
+			s.f.Close()
+			return nil, fmt.Errorf("%s: categorical column %q has no fixed categories", filename, s.parser.names[c])
+		}
+	}
+	s.numbers = make([]float32, len(s.parser.columns))
+	s.values = make([]string, len(s.parser.columns))
+
+	if err := s.Reset(); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	return &s, nil
+}
+
+/* Names returns names of columns of rows, like Table.Names. */
+func (s *CSVSource) Names() []string {
+	if s.parser == nil {
+		return nil
+	}
+	return s.parser.table().Names
+}
+
+func (s *CSVSource) Next() ([]float32, error) {
+	if s.parser == nil {
+		return nil, io.EOF
+	}
+
+	for {
+		record, err := s.cr.Read()
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, csvReadError(s.parser.filename, err)
+		}
+
+		ok, err := s.parser.parse(s.cr, record, s.numbers, s.values)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			s.row = s.parser.encode(s.numbers, s.values, s.row)
+			return s.row, nil
+		}
+	}
+}
+
+func (s *CSVSource) Reset() error {
+	if s.parser == nil {
+		return nil
+	}
+
+	if _, err := s.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.cr = newCSVReader(s.f, s.parser.options)
+	if s.parser.options.Header {
+		if _, err := s.cr.Read(); err != nil {
+			return csvReadError(s.parser.filename, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *CSVSource) Close() error {
+	return s.f.Close()
+}
+
+/* NewShuffleSource returns source which picks random row out of the next size rows of source. Shuffle is only complete if size is not less than number of rows. */
+func NewShuffleSource(source Source, size int, seed int64) *ShuffleSource {
+	return &ShuffleSource{source: source, size: max(size, 1), rng: rand.New(rand.NewSource(seed))}
+}
+
+func (s *ShuffleSource) Next() ([]float32, error) {
+	for (!s.eof) && (len(s.buffer) < s.size) {
+		row, err := s.source.Next()
+		if err == io.EOF {
+			s.eof = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		/* NOTE(anton2920): rows of source are only valid until its next call, so they are copied, reusing storage of row returned last time. */
+		s.buffer = append(s.buffer, append(s.spare[:0], row...))
+		s.spare = nil
+	}
+	if len(s.buffer) == 0 {
+		return nil, io.EOF
+	}
+
+	i := s.rng.Intn(len(s.buffer))
+	row := s.buffer[i]
+	s.buffer[i] = s.buffer[len(s.buffer)-1]
+	s.buffer = s.buffer[:len(s.buffer)-1]
+	s.spare = row
+
+	return row, nil
+}
+
+/* Reset starts over from the first row of source. Random generator is not reset, so every pass yields rows in different order. */
+func (s *ShuffleSource) Reset() error {
+	s.buffer = s.buffer[:0]
+	s.eof = false
+	return s.source.Reset()
+}
+
+/* WriteCache resets source and writes all its rows to w in cache file format. It returns number of rows written. */
+func WriteCache(w io.Writer, source Source) (int, error) {
+	var ncols, count int
+
+	if err := source.Reset(); err != nil {
+		return 0, err
+	}
+	row, err := source.Next()
+	if err == nil {
+		ncols = len(row)
+	} else if err != io.EOF {
+		return 0, err
+	}
+
+	bw := bufio.NewWriter(w)
+	e := encoder{w: bw}
+	e.write(CacheMagic)
+	e.write(uint16(CacheVersion))
+	e.write(uint32(ncols))
+
+	for ; err == nil; row, err = source.Next() {
+		if len(row) != ncols {
+			return count, fmt.Errorf("row #%d: expected %d columns, got %d", count, ncols, len(row))
+		}
+		e.write(row)
+		if e.err != nil {
+			return count, e.err
+		}
+		count++
+	}
+	if err != io.EOF {
+		return count, err
+	}
+	if e.err != nil {
+		return count, e.err
+	}
+
+	return count, bw.Flush()
+}
+
+/* StoreCache writes all rows of source to file in cache file format. */
+func StoreCache(filename string, source Source) (int, error) {
+	var count int
+
+	err := storeFile(filename, func(w io.Writer) error {
+		var err error
+		count, err = WriteCache(w, source)
+		return err
+	})
+	return count, err
+}
+
+/* OpenCache opens file in cache file format for reading its rows. */
+func OpenCache(filename string) (*CacheSource, error) {
+	var header [cacheHeaderSize]byte
+	var s CacheSource
+	var err error
+
+	s.f, err = os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(s.f, header[:]); err != nil {
+		s.f.Close()
+		return nil, fmt.Errorf("%s: truncated cache file: %w", filename, err)
+	}
+
+	if [4]byte(header[:4]) != CacheMagic {
+		s.f.Close()
+		return nil, fmt.Errorf("%s: %w", filename, ErrBadCacheMagic)
+	}
+	if version := binary.LittleEndian.Uint16(header[4:]); version != CacheVersion {
+		s.f.Close()
+		return nil, fmt.Errorf("%s: unsupported cache version %d", filename, version)
+	}
+	s.ncols = int(binary.LittleEndian.Uint32(header[6:]))
+	s.buf = make([]byte, 4*s.ncols)
+	s.row = make([]float32, s.ncols)
+	s.r = bufio.NewReader(s.f)
+
+	return &s, nil
+}
+
+/* Cols returns number of columns of every row. */
+func (s *CacheSource) Cols() int {
+	return s.ncols
+}
+
+func (s *CacheSource) Next() ([]float32, error) {
+	if s.ncols == 0 {
+		return nil, io.EOF
+	}
+
+	if _, err := io.ReadFull(s.r, s.buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%s: truncated cache file", s.f.Name())
+		}
+		return nil, err
+	}
+	for j := 0; j < s.ncols; j++ {
+		s.row[j] = math.Float32frombits(binary.LittleEndian.Uint32(s.buf[4*j:]))
+	}
+
+	return s.row, nil
+}
+
+func (s *CacheSource) Reset() error {
+	if _, err := s.f.Seek(cacheHeaderSize, io.SeekStart); err != nil {
+		return err
+	}
+	s.r.Reset(s.f)
+	return nil
+}
+
+func (s *CacheSource) Close() error {
+	return s.f.Close()
+}
+
+/* readBatch reads at most len(rows) next rows of source into rows, splitting them into first ninputs inputs and remaining outputs. It returns number of rows read, which is less than len(rows) only at the end of source. */
+func readBatch(source Source, ninputs int, rows, inputs, outputs [][]float32) (int, error) {
+	for n := 0; n < len(rows); n++ {
+		row, err := source.Next()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if len(row) <= ninputs {
+			return n, fmt.Errorf("row has %d columns, expected more than %d inputs", len(row), ninputs)
+		}
+
+		rows[n] = append(rows[n][:0], row...)
+		inputs[n] = rows[n][:ninputs]
+		outputs[n] = rows[n][ninputs:]
+	}
+	return len(rows), nil
+}
@@ -0,0 +1,327 @@
+package nn
+
+import (
+	"fmt"
+	"math"
+)
+
+/* WindowOptions control how rows of time series are turned into samples. */
+type WindowOptions struct {
+	/* Lookback is the number of consecutive rows in inputs of every sample, Horizon is the number of rows following them in outputs. */
+	Lookback int
+	Horizon  int
+
+	/* InputColumns and OutputColumns select columns of rows, all columns are used if they are empty. */
+	InputColumns  []int
+	OutputColumns []int
+}
+
+/* WalkForwardOptions control walk-forward validation of Dataset made of time series. Sizes are in samples. */
+type WalkForwardOptions struct {
+	/* Folds is the number of consecutive validation parts at the end of Dataset, ValidationSize is the size of every one. */
+	Folds          int
+	ValidationSize int
+
+	/* Window, if set, makes training part roll forward, keeping at most Window samples. Otherwise it expands from the beginning of Dataset. */
+	Window int
+
+	/* Gap is the number of samples skipped between training and validation parts, so outputs of training samples do not overlap inputs of validation ones. */
+	Gap int
+}
+
+/* Decomposition splits time series into trend, seasonal and residual components, which add up to the series. */
+type Decomposition struct {
+	/* Trend is NaN for the first and the last period/2 rows, as is Residual. */
+	Trend    [][]float32
+	Seasonal [][]float32
+	Residual [][]float32
+
+	/* Seasons holds seasonal component for every position in period, starting with position of the first row. */
+	Seasons [][]float32
+}
+
+const (
+	BaselineNaive = iota
+	BaselineSeasonalNaive
+	BaselineMovingAverage
+)
+
+var BaselineNames = []string{
+	"naive",
+	"seasonal-naive",
+	"moving-average",
+}
+
+/* BaselineByName returns kind of baseline with the given name. */
+func BaselineByName(name string) (int, bool) {
+	for kind := 0; kind < len(BaselineNames); kind++ {
+		if BaselineNames[kind] == name {
+			return kind, true
+		}
+	}
+	return 0, false
+}
+
+/* windowColumns returns columns selected for rows of ncols columns, all of them if columns is empty. */
+func windowColumns(columns []int, ncols int) ([]int, error) {
+	if len(columns) == 0 {
+		return identityOrder(ncols), nil
+	}
+	for _, c := range columns {
+		if (c < 0) || (c >= ncols) {
+			return nil, fmt.Errorf("column %d is out of range [0; %d)", c, ncols)
+		}
+	}
+	return columns, nil
+}
+
+/* appendColumns appends selected columns of row to xs. */
+func appendColumns(xs []float32, row []float32, columns []int) []float32 {
+	for _, c := range columns {
+		xs = append(xs, row[c])
+	}
+	return xs
+}
+
+/* validate checks options against series and returns its input and output columns. */
+func (options *WindowOptions) validate(series [][]float32) ([]int, []int, error) {
+	if (options.Lookback <= 0) || (options.Horizon <= 0) {
+		return nil, nil, fmt.Errorf("invalid lookback %d and horizon %d", options.Lookback, options.Horizon)
+	}
+	if len(series) < options.Lookback+options.Horizon {
+		return nil, nil, fmt.Errorf("series of %d rows is shorter than lookback %d and horizon %d", len(series), options.Lookback, options.Horizon)
+	}
+
+	inputColumns, err := windowColumns(options.InputColumns, len(series[0]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("input %w", err)
+	}
+	outputColumns, err := windowColumns(options.OutputColumns, len(series[0]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("output %w", err)
+	}
+	return inputColumns, outputColumns, nil
+}
+
+/* Windows returns Dataset with a sample for every position in series: inputs hold Lookback rows starting at it, outputs hold Horizon rows following them. Rows are joined together, so values of every step are next to each other, as recurrent layers expect. Unlike other Datasets, rows are copied. */
+func Windows(series [][]float32, options *WindowOptions) (Dataset, error) {
+	inputColumns, outputColumns, err := options.validate(series)
+	if err != nil {
+		return Dataset{}, err
+	}
+
+	n := len(series) - options.Lookback - options.Horizon + 1
+	d := Dataset{Inputs: make([][]float32, n), Outputs: make([][]float32, n)}
+	for i := 0; i < n; i++ {
+		inputs := make([]float32, 0, options.Lookback*len(inputColumns))
+		for t := i; t < i+options.Lookback; t++ {
+			inputs = appendColumns(inputs, series[t], inputColumns)
+		}
+		outputs := make([]float32, 0, options.Horizon*len(outputColumns))
+		for t := i + options.Lookback; t < i+options.Lookback+options.Horizon; t++ {
+			outputs = appendColumns(outputs, series[t], outputColumns)
+		}
+		d.Inputs[i] = inputs
+		d.Outputs[i] = outputs
+	}
+
+	return d, nil
+}
+
+/* WalkForward splits Dataset into folds for walk-forward validation. Samples are never shuffled and every training part precedes its validation part. */
+func (d *Dataset) WalkForward(options *WalkForwardOptions) ([]Fold, error) {
+	if (options.Folds <= 0) || (options.ValidationSize <= 0) || (options.Window < 0) || (options.Gap < 0) {
+		return nil, fmt.Errorf("invalid walk-forward options: %d folds of %d samples, window %d, gap %d", options.Folds, options.ValidationSize, options.Window, options.Gap)
+	}
+
+	folds := make([]Fold, options.Folds)
+	for f := 0; f < options.Folds; f++ {
+		first := d.Len() - (options.Folds-f)*options.ValidationSize
+		last := first - options.Gap
+		if last <= 0 {
+			return nil, fmt.Errorf("no samples left for training in fold #%d out of %d", f, d.Len())
+		}
+
+		start := 0
+		if options.Window > 0 {
+			start = max(last-options.Window, 0)
+		}
+		folds[f] = Fold{
+			Training:   d.Subset(ColumnRange(start, last)),
+			Validation: d.Subset(ColumnRange(first, first+options.ValidationSize)),
+		}
+	}
+
+	return folds, nil
+}
+
+/* WalkForwardValidate is like CrossValidate, but uses walk-forward folds. */
+func (d *Dataset) WalkForwardValidate(options *WalkForwardOptions, evaluate func(fold int, training, validation Dataset) ([]float32, error)) ([]float32, []float32, error) {
+	folds, err := d.WalkForward(options)
+	if err != nil {
+		return nil, nil, err
+	}
+	return validateFolds(folds, evaluate)
+}
+
+/* Difference returns differences between every row of series and the row lag steps before it. Result has lag rows less than series. */
+func Difference(series [][]float32, lag int) ([][]float32, error) {
+	if (lag <= 0) || (lag >= len(series)) {
+		return nil, fmt.Errorf("cannot difference %d rows with lag %d", len(series), lag)
+	}
+
+	diffs := make([][]float32, len(series)-lag)
+	for t := lag; t < len(series); t++ {
+		diff := make([]float32, len(series[t]))
+		for j := 0; j < len(diff); j++ {
+			diff[j] = series[t][j] - series[t-lag][j]
+		}
+		diffs[t-lag] = diff
+	}
+
+	return diffs, nil
+}
+
+/* Integrate inverts Difference: it restores rows following history from their differences. History must have at least lag rows. */
+func Integrate(history, diffs [][]float32, lag int) ([][]float32, error) {
+	if (lag <= 0) || (len(history) < lag) {
+		return nil, fmt.Errorf("cannot integrate with lag %d after %d rows", lag, len(history))
+	}
+
+	rows := make([][]float32, len(diffs))
+	for t := 0; t < len(diffs); t++ {
+		var prev []float32
+		if t < lag {
+			prev = history[len(history)-lag+t]
+		} else {
+			prev = rows[t-lag]
+		}
+
+		row := make([]float32, len(diffs[t]))
+		for j := 0; j < len(row); j++ {
+			row[j] = prev[j] + diffs[t][j]
+		}
+		rows[t] = row
+	}
+
+	return rows, nil
+}
+
+/* newNaNRows returns n rows of ncols NaNs. */
+func newNaNRows(n, ncols int) [][]float32 {
+	rows := make([][]float32, n)
+	for i := 0; i < n; i++ {
+		rows[i] = make([]float32, ncols)
+		for j := 0; j < ncols; j++ {
+			rows[i][j] = float32(math.NaN())
+		}
+	}
+	return rows
+}
+
+/* Decompose splits series into components with classical additive decomposition: trend is centered moving average over period, seasonal component is average of detrended values at the same position in period. Series must span at least two periods. */
+func Decompose(series [][]float32, period int) (*Decomposition, error) {
+	if (period < 2) || (len(series) < 2*period) {
+		return nil, fmt.Errorf("cannot decompose %d rows with period %d", len(series), period)
+	}
+
+	ncols := len(series[0])
+	d := Decomposition{Trend: newNaNRows(len(series), ncols), Seasonal: make([][]float32, len(series)), Residual: newNaNRows(len(series), ncols), Seasons: make([][]float32, period)}
+
+	/* NOTE(anton2920): for even period moving average spans period+1 rows with halved weights at both ends, so it stays centered. */
+	half := period / 2
+	for t := half; t < len(series)-half; t++ {
+		for j := 0; j < ncols; j++ {
+			var sum float64
+			for k := t - half; k <= t+half; k++ {
+				sum += float64(series[k][j])
+			}
+			if period%2 == 0 {
+				sum -= float64(series[t-half][j]+series[t+half][j]) / 2
+			}
+			d.Trend[t][j] = float32(sum / float64(period))
+		}
+	}
+
+	counts := make([]int, period)
+	sums := make([][]float64, period)
+	for p := 0; p < period; p++ {
+		sums[p] = make([]float64, ncols)
+	}
+	for t := half; t < len(series)-half; t++ {
+		for j := 0; j < ncols; j++ {
+			sums[t%period][j] += float64(series[t][j] - d.Trend[t][j])
+		}
+		counts[t%period]++
+	}
+	mean := make([]float64, ncols)
+	for p := 0; p < period; p++ {
+		for j := 0; j < ncols; j++ {
+			sums[p][j] /= float64(counts[p])
+			mean[j] += sums[p][j] / float64(period)
+		}
+	}
+	for p := 0; p < period; p++ {
+		d.Seasons[p] = make([]float32, ncols)
+		for j := 0; j < ncols; j++ {
+			d.Seasons[p][j] = float32(sums[p][j] - mean[j])
+		}
+	}
+
+	for t := 0; t < len(series); t++ {
+		d.Seasonal[t] = d.Seasons[t%period]
+		for j := 0; j < ncols; j++ {
+			d.Residual[t][j] = series[t][j] - d.Trend[t][j] - d.Seasonal[t][j]
+		}
+	}
+
+	return &d, nil
+}
+
+/* Baseline returns predictions of simple forecasting method for every sample of Windows(series, options), laid out like its outputs. Naive forecast repeats the last row of lookback, seasonal naive repeats rows one period before, moving average repeats mean of the last period rows. Period must not exceed lookback. */
+func Baseline(kind int, series [][]float32, options *WindowOptions, period int) ([][]float32, error) {
+	_, columns, err := options.validate(series)
+	if err != nil {
+		return nil, err
+	}
+	if kind == BaselineNaive {
+		period = 1
+	}
+	if (period <= 0) || (period > options.Lookback) {
+		return nil, fmt.Errorf("period %d is out of range [1; %d]", period, options.Lookback)
+	}
+
+	n := len(series) - options.Lookback - options.Horizon + 1
+	predictions := make([][]float32, n)
+	mean := make([]float32, len(columns))
+	for i := 0; i < n; i++ {
+		next := i + options.Lookback
+
+		if kind == BaselineMovingAverage {
+			for k, c := range columns {
+				var sum float32
+				for t := next - period; t < next; t++ {
+					sum += series[t][c]
+				}
+				mean[k] = sum / float32(period)
+			}
+		}
+
+		prediction := make([]float32, 0, options.Horizon*len(columns))
+		for h := 0; h < options.Horizon; h++ {
+			switch kind {
+			case BaselineNaive, BaselineSeasonalNaive:
+				/* NOTE(anton2920): the latest row at the same position in period, which is always inside lookback. */
+				t := next + h - period*(h/period+1)
+				prediction = appendColumns(prediction, series[t], columns)
+			case BaselineMovingAverage:
+				prediction = append(prediction, mean...)
+			default:
+				return nil, fmt.Errorf("unknown baseline %d", kind)
+			}
+		}
+		predictions[i] = prediction
+	}
+
+	return predictions, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anton2920/go/lab/NN/nn"
+)
+
+/* NewOptimizer returns optimizer with the given name, see -optimizer. */
+func NewOptimizer(name string, rate, momentum float32) (nn.Optimizer, error) {
+	switch name {
+	case "sgd":
+		return nn.NewSGD(rate), nil
+	case "momentum":
+		return nn.NewMomentum(rate, momentum), nil
+	case "nesterov":
+		return nn.NewNesterov(rate, momentum), nil
+	case "rmsprop":
+		return nn.NewRMSProp(rate), nil
+	case "adam":
+		return nn.NewAdam(rate), nil
+	default:
+		return nil, fmt.Errorf("unknown optimizer %q", name)
+	}
+}
+
+/* NewSchedule returns learning rate schedule with the given name, see -schedule. Meaning of factor and steps depends on schedule. */
+func NewSchedule(name string, rate, factor float32, steps, epochs int) (nn.Schedule, error) {
+	switch name {
+	case "constant":
+		return nn.NewConstantRate(rate), nil
+	case "step":
+		if steps <= 0 {
+			return nil, fmt.Errorf("step schedule needs positive number of decay steps, got %d", steps)
+		}
+		return nn.NewStepDecay(rate, factor, steps), nil
+	case "exponential":
+		if steps <= 0 {
+			return nil, fmt.Errorf("exponential schedule needs positive time constant, got %d", steps)
+		}
+		return nn.NewExponentialDecay(rate, float32(steps)), nil
+	case "cosine":
+		if epochs <= 0 {
+			return nil, fmt.Errorf("cosine schedule needs positive number of epochs, got %d", epochs)
+		}
+		return nn.NewCosineAnnealing(rate, 0, epochs), nil
+	case "plateau":
+		if steps < 0 {
+			return nil, fmt.Errorf("plateau schedule needs non-negative patience, got %d", steps)
+		}
+		return nn.NewReduceOnPlateau(rate, factor, steps), nil
+	default:
+		return nil, fmt.Errorf("unknown schedule %q", name)
+	}
+}
+
+/* ParseColumns parses comma-separated column names, indices and inclusive ranges of indices, like "lat,lon" or "0-3,5". Ranges are expanded into indices. */
+func ParseColumns(spec string) ([]string, error) {
+	var columns []string
+
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			return nil, fmt.Errorf("empty column in %q", spec)
+		}
+
+		first, last, isRange := strings.Cut(field, "-")
+		from, err1 := strconv.Atoi(first)
+		to, err2 := strconv.Atoi(last)
+		if (!isRange) || (err1 != nil) || (err2 != nil) {
+			columns = append(columns, field)
+			continue
+		}
+		if (from < 0) || (to < from) {
+			return nil, fmt.Errorf("invalid column range %q", field)
+		}
+		for c := from; c <= to; c++ {
+			columns = append(columns, strconv.Itoa(c))
+		}
+	}
+
+	return columns, nil
+}
+
+/* ParseLayers parses comma-separated layers, like "10:relu,5:softmax". Every layer is NEURONS:FUNCTION, optionally followed by :INITIALIZER and :DROPOUT in any order. */
+func ParseLayers(spec string) ([]nn.Layer, error) {
+	var layers []nn.Layer
+
+	if strings.TrimSpace(spec) == "" {
+		return nil, fmt.Errorf("no layers")
+	}
+
+	for l, field := range strings.Split(spec, ",") {
+		var layer nn.Layer
+		var ok bool
+		var err error
+
+		parts := strings.Split(strings.TrimSpace(field), ":")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("layer #%d: expected NEURONS:FUNCTION, got %q", l, field)
+		}
+
+		layer.Neurons, err = strconv.Atoi(parts[0])
+		if (err != nil) || (layer.Neurons <= 0) {
+			return nil, fmt.Errorf("layer #%d: invalid number of neurons %q", l, parts[0])
+		}
+		layer.FunctionID, ok = nn.FunctionByName(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("layer #%d: unknown activation function %q", l, parts[1])
+		}
+
+		for _, part := range parts[2:] {
+			if initializer, ok := nn.Initializers[part]; ok {
+				layer.Initializer = initializer
+				continue
+			}
+			dropout, err := strconv.ParseFloat(part, 32)
+			if (err != nil) || (dropout < 0) || (dropout >= 1) {
+				return nil, fmt.Errorf("layer #%d: %q is neither initializer nor dropout probability", l, part)
+			}
+			layer.Dropout = float32(dropout)
+		}
+
+		layers = append(layers, layer)
+	}
+
+	return layers, nil
+}
+
+/* LoadConfig sets flags of fs from file with a "name = value" line for every flag. Flags already set on command line are kept. Empty lines and lines starting with '#' are ignored. */
+func LoadConfig(fs *flag.FlagSet, filename string) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if (len(text) == 0) || (text[0] == '#') {
+			continue
+		}
+
+		name, value, ok := strings.Cut(text, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected 'name = value'", filename, line)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if (name == "config") || (fs.Lookup(name) == nil) {
+			return fmt.Errorf("%s:%d: unknown option %q", filename, line, name)
+		}
+
+		if set[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+/* ParseFlags parses args and then reads flags which were not set from file given by -config, if any. */
+func ParseFlags(fs *flag.FlagSet, args []string) error {
+	config := fs.String("config", "", "read options from file with 'name = value' line for every option; command line options take precedence")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *config != "" {
+		return LoadConfig(fs, *config)
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/anton2920/go/lab/NN/nn"
+)
+
+type Command struct {
+	Name        string
+	Description string
+	Run         func(args []string) error
+}
+
+/* DataOptions select CSV file, the way it is read and its columns used as inputs and outputs of NN. */
+type DataOptions struct {
+	File    string
+	Inputs  string
+	Outputs string
+
+	Header        bool
+	Delimiter     string
+	Decimal       string
+	Missing       string
+	MissingValues string
+	Categorical   string
+}
+
+const (
+	TaskRegression     = "regression"
+	TaskClassification = "classification"
+)
+
+var Commands = []Command{
+	{"train", "train NN on CSV data and store it", Train},
+	{"eval", "report metrics of stored NN on CSV data", Eval},
+	{"predict", "write outputs of stored NN for CSV data", Predict},
+	{"inspect", "print architecture and parameters of stored NN", Inspect},
+}
+
+func Fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format, args...)
+	os.Exit(1)
+}
+
+func Usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s command [options]\n\nCommands:\n", filepath.Base(os.Args[0]))
+	for _, command := range Commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", command.Name, command.Description)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun '%s command -h' for options of command.\n", filepath.Base(os.Args[0]))
+}
+
+func (o *DataOptions) Register(fs *flag.FlagSet) {
+	fs.StringVar(&o.File, "data", "", "CSV `file` with samples")
+	fs.StringVar(&o.Inputs, "inputs", "", "`columns` used as inputs, by names or indices, like 'lat,lon' or '0-3,5' (default: first columns NN takes)")
+	fs.StringVar(&o.Outputs, "outputs", "", "`columns` used as outputs, like -inputs (default: columns after inputs)")
+	fs.BoolVar(&o.Header, "header", false, "treat the first row as names of columns")
+	fs.StringVar(&o.Delimiter, "delimiter", ",", "field `delimiter`, use '\\t' for tabs")
+	fs.StringVar(&o.Decimal, "decimal", ".", "decimal `separator` of numbers")
+	fs.StringVar(&o.Missing, "missing", nn.MissingNames[nn.MissingError], fmt.Sprintf("`strategy` for missing values, one of %s", strings.Join(nn.MissingNames, ", ")))
+	fs.StringVar(&o.MissingValues, "missing-values", "", "comma-separated `values` treated as missing in addition to empty ones, like 'NA,?'")
+	fs.StringVar(&o.Categorical, "categorical", "", "comma-separated `columns` which are one-hot encoded, categories are ordered by their first appearance")
+}
+
+/* parseRune returns the only character of s, interpreting "\t" as tab. */
+func parseRune(name, s string) (rune, error) {
+	if s == "\\t" {
+		return '\t', nil
+	}
+	rs := []rune(s)
+	if len(rs) != 1 {
+		return 0, fmt.Errorf("%s must be a single character, got %q", name, s)
+	}
+	return rs[0], nil
+}
+
+/* Read reads samples from CSV file. If columns are not set, ninputs first columns are inputs and the rest are outputs. Outputs are not read if withOutputs is false. */
+func (o *DataOptions) Read(ninputs int, withOutputs bool) (nn.Dataset, error) {
+	var options nn.CSVOptions
+	var inputs, outputs []string
+	var ok bool
+	var err error
+
+	if o.File == "" {
+		return nn.Dataset{}, fmt.Errorf("no data file, see -data")
+	}
+
+	options.Header = o.Header
+	if options.Comma, err = parseRune("delimiter", o.Delimiter); err != nil {
+		return nn.Dataset{}, err
+	}
+	if options.Decimal, err = parseRune("decimal separator", o.Decimal); err != nil {
+		return nn.Dataset{}, err
+	}
+	if options.Missing, ok = nn.MissingByName(o.Missing); !ok {
+		return nn.Dataset{}, fmt.Errorf("unknown missing-value strategy %q", o.Missing)
+	}
+	if o.MissingValues != "" {
+		options.MissingValues = strings.Split(o.MissingValues, ",")
+	}
+	if o.Categorical != "" {
+		if options.Categorical, err = ParseColumns(o.Categorical); err != nil {
+			return nn.Dataset{}, fmt.Errorf("invalid categorical columns: %w", err)
+		}
+	}
+
+	if o.Inputs != "" {
+		if inputs, err = ParseColumns(o.Inputs); err != nil {
+			return nn.Dataset{}, fmt.Errorf("invalid inputs: %w", err)
+		}
+	}
+	if o.Outputs != "" {
+		if outputs, err = ParseColumns(o.Outputs); err != nil {
+			return nn.Dataset{}, fmt.Errorf("invalid outputs: %w", err)
+		}
+	}
+	/* NOTE(anton2920): other columns may be not numbers, so only selected ones are read, if all of them are known. */
+	if (inputs != nil) && ((outputs != nil) || (!withOutputs)) {
+		options.Columns = append(append(options.Columns, inputs...), outputs...)
+	}
+
+	table, err := nn.ReadCSV(o.File, &options)
+	if err != nil {
+		return nn.Dataset{}, fmt.Errorf("failed to read data: %w", err)
+	}
+	if len(table.Rows) == 0 {
+		return nn.Dataset{}, fmt.Errorf("%s: no samples", o.File)
+	}
+
+	if inputs == nil {
+		if (ninputs <= 0) || (ninputs > len(table.Names)) {
+			return nn.Dataset{}, fmt.Errorf("no input columns, see -inputs")
+		}
+		inputs = table.Names[:ninputs]
+	}
+
+	dataset, err := table.Dataset(inputs, outputs)
+	if err != nil {
+		return dataset, fmt.Errorf("%s: %w", o.File, err)
+	}
+	if !withOutputs {
+		dataset.Outputs = nil
+	} else if len(dataset.Outputs[0]) == 0 {
+		return dataset, fmt.Errorf("no output columns, see -outputs")
+	}
+
+	return dataset, nil
+}
+
+/* LoadNetwork reads NN from JSON file, if filename ends with ".json", or from binary one otherwise. */
+func LoadNetwork(filename string) (nn.NN, error) {
+	var network nn.NN
+	var err error
+
+	if strings.HasSuffix(filename, ".json") {
+		err = network.LoadJSON(filename)
+	} else {
+		err = network.Load(filename)
+	}
+	if err != nil {
+		return network, fmt.Errorf("failed to load NN: %w", err)
+	}
+	if !network.Trained {
+		return network, fmt.Errorf("NN from %q is not trained", filename)
+	}
+
+	return network, nil
+}
+
+/* StoreNetwork writes NN to JSON or ONNX file, if filename ends with ".json" or ".onnx", or to binary one otherwise. */
+func StoreNetwork(network *nn.NN, filename string) error {
+	var err error
+
+	switch {
+	case strings.HasSuffix(filename, ".json"):
+		err = network.StoreJSON(filename)
+	case strings.HasSuffix(filename, ".onnx"):
+		err = network.StoreONNX(filename)
+	default:
+		err = network.Store(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store NN: %w", err)
+	}
+
+	return nil
+}
+
+/* DefaultTask guesses task of NN from its output layer and loss. */
+func DefaultTask(network *nn.NN) string {
+	if (network.Layers[len(network.Layers)-1].FunctionID == nn.FunctionSoftmax) || (network.LossID == nn.LossCrossEntropy) {
+		return TaskClassification
+	}
+	return TaskRegression
+}
+
+/* Report compares predicted outputs with expected ones and writes metrics for task as text to stdout and as CSV to reportFile, if set. */
+func Report(task string, predicted, expected [][]float32, names []string, reportFile string) error {
+	type report interface {
+		WriteText(w io.Writer) error
+		WriteCSV(w io.Writer) error
+	}
+	var r report
+
+	switch task {
+	case TaskRegression:
+		metrics := nn.Regression(predicted, expected)
+		r = &metrics
+	case TaskClassification:
+		metrics := nn.Classification(predicted, expected)
+		metrics.Names = names
+		r = &metrics
+	default:
+		return fmt.Errorf("unknown task %q, expected %q or %q", task, TaskRegression, TaskClassification)
+	}
+
+	if err := r.WriteText(os.Stdout); err != nil {
+		return err
+	}
+	if reportFile != "" {
+		f, err := os.Create(reportFile)
+		if err != nil {
+			return fmt.Errorf("failed to create report: %w", err)
+		}
+		defer f.Close()
+
+		if err := r.WriteCSV(f); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func checkShape(network *nn.NN, dataset *nn.Dataset) error {
+	if ninputs := network.NumInputs(); len(dataset.Inputs[0]) != ninputs {
+		return fmt.Errorf("NN takes %d inputs, got %d", ninputs, len(dataset.Inputs[0]))
+	}
+	if (dataset.Outputs != nil) && (len(dataset.Outputs[0]) != network.NumOutputs()) {
+		return fmt.Errorf("NN has %d outputs, got %d", network.NumOutputs(), len(dataset.Outputs[0]))
+	}
+	return nil
+}
+
+func splitNames(names string) []string {
+	if names == "" {
+		return nil
+	}
+	return strings.Split(names, ",")
+}
+
+func Train(args []string) error {
+	var data DataOptions
+	var network nn.NN
+	var ok bool
+
+	fs := flag.NewFlagSet("train", flag.ExitOnError)
+	data.Register(fs)
+	layers := fs.String("layers", "", "comma-separated `layers` NEURONS:FUNCTION[:INITIALIZER][:DROPOUT], like '10:relu:he-uniform,3:softmax'")
+	loss := fs.String("loss", nn.LossNames[nn.LossMSE], fmt.Sprintf("`loss` function, one of %s", strings.Join(nn.LossNames, ", ")))
+	optimizer := fs.String("optimizer", "sgd", "`optimizer`, one of sgd, momentum, nesterov, rmsprop, adam")
+	rate := fs.Float64("rate", 0.01, "learning `rate`")
+	momentum := fs.Float64("momentum", 0.9, "momentum of momentum and nesterov optimizers")
+	schedule := fs.String("schedule", "constant", "learning rate `schedule`, one of constant, step, exponential, cosine, plateau")
+	decay := fs.Float64("decay", 0.5, "factor rate is multiplied by in step and plateau schedules")
+	decaySteps := fs.Int("decay-steps", 10, "epochs between decays of step schedule, time constant of exponential one or patience of plateau one")
+	batchSize := fs.Int("batch", 32, "batch `size`, 0 means all samples")
+	epochs := fs.Int("epochs", 100, "maximum number of `epochs`")
+	validationSplit := fs.Float64("validation", 0.2, "`fraction` of training samples used for validation")
+	testSplit := fs.Float64("test", 0, "`fraction` of samples held out for evaluation after training")
+	patience := fs.Int("patience", 0, "`epochs` without improvement of validation loss before training stops, 0 disables early stopping")
+	minDelta := fs.Float64("min-delta", 0, "minimal improvement of validation loss")
+	workers := fs.Int("workers", 1, "number of goroutines every batch is split between")
+	l1 := fs.Float64("l1", 0, "L1 penalty of weights")
+	l2 := fs.Float64("l2", 0, "L2 penalty of weights")
+	maxGradNorm := fs.Float64("max-grad-norm", 0, "maximum L2 norm of gradients, 0 disables clipping")
+	inputScaler := fs.String("input-scaler", "", fmt.Sprintf("`scaler` of inputs, one of %s", strings.Join(nn.ScalerNames, ", ")))
+	outputScaler := fs.String("output-scaler", "", "`scaler` of outputs, see -input-scaler")
+	shuffle := fs.Bool("shuffle", false, "shuffle samples before splitting them")
+	stratified := fs.Bool("stratified", false, "keep proportions of classes the same in every part of split samples")
+	seed := fs.Int64("seed", nn.Seed, "`seed` of shuffling")
+	task := fs.String("task", "", "task used for evaluation, 'regression' or 'classification' (default: guessed from NN)")
+	names := fs.String("names", "", "comma-separated `names` of classes used in reports")
+	reportFile := fs.String("report", "", "write metrics of NN on test samples to CSV `file`")
+	modelFile := fs.String("model", "nn.bin", "`file` NN is stored to, in JSON or ONNX format if it ends with '.json' or '.onnx'")
+	verbose := fs.Bool("v", false, "print losses after every epoch")
+	if err := ParseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var err error
+	if network.Layers, err = ParseLayers(*layers); err != nil {
+		return fmt.Errorf("invalid layers: %w", err)
+	}
+	if network.LossID, ok = nn.LossByName(*loss); !ok {
+		return fmt.Errorf("unknown loss %q", *loss)
+	}
+	network.Regularization = nn.Regularization{L1: float32(*l1), L2: float32(*l2), MaxGradNorm: float32(*maxGradNorm)}
+
+	opt, err := NewOptimizer(*optimizer, float32(*rate), float32(*momentum))
+	if err != nil {
+		return err
+	}
+	sched, err := NewSchedule(*schedule, float32(*rate), float32(*decay), *decaySteps, *epochs)
+	if err != nil {
+		return err
+	}
+
+	dataset, err := data.Read(0, true)
+	if err != nil {
+		return err
+	}
+	if n := network.NumOutputs(); n != len(dataset.Outputs[0]) {
+		return fmt.Errorf("output layer has %d neurons, but there are %d output columns", n, len(dataset.Outputs[0]))
+	}
+	if *task == "" {
+		*task = DefaultTask(&network)
+	}
+
+	training, _, test, err := dataset.Split(&nn.SplitOptions{TestSplit: float32(*testSplit), Shuffle: *shuffle, Seed: *seed, Stratified: *stratified})
+	if err != nil {
+		return err
+	}
+
+	/* NOTE(anton2920): scalers are fitted only on samples TrainValidate trains on, so validation loss does not depend on validation samples through them. */
+	if (*validationSplit < 0) || (*validationSplit >= 1) {
+		return fmt.Errorf("validation split %g is out of range [0; 1)", *validationSplit)
+	}
+	ntraining := nn.TrainingSamples(training.Len(), float32(*validationSplit))
+	if *inputScaler != "" {
+		kind, ok := nn.ScalerByName(*inputScaler)
+		if !ok {
+			return fmt.Errorf("unknown input scaler %q", *inputScaler)
+		}
+		network.InputScaler = nn.NewScaler(kind)
+		if err := network.InputScaler.Fit(training.Inputs[:ntraining]); err != nil {
+			return fmt.Errorf("failed to scale inputs: %w", err)
+		}
+		network.InputScaler.Transform(training.Inputs)
+	}
+	if *outputScaler != "" {
+		kind, ok := nn.ScalerByName(*outputScaler)
+		if !ok {
+			return fmt.Errorf("unknown output scaler %q", *outputScaler)
+		}
+		network.OutputScaler = nn.NewScaler(kind)
+		if err := network.OutputScaler.Fit(training.Outputs[:ntraining]); err != nil {
+			return fmt.Errorf("failed to scale outputs: %w", err)
+		}
+		network.OutputScaler.Transform(training.Outputs)
+	}
+
+	epoch, err := network.TrainValidate(training.Inputs, training.Outputs, &nn.TrainOptions{
+		Optimizer:       opt,
+		Schedule:        sched,
+		BatchSize:       *batchSize,
+		MaxEpochs:       *epochs,
+		ValidationSplit: float32(*validationSplit),
+		Workers:         *workers,
+		Patience:        *patience,
+		MinDelta:        float32(*minDelta),
+		Callback: func(metrics nn.EpochMetrics) error {
+			if *verbose {
+				fmt.Printf("Epoch %d: training loss: %f, validation loss: %f\n", metrics.Epoch, metrics.TrainingLoss, metrics.ValidationLoss)
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to train NN: %w", err)
+	}
+	network.Trained = true
+	fmt.Printf("Trained after %d epochs\n", epoch)
+
+	if err := StoreNetwork(&network, *modelFile); err != nil {
+		return err
+	}
+
+	if test.Len() > 0 {
+		fmt.Printf("\nMetrics on %d test samples:\n", test.Len())
+		return Report(*task, network.PredictAll(test.Inputs), test.Outputs, splitNames(*names), *reportFile)
+	}
+	return nil
+}
+
+func Eval(args []string) error {
+	var data DataOptions
+
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	data.Register(fs)
+	modelFile := fs.String("model", "nn.bin", "`file` NN is loaded from, in JSON format if it ends with '.json'")
+	task := fs.String("task", "", "'regression' or 'classification' (default: guessed from NN)")
+	names := fs.String("names", "", "comma-separated `names` of classes used in reports")
+	reportFile := fs.String("report", "", "write metrics to CSV `file`")
+	if err := ParseFlags(fs, args); err != nil {
+		return err
+	}
+
+	network, err := LoadNetwork(*modelFile)
+	if err != nil {
+		return err
+	}
+	dataset, err := data.Read(network.NumInputs(), true)
+	if err != nil {
+		return err
+	}
+	if err := checkShape(&network, &dataset); err != nil {
+		return err
+	}
+	if *task == "" {
+		*task = DefaultTask(&network)
+	}
+
+	return Report(*task, network.PredictAll(dataset.Inputs), dataset.Outputs, splitNames(*names), *reportFile)
+}
+
+func Predict(args []string) error {
+	var data DataOptions
+
+	fs := flag.NewFlagSet("predict", flag.ExitOnError)
+	data.Register(fs)
+	modelFile := fs.String("model", "nn.bin", "`file` NN is loaded from, in JSON format if it ends with '.json'")
+	outputFile := fs.String("o", "", "write outputs to CSV `file` instead of stdout")
+	class := fs.Bool("class", false, "write class, see -names, instead of outputs")
+	names := fs.String("names", "", "comma-separated `names` of classes written instead of their numbers")
+	if err := ParseFlags(fs, args); err != nil {
+		return err
+	}
+
+	network, err := LoadNetwork(*modelFile)
+	if err != nil {
+		return err
+	}
+	dataset, err := data.Read(network.NumInputs(), false)
+	if err != nil {
+		return err
+	}
+	if err := checkShape(&network, &dataset); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *outputFile != "" {
+		if out, err = os.Create(*outputFile); err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer out.Close()
+	}
+
+	classNames := splitNames(*names)
+	cw := csv.NewWriter(out)
+	for _, outputs := range network.PredictAll(dataset.Inputs) {
+		var row []string
+
+		if *class {
+			c := nn.Class(outputs)
+			if len(outputs) == 1 {
+				/* NOTE(anton2920): single output is a probability of class 1, like in nn.Classification. */
+				c = nn.Class([]float32{1 - outputs[0], outputs[0]})
+			}
+			if c < len(classNames) {
+				row = append(row, classNames[c])
+			} else {
+				row = append(row, strconv.Itoa(c))
+			}
+		} else {
+			for _, output := range outputs {
+				row = append(row, strconv.FormatFloat(float64(output), 'g', -1, 32))
+			}
+		}
+
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("failed to write outputs: %w", err)
+		}
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func writeScaler(w io.Writer, name string, s *nn.Scaler) {
+	if s == nil {
+		fmt.Fprintf(w, "%s:\tnone\n", name)
+		return
+	}
+	fmt.Fprintf(w, "%s:\t%s, columns %v\n", name, nn.ScalerNames[s.Kind], s.Columns)
+}
+
+func Inspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	modelFile := fs.String("model", "nn.bin", "`file` NN is loaded from, in JSON format if it ends with '.json'")
+	asJSON := fs.Bool("json", false, "print the whole NN, including weights, as JSON")
+	if err := ParseFlags(fs, args); err != nil {
+		return err
+	}
+
+	network, err := LoadNetwork(*modelFile)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		return network.ExportJSON(os.Stdout)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Inputs:\t%d\n", network.NumInputs())
+	fmt.Fprintf(tw, "Loss:\t%s\n", nn.LossNames[network.LossID])
+	fmt.Fprintf(tw, "Parameters:\t%d\n", network.NumParameters())
+	fmt.Fprintf(tw, "Regularization:\tL1 %g, L2 %g, max gradient norm %g\n", network.Regularization.L1, network.Regularization.L2, network.Regularization.MaxGradNorm)
+	writeScaler(tw, "Input scaler", network.InputScaler)
+	writeScaler(tw, "Output scaler", network.OutputScaler)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Println()
+
+	fmt.Fprintf(tw, "Layer\tKind\tNeurons\tOutputs\tFunction\tSteps\tDropout\tParameters\n")
+	for l, layer := range network.Layers {
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%s\t%d\t%g\t%d\n", l, nn.LayerNames[layer.Kind], layer.Neurons, layer.NumOutputs(), nn.FunctionNames[layer.FunctionID], layer.Steps, layer.Dropout, len(layer.Weights.Data)+len(layer.Biases))
+	}
+	return tw.Flush()
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		Usage()
+		os.Exit(2)
+	}
+
+	for _, command := range Commands {
+		if command.Name == os.Args[1] {
+			if err := command.Run(os.Args[2:]); err != nil {
+				Fatalf("Failed to %s: %s\n", command.Name, err.Error())
+			}
+			return
+		}
+	}
+
+	switch os.Args[1] {
+	case "-h", "-help", "--help", "help":
+		Usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", os.Args[1])
+		Usage()
+		os.Exit(2)
+	}
+}
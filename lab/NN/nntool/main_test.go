@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/anton2920/go/lab/NN/nn"
+)
+
+func TestParseColumns(t *testing.T) {
+	columns, err := ParseColumns("0-2, lat,7-7,a-b")
+	if err != nil {
+		t.Fatalf("Failed to parse columns: %s", err.Error())
+	}
+	if expected := []string{"0", "1", "2", "lat", "7", "a-b"}; !reflect.DeepEqual(columns, expected) {
+		t.Errorf("Expected columns %v, got %v", expected, columns)
+	}
+
+	for _, spec := range [...]string{"", "a,,b", "3-1"} {
+		if _, err := ParseColumns(spec); err == nil {
+			t.Errorf("Expected error for columns %q", spec)
+		}
+	}
+}
+
+func TestParseLayers(t *testing.T) {
+	layers, err := ParseLayers("10:relu:he-uniform:0.2, 3:softmax")
+	if err != nil {
+		t.Fatalf("Failed to parse layers: %s", err.Error())
+	}
+	if len(layers) != 2 {
+		t.Fatalf("Expected 2 layers, got %d", len(layers))
+	}
+	if (layers[0].Neurons != 10) || (layers[0].FunctionID != nn.FunctionReLU) || (layers[0].Initializer == nil) || (layers[0].Dropout != 0.2) {
+		t.Errorf("Wrong first layer: %+v", layers[0])
+	}
+	if (layers[1].Neurons != 3) || (layers[1].FunctionID != nn.FunctionSoftmax) || (layers[1].Initializer != nil) || (layers[1].Dropout != 0) {
+		t.Errorf("Wrong second layer: %+v", layers[1])
+	}
+
+	for _, spec := range [...]string{"", "10", "0:relu", "10:foo", "10:relu:bar", "10:relu:1"} {
+		if _, err := ParseLayers(spec); err == nil {
+			t.Errorf("Expected error for layers %q", spec)
+		}
+	}
+}
+
+func TestNewSchedule(t *testing.T) {
+	for _, name := range [...]string{"constant", "step", "exponential", "cosine", "plateau"} {
+		if _, err := NewSchedule(name, 0.1, 0.5, 10, 100); err != nil {
+			t.Errorf("Failed to create %s schedule: %s", name, err.Error())
+		}
+	}
+
+	for _, c := range [...]struct {
+		Name   string
+		Steps  int
+		Epochs int
+	}{
+		{"step", 0, 100},
+		{"step", -1, 100},
+		{"exponential", 0, 100},
+		{"exponential", -1, 100},
+		{"cosine", 10, 0},
+		{"cosine", 10, -1},
+		{"plateau", -1, 100},
+		{"unknown", 10, 100},
+	} {
+		if _, err := NewSchedule(c.Name, 0.1, 0.5, c.Steps, c.Epochs); err == nil {
+			t.Errorf("Expected error for %s schedule with %d steps and %d epochs", c.Name, c.Steps, c.Epochs)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	newFlagSet := func() (*flag.FlagSet, *string, *int, *bool) {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		return fs, fs.String("layers", "", ""), fs.Int("epochs", 100, ""), fs.Bool("shuffle", false, "")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "train.conf")
+	if err := os.WriteFile(config, []byte("# Comment.\nlayers = 5:tanh,2:identity\n\nepochs=10\nshuffle = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %s", err.Error())
+	}
+
+	fs, layers, epochs, shuffle := newFlagSet()
+	if err := ParseFlags(fs, []string{"-config", config, "-epochs", "20"}); err != nil {
+		t.Fatalf("Failed to parse flags: %s", err.Error())
+	}
+	if (*layers != "5:tanh,2:identity") || (*epochs != 20) || (!*shuffle) {
+		t.Errorf("Expected options from config and command line, got %q, %d, %v", *layers, *epochs, *shuffle)
+	}
+
+	for _, text := range [...]string{"epochs 10\n", "rate = 0.1\n", "epochs = ten\n"} {
+		if err := os.WriteFile(config, []byte(text), 0644); err != nil {
+			t.Fatalf("Failed to write config: %s", err.Error())
+		}
+		fs, _, _, _ := newFlagSet()
+		if err := ParseFlags(fs, []string{"-config", config}); err == nil {
+			t.Errorf("Expected error for config %q", text)
+		}
+	}
+}
@@ -1,403 +1,31 @@
 package main
 
 import (
-	"encoding/csv"
-	"encoding/gob"
 	"fmt"
-	"math"
-	"math/rand"
 	"os"
-	"strconv"
-	"strings"
-)
-
-type ActivationFunction func(float32) float32
-
-type Neuron struct {
-	Weights         []float32
-	PreviousWeights []float32
-	Bias            float32
-}
-
-type Layer struct {
-	Neurons    []Neuron
-	Outputs    []float32
-	FunctionID int
-}
 
-type NN struct {
-	Layers    []Layer
-	MinVector []float32
-	MaxVector []float32
-}
-
-const (
-	FunctionIdentity = iota
-	FunctionSigmoid
-	FunctionTh
-	FunctionReLU
+	"github.com/anton2920/go/lab/NN/nn"
 )
 
 const (
-	Ninputs      = 4
-	TrainingFile = "training.csv"
-	NNFile       = "nn.bin"
+	Ninputs = 4
+	Window  = 8
+	Period  = 24
+	Steps   = 6
+	Level   = 0.9
+	Samples = 200
+
+	ValidationSplit = 0.2
+	TrainingFile    = "training.csv"
+	NNFile          = "nn.bin"
+	JSONFile        = "nn.json"
 )
 
-var (
-	Functions = []ActivationFunction{
-		Identity,
-		Sigmoid,
-		Th,
-		ReLU,
-	}
-
-	Derivatives = []ActivationFunction{
-		IdentityPrime,
-		SigmoidPrime,
-		ThPrime,
-		ReLUPrime,
-	}
-)
-
-func Identity(x float32) float32 {
-	return x
-}
-
-func IdentityPrime(x float32) float32 {
-	/* TODO(anton2920): find out if it should be like that. */
-	return 1
-}
-
-func Sigmoid(x float32) float32 {
-	return 1 / (1 + float32(math.Exp(float64(-x))))
-}
-
-func SigmoidPrime(x float32) float32 {
-	return x * (1 - x)
-}
-
-func Th(x float32) float32 {
-	return float32(math.Tanh(float64(x)))
-}
-
-func ThPrime(x float32) float32 {
-	return 1 - x*x
-}
-
-func ReLU(x float32) float32 {
-	if x < 0 {
-		x *= 0.01
-	}
-	return x
-}
-
-func ReLUPrime(x float32) float32 {
-	if x < 0 {
-		return 0.01
-	} else {
-		return 1
-	}
-}
-
-func (n *Neuron) Query(inputs []float32) float32 {
-	var output float32
-
-	for i := 0; i < len(inputs); i++ {
-		output += inputs[i] * n.Weights[i]
-	}
-	output += n.Bias
-
-	return output
-}
-
-func (l *Layer) Query(inputs []float32) []float32 {
-	if l.Outputs == nil {
-		l.Outputs = make([]float32, len(l.Neurons))
-	}
-
-	for n := 0; n < len(l.Neurons); n++ {
-		neuron := &l.Neurons[n]
-		l.Outputs[n] = Functions[l.FunctionID](neuron.Query(inputs))
-	}
-	return l.Outputs
-}
-
-func (nn *NN) Load(filename string) error {
-	f, err := os.Open(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	gobDecoder := gob.NewDecoder(f)
-	if err := gobDecoder.Decode(&nn); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (nn *NN) Store(filename string) error {
-	f, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	gobEncoder := gob.NewEncoder(f)
-	if err := gobEncoder.Encode(&nn); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (nn *NN) Query(inputs []float32) []float32 {
-	outputs := inputs
-	for l := 0; l < len(nn.Layers); l++ {
-		layer := &nn.Layers[l]
-		outputs = layer.Query(outputs)
-	}
-	return outputs
-}
-
-func (nn *NN) Train(inputs [][]float32, outputs [][]float32, validationSplit float32, trainingRate float32, maxNumberOfEpochs int) int {
-	var currentEpoch, countdown int
-	var minMSE float32 = 10
-
-	rng := rand.New(rand.NewSource(6585))
-	for l := 0; l < len(nn.Layers); l++ {
-		layer := &nn.Layers[l]
-		layer.Outputs = nil
-
-		for n := 0; n < len(layer.Neurons); n++ {
-			neuron := &layer.Neurons[n]
-
-			var nweights int
-			if l == 0 {
-				nweights = len(inputs[0])
-			} else {
-				nweights = len(nn.Layers[l-1].Neurons)
-			}
-			neuron.Weights = make([]float32, nweights)
-
-			for w := 0; w < len(neuron.Weights); w++ {
-				neuron.Weights[w] = (rng.Float32() - 0.5) / 10
-			}
-			neuron.Bias = (rng.Float32() - 0.5) / 10
-
-			neuron.PreviousWeights = make([]float32, len(neuron.Weights))
-			copy(neuron.PreviousWeights, neuron.Weights)
-		}
-	}
-
-	for ; currentEpoch < maxNumberOfEpochs; currentEpoch++ {
-		for i := 0; i < int(float32(len(inputs))*(1-validationSplit)); i++ {
-			results := nn.Query(inputs[i])
-
-			// fmt.Println(inputs[i], outputs[i], results)
-			// runtime.Breakpoint()
-
-			var coef, prevCoef []float32
-			for l := len(nn.Layers) - 1; l >= 0; l-- {
-				layer := &nn.Layers[l]
-
-				coef = make([]float32, len(layer.Neurons))
-				for n := 0; n < len(layer.Neurons); n++ {
-					if l == len(nn.Layers)-1 {
-						coef[n] = Derivatives[layer.FunctionID](layer.Outputs[n]) * (outputs[i][n] - results[n])
-					} else {
-						var temp float32
-						nextLayer := &nn.Layers[l+1]
-						for i := 0; i < len(nextLayer.Neurons); i++ {
-							temp += prevCoef[i] * nextLayer.Neurons[i].Weights[n]
-						}
-						coef[n] = Derivatives[layer.FunctionID](layer.Outputs[n]) * temp
-					}
-
-					neuron := &layer.Neurons[n]
-					for w := 0; w < len(neuron.Weights); w++ {
-						currWeight := &neuron.Weights[w]
-						prevWeight := &neuron.PreviousWeights[w]
-
-						if l == 0 {
-							*currWeight += trainingRate*coef[n]*inputs[i][w] + 0.5*(*currWeight-*prevWeight)
-						} else {
-							prevLayer := &nn.Layers[l-1]
-							*currWeight += trainingRate*coef[n]*prevLayer.Outputs[w] + 0.5*(*currWeight-*prevWeight)
-						}
-
-						*prevWeight = *currWeight
-					}
-					neuron.Bias += trainingRate * coef[n]
-				}
-
-				prevCoef = coef
-			}
-		}
-
-		var mse float32
-		var count int
-		for i := int(float32(len(inputs)) * validationSplit); i < len(inputs); i++ {
-			results := nn.Query(inputs[i])
-			for j := 0; j < len(outputs[i]); j++ {
-				mse += (outputs[i][j] - results[j]) * (outputs[i][j] - results[j])
-				count++
-			}
-		}
-		mse /= float32(count)
-		fmt.Printf("Epoch %d: validation mse: %f", currentEpoch, mse)
-
-		if mse < minMSE {
-			nn.Store(NNFile)
-			minMSE = mse
-			fmt.Print(" (SAVED)")
-			countdown = 0
-		}
-		fmt.Println()
-
-		countdown++
-		if countdown > 10 {
-			nn.Load(NNFile)
-			break
-		}
-	}
-
-	return currentEpoch
-}
-
 func Fatalf(format string, args ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, args...)
 	os.Exit(1)
 }
 
-func GenerateTrainingDataRow(csvWriter *csv.Writer, row []string, basis [][]float32, ninputs int, i int, maxOffset float32) error {
-	var j int
-	for ; j < ninputs; j++ {
-		row[j] = strconv.FormatFloat(float64(basis[i][j]+maxOffset*rand.Float32()), 'f', 4, 32)
-	}
-
-	for ; j < len(basis[i]); j++ {
-		row[j] = strconv.Itoa(int(basis[i][j]))
-	}
-
-	if err := csvWriter.Write(row); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func GenerateTrainingData(trainingFilename string, basis [][]float32, maxOffset float32, ninputs, count int) error {
-	f, err := os.Create(trainingFilename)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	csvWriter := csv.NewWriter(f)
-	defer csvWriter.Flush()
-
-	row := make([]string, len(basis[0]))
-	for i := 0; i < len(basis); i++ {
-		if err := GenerateTrainingDataRow(csvWriter, row, basis, ninputs, i, maxOffset); err != nil {
-			return err
-		}
-	}
-
-	for k := 0; k < count-len(basis); k++ {
-		i := rand.Int() % len(basis)
-		if err := GenerateTrainingDataRow(csvWriter, row, basis, ninputs, i, maxOffset); err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
-func ReadTrainingData(trainingFile string) ([][]float32, error) {
-	f, err := os.Open(trainingFile)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	csvReader := csv.NewReader(f)
-	trainingStrings, err := csvReader.ReadAll()
-	if err != nil {
-		return nil, err
-	}
-
-	trainingData := make([][]float32, len(trainingStrings))
-	for i := 0; i < len(trainingData); i++ {
-		trainingData[i] = make([]float32, len(trainingStrings[i]))
-		for j := 0; j < len(trainingStrings[i]); j++ {
-			value, err := strconv.ParseFloat(strings.TrimSpace(trainingStrings[i][j]), 32)
-			if err != nil {
-				return nil, err
-			}
-			trainingData[i][j] = float32(value)
-		}
-	}
-
-	return trainingData, nil
-}
-
-func NormalizeTrainingData01(trainingData [][]float32) ([]float32, []float32) {
-	ninputs := len(trainingData[0])
-	minVector := make([]float32, ninputs)
-	maxVector := make([]float32, ninputs)
-
-	for j := 0; j < ninputs; j++ {
-		minVector[j] = trainingData[0][j]
-		maxVector[j] = trainingData[0][j]
-	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			minVector[j] = min(minVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-			maxVector[j] = max(maxVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-		}
-	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			trainingData[i][j] = (trainingData[i][j] - minVector[j]) / (maxVector[j] - minVector[j])
-			// trainingData[i][j] = (trainingData[i][j] - 0.5*(maxVector[j]+minVector[j])) / (0.5 * (maxVector[j] - minVector[j]))
-		}
-	}
-
-	return minVector, maxVector
-}
-
-func NormalizeTrainingData11(trainingData [][]float32) ([]float32, []float32) {
-	ninputs := len(trainingData[0])
-	minVector := make([]float32, ninputs)
-	maxVector := make([]float32, ninputs)
-
-	for j := 0; j < ninputs; j++ {
-		minVector[j] = trainingData[0][j]
-		maxVector[j] = trainingData[0][j]
-	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			minVector[j] = min(minVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-			maxVector[j] = max(maxVector[j], float32(math.Abs(float64(trainingData[i][j]))))
-		}
-	}
-
-	for i := 0; i < len(trainingData); i++ {
-		for j := 0; j < ninputs; j++ {
-			// trainingData[i][j] = (trainingData[i][j] - minVector[j]) / (maxVector[j] - minVector[j])
-			trainingData[i][j] = (trainingData[i][j] - 0.5*(maxVector[j]+minVector[j])) / (0.5 * (maxVector[j] - minVector[j]))
-		}
-	}
-
-	return minVector, maxVector
-}
-
 func EuclidianDistance(p1, p2 []float32) float32 {
 	var distance float32
 
@@ -464,75 +92,122 @@ func RemoveByValue(vs [][]float32, v []float32) [][]float32 {
 }
 
 func main() {
-	nn := NN{
-		Layers: []Layer{
-			{Neurons: make([]Neuron, 10), FunctionID: FunctionReLU},
-			// {Neurons: make([]Neuron, 10), FunctionID: FunctionSigmoid},
-			{Neurons: make([]Neuron, Ninputs), FunctionID: FunctionIdentity},
+	network := nn.NN{
+		Layers: []nn.Layer{
+			/* NOTE(anton2920): GRU reads Window previous rows one by one; it does slightly better than LSTM of the same size here, as well as than Conv1D in front of it or Conv1D with max pooling instead of it. ONNX export only supports dense layers. */
+			{Kind: nn.LayerGRU, Neurons: 16, FunctionID: nn.FunctionTh, Steps: Window, Initializer: nn.XavierUniform},
+			{Neurons: Ninputs, FunctionID: nn.FunctionIdentity},
 		},
+		/* NOTE(anton2920): dropout makes results worse for this task, L2 makes them slightly better. */
+		Regularization: nn.Regularization{L2: 1e-4, MaxGradNorm: 1},
 	}
 
-	trainingData, err := ReadTrainingData(TrainingFile)
+	trainingData, err := nn.ReadTrainingData(TrainingFile)
 	if err != nil {
 		Fatalf("Failed to read training data: %s\n", err.Error())
 	}
-	nn.MinVector, nn.MaxVector = NormalizeTrainingData01(trainingData)
-
-	trainingSplit := int(0.8 * float32(len(trainingData)))
-	inputs := trainingData[:trainingSplit-1]
-	outputs := trainingData[1:trainingSplit]
+	/* NOTE(anton2920): windows are copies of rows, so training data stays in original units for baselines. */
+	dataset, err := nn.Windows(trainingData, &nn.WindowOptions{Lookback: Window, Horizon: 1})
+	if err != nil {
+		Fatalf("Failed to make windows of training data: %s\n", err.Error())
+	}
 
-	testInputs := trainingData[trainingSplit : len(trainingData)-1]
-	testOutputs := trainingData[trainingSplit+1:]
+	network.InputScaler = nn.NewScaler(nn.ScalerMinMax01)
+	if err := network.InputScaler.FitTransform(dataset.Inputs); err != nil {
+		Fatalf("Failed to scale training data: %s\n", err.Error())
+	}
+	network.OutputScaler = nn.NewScaler(nn.ScalerMinMax01)
+	if err := network.OutputScaler.FitTransform(dataset.Outputs); err != nil {
+		Fatalf("Failed to scale training data: %s\n", err.Error())
+	}
 
-	fmt.Printf("Trained after %d epochs\n", nn.Train(inputs, outputs, 0.2, 0.01, 500))
+	/* NOTE(anton2920): NN predicts the next row from the previous ones, so data must not be shuffled. */
+	training, _, test, err := dataset.Split(&nn.SplitOptions{TestSplit: 0.2})
+	if err != nil {
+		Fatalf("Failed to split training data: %s\n", err.Error())
+	}
+	testInputs, testOutputs := test.Inputs, test.Outputs
+
+	epochs, err := network.TrainValidate(training.Inputs, training.Outputs, &nn.TrainOptions{
+		Optimizer:       nn.NewSGD(0.01),
+		BatchSize:       1,
+		MaxEpochs:       500,
+		ValidationSplit: ValidationSplit,
+		Patience:        10,
+		CheckpointFile:  NNFile,
+		Callback: func(metrics nn.EpochMetrics) error {
+			fmt.Printf("Epoch %d: training loss: %f, validation loss: %f", metrics.Epoch, metrics.TrainingLoss, metrics.ValidationLoss)
+			if metrics.Improved {
+				fmt.Print(" (SAVED)")
+			}
+			fmt.Println()
+			return nil
+		},
+	})
+	if err != nil {
+		Fatalf("Failed to train NN: %s\n", err.Error())
+	}
+	fmt.Printf("Trained after %d epochs\n", epochs)
 
-	denormOutputs := make([]float32, len(testOutputs[0]))
-	var mse, mae float32
-	var count int
-	for i := 0; i < len(testInputs); i++ {
-		results := nn.Query(testInputs[i])
+	if err := network.StoreJSON(JSONFile); err != nil {
+		Fatalf("Failed to export NN to JSON: %s\n", err.Error())
+	}
 
-		for j := 0; j < len(testOutputs[i]); j++ {
-			/* NOTE(anton2920: denormalizing from [0; 1]. */
-			denormOutputs[j] = testOutputs[i][j]*(nn.MaxVector[j]-nn.MinVector[j]) + nn.MinVector[j]
-			results[j] = results[j]*(nn.MaxVector[j]-nn.MinVector[j]) + nn.MinVector[j]
+	predictions := network.QueryAll(testInputs)
+	expected := make([][]float32, len(testOutputs))
+	for i := 0; i < len(testOutputs); i++ {
+		expected[i] = append([]float32(nil), testOutputs[i]...)
+	}
+	network.OutputScaler.InverseTransform(predictions)
+	network.OutputScaler.InverseTransform(expected)
 
-			/* NOTE(anton2920): denormalizing from [-1; 1]. */
-			/*denormOutputs[j] = testOutputs[i][j]*0.5*(nn.MaxVector[j]-nn.MinVector[j]) + 0.5*(nn.MaxVector[j]+nn.MinVector[j])
-			results[j] = results[j]*0.5*(nn.MaxVector[j]-nn.MinVector[j]) + 0.5*(nn.MaxVector[j]+nn.MinVector[j])*/
+	metrics := nn.Regression(predictions, expected)
+	if err := metrics.WriteText(os.Stdout); err != nil {
+		Fatalf("Failed to write metrics: %s\n", err.Error())
+	}
 
-			mae += float32(math.Abs(float64(denormOutputs[j] - results[j])))
-			mse += (denormOutputs[j] - results[j]) * (denormOutputs[j] - results[j])
-			count++
+	/* NOTE(anton2920): baselines look a period back, their last predictions are for the same rows as test samples. */
+	for kind, period := range [...]int{nn.BaselineNaive: 1, nn.BaselineSeasonalNaive: Period, nn.BaselineMovingAverage: Window} {
+		baseline, err := nn.Baseline(kind, trainingData, &nn.WindowOptions{Lookback: Period, Horizon: 1}, period)
+		if err != nil {
+			Fatalf("Failed to compute %s baseline: %s\n", nn.BaselineNames[kind], err.Error())
 		}
+		metrics := nn.Regression(baseline[len(baseline)-len(expected):], expected)
+		fmt.Printf("Baseline %s: MSE %f, MAE %f\n", nn.BaselineNames[kind], metrics.MSE, metrics.MAE)
 	}
-	mae /= float32(count)
-	mse /= float32(count)
-	fmt.Printf("Mean squarred error: %f. Mean absolute error: %f\n", mse, mae)
 
 	for i := 0; i < 10; i++ {
 		index := 10 * i
-		results := nn.Query(testInputs[index])
-
-		mae = 0
-		mse = 0
-		count = 0
-		for j := 0; j < len(testOutputs[index]); j++ {
-			/* NOTE(anton2920: denormalizing from [0; 1]. */
-			denormOutputs[j] = testOutputs[index][j]*(nn.MaxVector[j]-nn.MinVector[j]) + nn.MinVector[j]
-			results[j] = results[j]*(nn.MaxVector[j]-nn.MinVector[j]) + nn.MinVector[j]
+		sample := nn.Regression(predictions[index:index+1], expected[index:index+1])
+		fmt.Printf("Expected results: %f; actual results: %f (mse=%f, mae=%f)\n", expected[index], predictions[index], sample.MSE, sample.MAE)
+	}
 
-			/* NOTE(anton2920): denormalizing from [-1; 1]. */
-			/*denormOutputs[j] = testOutputs[index][j]*0.5*(nn.MaxVector[j]-nn.MinVector[j]) + 0.5*(nn.MaxVector[j]+nn.MinVector[j])
-			results[j] = results[j]*0.5*(nn.MaxVector[j]-nn.MinVector[j]) + 0.5*(nn.MaxVector[j]+nn.MinVector[j])*/
+	/* NOTE(anton2920): residuals come from the part of training samples TrainValidate validated on, so NN has not fitted them. Sample i predicts row i+Window. */
+	forecaster := nn.NewForecaster(&network, &nn.ForecastOptions{Kind: nn.ForecastRecursive, Steps: Steps, Window: nn.WindowOptions{Lookback: Window, Horizon: 1}, Level: Level, Samples: Samples, Seed: nn.Seed})
+	validationStart := nn.TrainingSamples(training.Len(), ValidationSplit)
+	if err := forecaster.Fit(trainingData[validationStart : training.Len()+Window]); err != nil {
+		Fatalf("Failed to fit forecaster: %s\n", err.Error())
+	}
 
-			mae += float32(math.Abs(float64(denormOutputs[j] - results[j])))
-			mse += (denormOutputs[j] - results[j]) * (denormOutputs[j] - results[j])
-			count++
+	var covered, total int
+	for index := 0; index+Steps <= test.Len(); index += 10 {
+		history := trainingData[:training.Len()+index+Window]
+		forecast, err := forecaster.Forecast(history)
+		if err != nil {
+			Fatalf("Failed to forecast: %s\n", err.Error())
+		}
+		for s := 0; s < Steps; s++ {
+			actual := trainingData[len(history)+s]
+			for j := 0; j < len(actual); j++ {
+				if (actual[j] >= forecast.Lower[s][j]) && (actual[j] <= forecast.Upper[s][j]) {
+					covered++
+				}
+				total++
+			}
+			if index == 0 {
+				fmt.Printf("Step %d: expected results: %f; forecast: %f, %g%% interval: %f - %f\n", s+1, actual, forecast.Predictions[s], 100*Level, forecast.Lower[s], forecast.Upper[s])
+			}
 		}
-		mae /= float32(count)
-		mse /= float32(count)
-		fmt.Printf("Expected results: %f; actual results: %f (mse=%f, mae=%f)\n", denormOutputs, results, mse, mae)
 	}
+	fmt.Printf("Coverage of %g%% intervals %d steps ahead: %.2f%%\n", 100*Level, Steps, 100*float32(covered)/float32(total))
 }